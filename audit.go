@@ -0,0 +1,48 @@
+package sqlitebp
+
+import (
+	"time"
+)
+
+// OpenEvent describes one open of a database, see WithAuditHook.
+type OpenEvent struct {
+	Identity string // set with WithAuditIdentity, "" if none
+	Filename string
+	Mode     Mode
+	// Settings holds the DSN parameters (e.g. _busy_timeout, _txlock) and the pragmas
+	// (e.g. journal_mode, temp_store) applied to each connection. Values of sensitive
+	// names (keys, passwords, see WithRedactedParams) are replaced by "[REDACTED]".
+	Settings map[string]string
+	Time     time.Time // when the open finished
+	Err      error     // nil if the open succeeded; secrets are redacted as in Settings
+}
+
+// audit reports the open of cfg.filename in mode, which failed with err if not nil, to
+// the WithAuditHook callback.
+func (cfg *openConfig) audit(mode Mode, err error) {
+	settings := make(map[string]string, len(cfg.params)+len(cfg.pragmas))
+	for k, v := range cfg.params {
+		if k == "mode" {
+			continue
+		}
+		if cfg.sensitive(k) {
+			v = "[REDACTED]"
+		}
+		settings[k] = v
+	}
+	for _, p := range cfg.pragmas {
+		v := p.value
+		if cfg.sensitive(p.name) {
+			v = "[REDACTED]"
+		}
+		settings[p.name] = v
+	}
+	cfg.auditHook(OpenEvent{
+		Identity: cfg.auditIdentity,
+		Filename: cfg.filename,
+		Mode:     mode,
+		Settings: settings,
+		Time:     time.Now(),
+		Err:      err,
+	})
+}
@@ -0,0 +1,72 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithAuditHook(t *testing.T) {
+	dir := t.TempDir()
+	var events []OpenEvent
+	audit := WithAuditHook(func(event OpenEvent) { events = append(events, event) })
+	start := time.Now()
+
+	a := filepath.Join(dir, "a.db")
+	db, err := OpenReadWriteCreate(a, audit, WithAuditIdentity("billing-job"), WithPragma("key", "'s3cret'"))
+	if err != nil {
+		t.Fatalf("open a: %v", err)
+	}
+	db.Close()
+	db, err = OpenReadOnly(a, audit, WithSynchronous("FULL"))
+	if err != nil {
+		t.Fatalf("open a read-only: %v", err)
+	}
+	db.Close()
+	missing := filepath.Join(dir, "missing.db")
+	if _, err := OpenReadWrite(missing, audit); err == nil {
+		t.Fatal("expected opening a missing file to fail")
+	}
+	// Invalid options are rejected before the open is attempted.
+	if _, err := OpenReadWrite(a, audit, WithSynchronous("SOMETIMES")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	for i, want := range []struct {
+		identity, filename string
+		mode               Mode
+		failed             bool
+	}{
+		{"billing-job", a, ModeReadWriteCreate, false},
+		{"", a, ModeReadOnly, false},
+		{"", missing, ModeReadWrite, true},
+	} {
+		got := events[i]
+		if got.Identity != want.identity || got.Filename != want.filename || got.Mode != want.mode || (got.Err != nil) != want.failed {
+			t.Errorf("event %d = %+v, want %+v", i, got, want)
+		}
+		if got.Time.Before(start) || got.Time.After(time.Now()) {
+			t.Errorf("event %d time %v out of range", i, got.Time)
+		}
+	}
+	if s := events[0].Settings; s["key"] != "[REDACTED]" || s["journal_mode"] != "WAL" || s["_txlock"] != "immediate" {
+		t.Errorf("read/write settings = %v", s)
+	}
+	for k, v := range events[0].Settings {
+		if strings.Contains(v, "s3cret") {
+			t.Errorf("setting %s leaks the key: %q", k, v)
+		}
+	}
+	s := events[1].Settings
+	if _, ok := s["_txlock"]; ok || s["_synchronous"] != "FULL" {
+		t.Errorf("read-only settings = %v", s)
+	}
+	if _, ok := s["mode"]; ok {
+		t.Errorf("mode duplicated in settings: %v", s)
+	}
+}
@@ -0,0 +1,131 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Blob streams a single BLOB value with SQLite's incremental I/O, without loading the
+// whole value into memory. It holds a pooled connection until Close.
+//
+// The size of a blob is fixed: writes cannot extend it, so reserve space first with
+// zeroblob(N) in the INSERT or UPDATE. Any change to the row through another statement
+// invalidates the handle and later calls fail. A Blob is not safe for concurrent use.
+type Blob struct {
+	conn     *sql.Conn
+	blob     blobHandle
+	size     int64
+	offset   int64
+	writable bool
+}
+
+var _ io.ReadWriteSeeker = (*Blob)(nil)
+
+// OpenBlob opens the value of column in the row with the given rowid of table in database
+// ("main" for the primary database, or the name of an attached one). The handle keeps a
+// connection checked out of db's pool until it is closed. WITHOUT ROWID tables have no
+// rowid to address and fail with ErrWithoutRowID.
+func OpenBlob(ctx context.Context, db *sql.DB, database, table, column string, rowid int64, writable bool) (*Blob, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite3_blob_open reports WITHOUT ROWID tables only as a generic SQL error.
+	if ok, err := hasRowID(ctx, conn, database, table); err != nil || !ok {
+		conn.Close()
+		if err == nil {
+			err = errors.Join(ErrWithoutRowID, fmt.Errorf("table %s.%s has no rowid", database, table))
+		}
+		return nil, fmt.Errorf("sqlitebp: failed to open blob %s.%s.%s row %d: %w", database, table, column, rowid, err)
+	}
+	b := &Blob{conn: conn, writable: writable}
+	err = conn.Raw(func(dc any) error {
+		c, ok := unwrapConn(dc)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection type %T", dc)
+		}
+		blob, err := blobOpen(c, database, table, column, rowid, writable)
+		b.blob = blob
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sqlitebp: failed to open blob %s.%s.%s row %d: %w", database, table, column, rowid, err)
+	}
+	b.size = blobSize(b.blob)
+	return b, nil
+}
+
+// Size returns the size of the blob in bytes.
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
+// Read implements io.Reader.
+func (b *Blob) Read(p []byte) (int, error) {
+	if b.blob == nil {
+		return 0, errBlobClosed
+	}
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), b.size-b.offset))
+	if err := blobRead(b.blob, p[:n], b.offset); err != nil {
+		return 0, err
+	}
+	b.offset += int64(n)
+	return n, nil
+}
+
+// Write implements io.Writer. Writing past the end of the blob writes what fits and
+// returns io.ErrShortWrite.
+func (b *Blob) Write(p []byte) (int, error) {
+	if b.blob == nil {
+		return 0, errBlobClosed
+	}
+	if !b.writable {
+		return 0, errors.New("sqlitebp: blob opened read-only")
+	}
+	n := int(max(0, min(int64(len(p)), b.size-b.offset)))
+	if err := blobWrite(b.blob, p[:n], b.offset); err != nil {
+		return 0, err
+	}
+	b.offset += int64(n)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker. Offsets beyond the end are allowed; reads there return io.EOF.
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, fmt.Errorf("sqlitebp: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("sqlitebp: negative blob offset")
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+// Close closes the blob and returns its connection to the pool. Closing twice is a no-op.
+func (b *Blob) Close() error {
+	if b.blob == nil {
+		return nil
+	}
+	err := blobClose(b.blob)
+	b.blob = nil
+	return errors.Join(err, b.conn.Close())
+}
+
+var errBlobClosed = errors.New("sqlitebp: blob is closed")
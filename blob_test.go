@@ -0,0 +1,75 @@
+package sqlitebp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenBlob_StreamsLargeValue(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "blob.db"), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	const size = 4 << 20
+	res, err := db.Exec("CREATE TABLE files (id INTEGER PRIMARY KEY, data BLOB); INSERT INTO files (data) VALUES (zeroblob(?))", size)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rowid, _ := res.LastInsertId()
+
+	w, err := OpenBlob(ctx, db, "main", "files", "data", rowid, true)
+	if err != nil {
+		t.Fatalf("open writable: %v", err)
+	}
+	if w.Size() != size {
+		t.Fatalf("size=%d want %d", w.Size(), size)
+	}
+	written := sha256.New()
+	chunk := make([]byte, 64<<10)
+	for i := 0; i < size/len(chunk); i++ {
+		rand.Read(chunk)
+		written.Write(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write chunk %d: %v", i, err)
+		}
+	}
+	if _, err := w.Write([]byte{1}); err != io.ErrShortWrite {
+		t.Fatalf("write past end: err=%v want io.ErrShortWrite", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writable: %v", err)
+	}
+
+	r, err := OpenBlob(ctx, db, "main", "files", "data", rowid, false)
+	if err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	read := sha256.New()
+	if n, err := io.CopyBuffer(read, r, make([]byte, 100_000)); err != nil || n != size {
+		t.Fatalf("read n=%d err=%v want %d", n, err, size)
+	}
+	if string(read.Sum(nil)) != string(written.Sum(nil)) {
+		t.Fatalf("checksum mismatch")
+	}
+	if _, err := r.Write([]byte{1}); err == nil {
+		t.Fatalf("expected write to read-only blob to fail")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// The single pooled connection was returned.
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT length(data) FROM files").Scan(&n); err != nil || n != size {
+		t.Fatalf("length=%d err=%v", n, err)
+	}
+	if _, err := OpenBlob(ctx, db, "main", "files", "data", rowid+1, false); err == nil {
+		t.Fatalf("expected error for missing row")
+	}
+}
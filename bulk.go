@@ -0,0 +1,76 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// bulkLoadProfile trades durability for import speed. A crash during the load can corrupt
+// the database, which is acceptable for an initial import that can simply be restarted.
+var bulkLoadProfile = []Option{
+	WithSynchronous("OFF"),
+	WithCacheSizeMiB(256),
+	WithSecureDelete("OFF"),
+	// Let the WAL grow for the whole load and checkpoint once in Finish.
+	WithPragma("wal_autocheckpoint", "0"),
+	// A single connection; concurrent writers would only contend for the write lock.
+	WithMaxOpenConns(1),
+}
+
+// BulkLoader is a database handle configured for a large initial import. Load data through
+// the embedded *sql.DB, then call Finish to obtain a normally configured handle.
+type BulkLoader struct {
+	*sql.DB
+
+	filename string
+	opts     []Option
+}
+
+// OpenBulkLoad opens or creates filename with an aggressive bulk load profile: synchronous=OFF,
+// a 256 MiB page cache, secure_delete off, automatic checkpoints disabled and a single
+// connection. opts are applied both to the bulk load handle and to the handle returned by
+// Finish; they must not set synchronous, cache size, secure_delete, wal_autocheckpoint or
+// the pool size, which the profile controls.
+//
+// Never use a BulkLoader for data that cannot be re-imported: a crash or power loss during
+// the load can corrupt the database.
+func OpenBulkLoad(filename string, opts ...Option) (*BulkLoader, error) {
+	db, err := openWithMode(filename, ModeReadWriteCreate, append(append([]Option{}, opts...), bulkLoadProfile...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &BulkLoader{DB: db, filename: filename, opts: opts}, nil
+}
+
+// Finish ends the bulk load: it runs ANALYZE if analyze is set, checkpoints and truncates the
+// WAL, closes the bulk load handle and reopens the database with the default durability
+// settings plus the options given to OpenBulkLoad. The BulkLoader must not be used afterwards.
+func (b *BulkLoader) Finish(ctx context.Context, analyze bool) (*sql.DB, error) {
+	if analyze {
+		if _, err := b.DB.ExecContext(ctx, "ANALYZE"); err != nil {
+			b.DB.Close()
+			return nil, fmt.Errorf("sqlitebp: bulk load analyze failed: %w", err)
+		}
+	}
+	// synchronous=OFF skipped every fsync; the checkpoint copies the WAL into the database
+	// and syncs it, so the loaded data is durable before normal operation starts.
+	if _, err := b.DB.ExecContext(ctx, "PRAGMA synchronous=FULL"); err != nil {
+		b.DB.Close()
+		return nil, errors.Join(ErrPragmaExec, fmt.Errorf("failed to restore synchronous: %w", err))
+	}
+	var busy, logPages, checkpointed int
+	if err := b.DB.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logPages, &checkpointed); err != nil {
+		b.DB.Close()
+		return nil, fmt.Errorf("sqlitebp: bulk load checkpoint failed: %w", err)
+	}
+	if busy != 0 {
+		b.DB.Close()
+		return nil, fmt.Errorf("sqlitebp: bulk load checkpoint of %q blocked by another connection", b.filename)
+	}
+	if err := b.DB.Close(); err != nil {
+		return nil, err
+	}
+	return openWithMode(b.filename, ModeReadWrite, b.opts...)
+}
@@ -0,0 +1,63 @@
+package sqlitebp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBulkLoader_RestoresDurabilityOnFinish(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "bulk.db")
+	loader, err := OpenBulkLoad(filename)
+	if err != nil {
+		t.Fatalf("open bulk load: %v", err)
+	}
+	var synchronous, autocheckpoint int
+	if err := loader.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil || synchronous != 0 {
+		t.Fatalf("bulk synchronous=%d err=%v want 0 (OFF)", synchronous, err)
+	}
+	if err := loader.QueryRow("PRAGMA wal_autocheckpoint").Scan(&autocheckpoint); err != nil || autocheckpoint != 0 {
+		t.Fatalf("bulk wal_autocheckpoint=%d err=%v want 0", autocheckpoint, err)
+	}
+
+	tx, err := loader.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 20000; i++ {
+		if _, err := tx.Exec("INSERT INTO items (name) VALUES (?)", "item"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	db, err := loader.Finish(ctx, true)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items").Scan(&n); err != nil || n != 20000 {
+		t.Fatalf("count=%d err=%v want 20000", n, err)
+	}
+	if err := db.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil || synchronous != 1 {
+		t.Fatalf("synchronous=%d err=%v want 1 (NORMAL)", synchronous, err)
+	}
+	if err := db.QueryRow("PRAGMA wal_autocheckpoint").Scan(&autocheckpoint); err != nil || autocheckpoint != 1000 {
+		t.Fatalf("wal_autocheckpoint=%d err=%v want 1000", autocheckpoint, err)
+	}
+	var stats int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_stat1").Scan(&stats); err != nil || stats == 0 {
+		t.Fatalf("sqlite_stat1 rows=%d err=%v, want ANALYZE results", stats, err)
+	}
+	if info, err := os.Stat(filename + "-wal"); err == nil && info.Size() != 0 {
+		t.Fatalf("WAL size=%d after Finish, want truncated", info.Size())
+	}
+}
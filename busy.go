@@ -0,0 +1,145 @@
+package sqlitebp
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// BusyCause is where the lock behind a busy error appears to be held, see BusyEvent.
+type BusyCause int
+
+const (
+	// BusyInProcess means another connection of the same handle was in a transaction
+	// or reading when the statement gave up, or SQLite reported SQLITE_LOCKED, which
+	// only connections of one process cause. Serializing writes, e.g. through a single
+	// connection pool for writes, avoids it.
+	BusyInProcess BusyCause = iota + 1
+	// BusyExternal means no connection of the handle was, so the lock is held by
+	// another handle or process. Retrying, or a longer busy timeout, is the remedy.
+	BusyExternal
+)
+
+// String returns "in-process" or "external".
+func (c BusyCause) String() string {
+	switch c {
+	case BusyInProcess:
+		return "in-process"
+	case BusyExternal:
+		return "external"
+	}
+	return "unknown"
+}
+
+// BusyEvent describes a statement that failed with SQLITE_BUSY or SQLITE_LOCKED, see
+// WithBusyDiagnostics.
+type BusyEvent struct {
+	Time      time.Time
+	Statement string // the SQL, "BEGIN" or "COMMIT"
+	Err       error
+	Stats     sql.DBStats // of the handle, when the error was returned
+	Cause     BusyCause
+	// Holders is the number of other connections of the handle that were in a
+	// transaction or had rows open.
+	Holders int
+	// LockStatus maps each database to its lock (e.g. "main": "reserved") as reported
+	// by PRAGMA lock_status, which only builds with SQLITE_DEBUG or SQLITE_TEST have;
+	// nil otherwise.
+	LockStatus map[string]string
+}
+
+// busyDiagnostics is the state of WithBusyDiagnostics, shared by a handle's connections.
+type busyDiagnostics struct {
+	sink func(event BusyEvent)
+	db   atomic.Pointer[sql.DB] // set once opened
+
+	mu    sync.Mutex
+	conns map[*sqliteConn]struct{} // open connections
+}
+
+func (b *busyDiagnostics) add(c *sqliteConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conns == nil {
+		b.conns = make(map[*sqliteConn]struct{})
+	}
+	b.conns[c] = struct{}{}
+}
+
+// remove must be called before c is closed; holders reads the state of the others.
+func (b *busyDiagnostics) remove(c *sqliteConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.conns, c)
+}
+
+// holders counts the connections other than c that are in a transaction or have rows
+// open, i.e. may hold a lock.
+func (b *busyDiagnostics) holders(c *sqliteConn) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for other := range b.conns {
+		if other != c && (!other.AutoCommit() || other.openRows.Load() > 0) {
+			n++
+		}
+	}
+	return n
+}
+
+// report sends an event to the sink if err is a busy or locked error of query on c.
+func (b *busyDiagnostics) report(c *sqliteConn, query string, err error) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrBusy && sqliteErr.Code != sqlite3.ErrLocked {
+		return
+	}
+	event := BusyEvent{
+		Time:       time.Now(),
+		Statement:  query,
+		Err:        err,
+		Holders:    b.holders(c),
+		LockStatus: lockStatus(c.SQLiteConn),
+		Cause:      BusyExternal,
+	}
+	if event.Holders > 0 || sqliteErr.Code == sqlite3.ErrLocked {
+		event.Cause = BusyInProcess
+	}
+	if db := b.db.Load(); db != nil {
+		event.Stats = db.Stats()
+	}
+	b.sink(event)
+}
+
+// lockStatus returns the result of PRAGMA lock_status, nil if the build lacks it (the
+// unknown pragma returns no rows) or it fails.
+func lockStatus(conn *sqlite3.SQLiteConn) map[string]string {
+	rows, err := conn.Query("PRAGMA lock_status", nil)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var status map[string]string
+	dest := make([]driver.Value, 2)
+	for rows.Next(dest) == nil {
+		if status == nil {
+			status = make(map[string]string)
+		}
+		status[valueString(dest[0])] = valueString(dest[1])
+	}
+	return status
+}
+
+func valueString(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
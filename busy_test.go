@@ -0,0 +1,73 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestWithBusyDiagnostics(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	var events []BusyEvent
+	db, err := OpenReadWriteCreate(filename, WithBusyTimeoutSeconds(0),
+		WithBusyDiagnostics(func(event BusyEvent) { events = append(events, event) }))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (v INTEGER)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Another connection of the pool holds the write lock.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	const insert = "INSERT INTO t VALUES (1)"
+	var sqliteErr sqlite3.Error
+	if _, err := db.Exec(insert); !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrBusy {
+		t.Fatalf("expected SQLITE_BUSY, got %v", err)
+	}
+	tx.Rollback()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Cause != BusyInProcess || event.Holders != 1 || event.Statement != insert || event.Err == nil {
+		t.Errorf("event = %+v, want an in-process cause", event)
+	}
+	if event.Stats.InUse != 2 {
+		t.Errorf("stats report %d connections in use, want 2", event.Stats.InUse)
+	}
+
+	// A separate handle holds it.
+	other, err := OpenReadWrite(filename)
+	if err != nil {
+		t.Fatalf("open other: %v", err)
+	}
+	defer other.Close()
+	otherTx, err := other.Begin()
+	if err != nil {
+		t.Fatalf("begin other: %v", err)
+	}
+	defer otherTx.Rollback()
+	if _, err := db.Exec(insert); err == nil {
+		t.Fatal("expected the insert to fail")
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if event := events[1]; event.Cause != BusyExternal || event.Holders != 0 {
+		t.Errorf("event = %+v, want an external cause", event)
+	}
+	if event.Cause.String() != "in-process" || events[1].Cause.String() != "external" {
+		t.Errorf("causes print as %s and %s", event.Cause, events[1].Cause)
+	}
+
+	if _, err := OpenReadWrite(filename, WithBusyDiagnostics(nil)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a nil sink, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package sqlitebp
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// ErrNotCached indicates CloseCached was given a handle that OpenCached did not return,
+// or one whose last reference was already closed.
+var ErrNotCached = errors.New("sqlitebp: handle not opened by OpenCached")
+
+// cachedHandle is a handle shared by the OpenCached calls with the same key. ready is
+// closed once the open finished, setting db or err.
+type cachedHandle struct {
+	key   string
+	db    *sql.DB
+	err   error
+	refs  int
+	ready chan struct{}
+}
+
+var handleCache = struct {
+	sync.Mutex
+	byKey map[string]*cachedHandle
+	byDB  map[*sql.DB]*cachedHandle
+}{
+	byKey: map[string]*cachedHandle{},
+	byDB:  map[*sql.DB]*cachedHandle{},
+}
+
+// OpenCached is OpenWithConfig with the handle shared across calls: while a handle opened
+// for the same file, mode and settings is still in use, it is returned again instead of
+// opening another pool, which saves the open, pragma and ping round trip and the driver
+// registration each open makes. Every successful call must be matched by a CloseCached,
+// which closes the handle once its last user is done; do not call Close on it directly.
+//
+// Callers share the pool, including its settings made after the open (SetMaxOpenConns
+// and the like). Config callback fields cannot be compared, so a cfg that sets any is
+// rejected.
+func OpenCached(filename string, mode Mode, cfg Config) (*sql.DB, error) {
+	key, err := cacheKey(filename, mode, cfg)
+	if err != nil {
+		return nil, err
+	}
+	handleCache.Lock()
+	h := handleCache.byKey[key]
+	if h != nil {
+		h.refs++
+		handleCache.Unlock()
+		<-h.ready
+		return h.db, h.err
+	}
+	h = &cachedHandle{key: key, refs: 1, ready: make(chan struct{})}
+	handleCache.byKey[key] = h
+	handleCache.Unlock()
+
+	db, err := OpenWithConfig(filename, mode, cfg)
+	handleCache.Lock()
+	h.db, h.err = db, err
+	if err != nil {
+		// Waiting callers get the error too; the next call tries again.
+		delete(handleCache.byKey, key)
+	} else {
+		handleCache.byDB[db] = h
+	}
+	handleCache.Unlock()
+	close(h.ready)
+	return db, err
+}
+
+// CloseCached releases a handle returned by OpenCached, closing it when no other caller
+// still uses it.
+func CloseCached(db *sql.DB) error {
+	handleCache.Lock()
+	h := handleCache.byDB[db]
+	if h == nil {
+		handleCache.Unlock()
+		return ErrNotCached
+	}
+	h.refs--
+	if h.refs > 0 {
+		handleCache.Unlock()
+		return nil
+	}
+	delete(handleCache.byDB, db)
+	delete(handleCache.byKey, h.key)
+	handleCache.Unlock()
+	return db.Close()
+}
+
+// cacheKey identifies the handle OpenCached opens for filename, mode and cfg.
+func cacheKey(filename string, mode Mode, cfg Config) (string, error) {
+	if filename == "" {
+		return "", ErrEmptyFilename
+	}
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("json") == "-" && !v.Field(i).IsZero() {
+			return "", errors.Join(ErrInvalidConfigOption, fmt.Errorf("%s cannot be used with OpenCached", v.Type().Field(i).Name))
+		}
+	}
+	settings, err := json.Marshal(cfg)
+	if err != nil {
+		return "", errors.Join(ErrInvalidConfigOption, err)
+	}
+	// The same file may be named by different paths.
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	sum := sha256.Sum256(settings)
+	return fmt.Sprintf("%s\x00%s\x00%s", filename, mode, hex.EncodeToString(sum[:])), nil
+}
@@ -0,0 +1,64 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenCached(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "tenant.db")
+	cfg := Config{CacheSizeMiB: 4}
+	a, err := OpenCached(fn, ModeReadWriteCreate, cfg)
+	if err != nil {
+		t.Fatalf("open a: %v", err)
+	}
+	b, err := OpenCached(fn, ModeReadWriteCreate, cfg)
+	if err != nil {
+		t.Fatalf("open b: %v", err)
+	}
+	if a != b {
+		t.Fatal("expected both calls to share the handle")
+	}
+	// Different settings or modes get their own handle.
+	other, err := OpenCached(fn, ModeReadWriteCreate, Config{CacheSizeMiB: 8})
+	if err != nil {
+		t.Fatalf("open other: %v", err)
+	}
+	if other == a {
+		t.Fatal("expected different settings to get a separate handle")
+	}
+	if err := CloseCached(other); err != nil {
+		t.Fatalf("close other: %v", err)
+	}
+
+	if err := CloseCached(a); err != nil {
+		t.Fatalf("close a: %v", err)
+	}
+	if err := b.Ping(); err != nil {
+		t.Fatalf("handle closed while still referenced: %v", err)
+	}
+	if err := CloseCached(b); err != nil {
+		t.Fatalf("close b: %v", err)
+	}
+	if err := b.Ping(); err == nil {
+		t.Fatal("expected the handle to be closed after the last CloseCached")
+	}
+	if err := CloseCached(b); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("expected ErrNotCached, got %v", err)
+	}
+
+	// Once closed, the next call opens a new handle.
+	c, err := OpenCached(fn, ModeReadWriteCreate, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if c == a {
+		t.Fatal("expected a new handle after the last close")
+	}
+	CloseCached(c)
+
+	if _, err := OpenCached(fn, ModeReadWrite, Config{OnConnect: func(int) {}}); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a callback, got %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package sqlitebp
+
+// The go-sqlite3 driver compiles the SQLite amalgamation into the binary but does not
+// expose every C API. The declarations below bind directly to those symbols for the
+// few features the driver does not wrap. Only the prototypes we need are declared.
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_blob sqlite3_blob;
+
+extern void *sqlite3_wal_hook(sqlite3*, int(*)(void*,sqlite3*,const char*,int), void*);
+extern int sqlite3_wal_checkpoint_v2(sqlite3*, const char*, int, int*, int*);
+
+extern int sqlite3_config(int, ...);
+extern long long sqlite3_memory_used(void);
+extern long long sqlite3_memory_highwater(int);
+extern void sqlite3_progress_handler(sqlite3*, int, int(*)(void*), void*);
+extern void sqlite3_interrupt(sqlite3*);
+extern int sqlite3_file_control(sqlite3*, const char*, int, void*);
+extern int sqlite3_busy_handler(sqlite3*, int(*)(void*,int), void*);
+
+extern const char *sqlite3_errmsg(sqlite3*);
+extern const char *sqlite3_errstr(int);
+extern void sqlite3_log(int, const char*, ...);
+extern int sqlite3_blob_open(sqlite3*, const char*, const char*, const char*, long long, int, sqlite3_blob**);
+extern int sqlite3_blob_read(sqlite3_blob*, void*, int, int);
+extern int sqlite3_blob_write(sqlite3_blob*, const void*, int, int);
+extern int sqlite3_blob_bytes(sqlite3_blob*);
+extern int sqlite3_blob_close(sqlite3_blob*);
+
+extern int goWALHook(uintptr_t, char*, int, int*);
+extern void goWALTruncated(uintptr_t, int, int);
+extern void goErrorLog(void*, int, char*);
+extern int goProgress(uintptr_t);
+extern int goBusy(uintptr_t, int);
+
+// Mirrors sqlite3WalDefaultHook: installing a WAL hook replaces auto-checkpointing,
+// so the bridge keeps the default 1000 page PASSIVE checkpoint after calling Go. When Go
+// reports the WAL over its size limit a TRUNCATE checkpoint (mode 3) runs instead.
+static int bp_wal_hook(void *arg, sqlite3 *db, const char *name, int pages) {
+	int truncate = 0;
+	int rc = goWALHook((uintptr_t)arg, (char*)name, pages, &truncate);
+	if (rc != 0) {
+		return rc;
+	}
+	if (truncate) {
+		goWALTruncated((uintptr_t)arg, pages, sqlite3_wal_checkpoint_v2(db, name, 3, 0, 0));
+	} else if (pages >= 1000) {
+		sqlite3_wal_checkpoint_v2(db, name, 0, 0, 0);
+	}
+	return 0;
+}
+
+static void bp_set_wal_hook(sqlite3 *db, uintptr_t handle) {
+	sqlite3_wal_hook(db, bp_wal_hook, (void*)handle);
+}
+
+static int bp_progress(void *arg) {
+	return goProgress((uintptr_t)arg);
+}
+
+static void bp_set_progress_handler(sqlite3 *db, int ops, uintptr_t handle) {
+	sqlite3_progress_handler(db, ops, bp_progress, (void*)handle);
+}
+
+static int bp_busy(void *arg, int count) {
+	return goBusy((uintptr_t)arg, count);
+}
+
+static void bp_set_busy_handler(sqlite3 *db, uintptr_t handle) {
+	sqlite3_busy_handler(db, bp_busy, (void*)handle);
+}
+
+static void bp_error_log(void *arg, int code, const char *msg) {
+	goErrorLog(arg, code, (char*)msg);
+}
+
+static void bp_log(int code, const char *msg) {
+	sqlite3_log(code, "%s", msg);
+}
+
+// SQLITE_CONFIG_SINGLETHREAD, _MULTITHREAD and _SERIALIZED (1-3) take no arguments.
+static int bp_config_threading(int op) {
+	return sqlite3_config(op);
+}
+
+// SQLITE_CONFIG_MMAP_SIZE is 22 and takes the default and maximum as sqlite3_int64.
+static int bp_config_mmap_size(long long def, long long max) {
+	return sqlite3_config(22, def, max);
+}
+
+// SQLITE_CONFIG_LOG is 16. It may be changed after sqlite3_initialize since 3.42.0.
+static int bp_set_error_log(void) {
+	return sqlite3_config(16, bp_error_log, (void*)0);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// rawConn returns the underlying sqlite3* handle of a driver connection.
+// go-sqlite3 keeps it in the unexported db field.
+func rawConn(conn *sqlite3.SQLiteConn) *C.sqlite3 {
+	return (*C.sqlite3)(unsafe.Pointer(reflect.ValueOf(conn).Elem().FieldByName("db").Pointer()))
+}
+
+//export goWALHook
+func goWALHook(handle C.uintptr_t, name *C.char, pages C.int, truncate *C.int) C.int {
+	rc, over := cgo.Handle(handle).Value().(*walHook).commit(C.GoString(name), int(pages))
+	if over {
+		*truncate = 1
+	}
+	return C.int(rc)
+}
+
+//export goWALTruncated
+func goWALTruncated(handle C.uintptr_t, pages, rc C.int) {
+	cgo.Handle(handle).Value().(*walHook).truncated(int(pages), int(rc))
+}
+
+// setWALHook installs the *walHook behind handle as the WAL hook of conn. The handle must
+// outlive the connection.
+func setWALHook(conn *sqlite3.SQLiteConn, handle cgo.Handle) {
+	C.bp_set_wal_hook(rawConn(conn), C.uintptr_t(handle))
+}
+
+//export goProgress
+func goProgress(handle C.uintptr_t) C.int {
+	if cgo.Handle(handle).Value().(func() bool)() {
+		return 1
+	}
+	return 0
+}
+
+// setProgressHandler installs the func() bool behind handle as the progress handler of conn,
+// invoked every ops virtual machine instructions. Returning true interrupts the statement.
+func setProgressHandler(conn *sqlite3.SQLiteConn, ops int, handle cgo.Handle) {
+	C.bp_set_progress_handler(rawConn(conn), C.int(ops), C.uintptr_t(handle))
+}
+
+//export goBusy
+func goBusy(handle C.uintptr_t, count C.int) C.int {
+	if cgo.Handle(handle).Value().(func(int) bool)(int(count)) {
+		return 1
+	}
+	return 0
+}
+
+// setBusyHandler installs the func(int) bool behind handle as the busy handler of conn,
+// replacing its busy timeout. It is called with the number of times it was already called
+// for the same lock; returning true retries, false gives up with SQLITE_BUSY.
+func setBusyHandler(conn *sqlite3.SQLiteConn, handle cgo.Handle) {
+	C.bp_set_busy_handler(rawConn(conn), C.uintptr_t(handle))
+}
+
+var (
+	errorLogOnce sync.Once
+	errorLogFunc func(code int, msg string)
+	errorLogErr  error
+)
+
+//export goErrorLog
+func goErrorLog(_ unsafe.Pointer, code C.int, msg *C.char) {
+	// Called by SQLite on any thread, possibly while holding internal mutexes;
+	// errorLogFunc must not call back into SQLite.
+	errorLogFunc(int(code), C.GoString(msg))
+}
+
+// registerErrorLog installs fn as the process-global SQLite error log callback.
+// Only the first registration takes effect; later calls return the first result.
+func registerErrorLog(fn func(code int, msg string)) error {
+	errorLogOnce.Do(func() {
+		errorLogFunc = fn
+		if rc := C.bp_set_error_log(); rc != 0 {
+			errorLogErr = fmt.Errorf("sqlite3_config(SQLITE_CONFIG_LOG) returned %d", int(rc))
+		}
+	})
+	return errorLogErr
+}
+
+// sqliteLog writes msg to SQLite's error log, which WithErrorLogCallback receives.
+func sqliteLog(code int, msg string) {
+	cMsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cMsg))
+	C.bp_log(C.int(code), cMsg)
+}
+
+// configThreading sets the global threading mode (SQLITE_CONFIG_MULTITHREAD etc.).
+func configThreading(op int) error {
+	return configError("threading mode", C.bp_config_threading(C.int(op)))
+}
+
+// configMMapSize sets the global default and maximum mmap_size.
+func configMMapSize(def, max int64) error {
+	return configError("mmap size", C.bp_config_mmap_size(C.longlong(def), C.longlong(max)))
+}
+
+// memoryUsed returns the bytes of memory SQLite currently has allocated.
+func memoryUsed() int64 {
+	return int64(C.sqlite3_memory_used())
+}
+
+// memoryHighwater returns the most memory SQLite has had allocated, then lowers the mark
+// to the current usage if reset is set.
+func memoryHighwater(reset bool) int64 {
+	var r C.int
+	if reset {
+		r = 1
+	}
+	return int64(C.sqlite3_memory_highwater(r))
+}
+
+// configError reports a failed sqlite3_config call. SQLITE_MISUSE means SQLite was
+// already initialized, i.e. a connection has been opened.
+func configError(setting string, rc C.int) error {
+	switch rc {
+	case 0:
+		return nil
+	case 21:
+		return fmt.Errorf("cannot set %s after SQLite has been initialized", setting)
+	}
+	return fmt.Errorf("failed to set %s: %s", setting, C.GoString(C.sqlite3_errstr(rc)))
+}
+
+// blobHandle is an open sqlite3_blob.
+type blobHandle *C.sqlite3_blob
+
+// blobOpen opens an incremental I/O handle on the column value at rowid.
+func blobOpen(conn *sqlite3.SQLiteConn, database, table, column string, rowid int64, writable bool) (blobHandle, error) {
+	db := rawConn(conn)
+	cDatabase, cTable, cColumn := C.CString(database), C.CString(table), C.CString(column)
+	defer C.free(unsafe.Pointer(cDatabase))
+	defer C.free(unsafe.Pointer(cTable))
+	defer C.free(unsafe.Pointer(cColumn))
+	var flags C.int
+	if writable {
+		flags = 1
+	}
+	var blob *C.sqlite3_blob
+	if rc := C.sqlite3_blob_open(db, cDatabase, cTable, cColumn, C.longlong(rowid), flags, &blob); rc != 0 {
+		// A handle may be returned even on failure and must still be closed.
+		C.sqlite3_blob_close(blob)
+		return nil, errors.New(C.GoString(C.sqlite3_errmsg(db)))
+	}
+	return blob, nil
+}
+
+// blobSize returns the size of the blob in bytes.
+func blobSize(blob blobHandle) int64 {
+	return int64(C.sqlite3_blob_bytes(blob))
+}
+
+// blobRead fills p from the blob starting at off.
+func blobRead(blob blobHandle, p []byte, off int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return blobError(C.sqlite3_blob_read(blob, unsafe.Pointer(&p[0]), C.int(len(p)), C.int(off)))
+}
+
+// blobWrite writes p to the blob starting at off. The blob cannot grow.
+func blobWrite(blob blobHandle, p []byte, off int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return blobError(C.sqlite3_blob_write(blob, unsafe.Pointer(&p[0]), C.int(len(p)), C.int(off)))
+}
+
+// interrupt aborts the statements running on conn. It may be called from any goroutine
+// while conn is open.
+func interrupt(conn *sqlite3.SQLiteConn) {
+	C.sqlite3_interrupt(rawConn(conn))
+}
+
+// fileMoved reports whether the main database file of conn has been unlinked or renamed
+// since it was opened (SQLITE_FCNTL_HAS_MOVED). VFSes that cannot tell report false.
+func fileMoved(conn *sqlite3.SQLiteConn) bool {
+	cMain := C.CString("main")
+	defer C.free(unsafe.Pointer(cMain))
+	var moved C.int
+	rc := C.sqlite3_file_control(rawConn(conn), cMain, 20, unsafe.Pointer(&moved))
+	return rc == 0 && moved != 0
+}
+
+// blobClose closes the blob.
+func blobClose(blob blobHandle) error {
+	return blobError(C.sqlite3_blob_close(blob))
+}
+
+func blobError(rc C.int) error {
+	if rc == 0 {
+		return nil
+	}
+	return errors.New(C.GoString(C.sqlite3_errstr(rc)))
+}
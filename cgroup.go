@@ -0,0 +1,63 @@
+package sqlitebp
+
+import (
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cgroupCPUQuota reports the CPU limit of the current container in CPUs, if any. It is a
+// variable so tests can inject a fake quota.
+var cgroupCPUQuota = func() (float64, bool) {
+	return readCgroupCPUQuota(os.DirFS("/sys/fs/cgroup"))
+}
+
+// readCgroupCPUQuota reads the CPU quota from a cgroup v2 (cpu.max) or v1
+// (cpu.cfs_quota_us and cpu.cfs_period_us) hierarchy mounted at fsys. It returns false
+// when no limit is set or the files are missing, as outside containers.
+func readCgroupCPUQuota(fsys fs.FS) (float64, bool) {
+	if b, err := fs.ReadFile(fsys, "cpu.max"); err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return cpuQuota(fields[0], fields[1])
+	}
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := fs.ReadFile(fsys, dir+"/cpu.cfs_quota_us")
+		if err != nil {
+			continue
+		}
+		period, err := fs.ReadFile(fsys, dir+"/cpu.cfs_period_us")
+		if err != nil {
+			continue
+		}
+		return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0, false
+}
+
+// cpuQuota divides a cgroup quota by its period. A negative quota (v1's -1) means unlimited.
+func cpuQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
+
+// effectiveProcs returns the number of CPUs the pool should be sized for: procs (normally
+// GOMAXPROCS), lowered to the cgroup CPU quota rounded up when one is set.
+func effectiveProcs(procs int) int {
+	quota, ok := cgroupCPUQuota()
+	if !ok {
+		return procs
+	}
+	return min(procs, max(1, int(math.Ceil(quota))))
+}
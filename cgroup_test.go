@@ -0,0 +1,74 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadCgroupCPUQuota(t *testing.T) {
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		quota float64
+		ok    bool
+	}{
+		{"none", fstest.MapFS{}, 0, false},
+		{"v2 unlimited", fstest.MapFS{"cpu.max": {Data: []byte("max 100000\n")}}, 0, false},
+		{"v2 limited", fstest.MapFS{"cpu.max": {Data: []byte("250000 100000\n")}}, 2.5, true},
+		{"v2 malformed", fstest.MapFS{"cpu.max": {Data: []byte("garbage\n")}}, 0, false},
+		{"v1 unlimited", fstest.MapFS{
+			"cpu/cpu.cfs_quota_us":  {Data: []byte("-1\n")},
+			"cpu/cpu.cfs_period_us": {Data: []byte("100000\n")},
+		}, 0, false},
+		{"v1 limited", fstest.MapFS{
+			"cpu,cpuacct/cpu.cfs_quota_us":  {Data: []byte("50000\n")},
+			"cpu,cpuacct/cpu.cfs_period_us": {Data: []byte("100000\n")},
+		}, 0.5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quota, ok := readCgroupCPUQuota(tt.fsys)
+			if quota != tt.quota || ok != tt.ok {
+				t.Fatalf("got (%v, %v), want (%v, %v)", quota, ok, tt.quota, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWithCgroupAwarePoolSizing(t *testing.T) {
+	orig := cgroupCPUQuota
+	t.Cleanup(func() { cgroupCPUQuota = orig })
+	procs := runtime.GOMAXPROCS(0)
+	fn := filepath.Join(t.TempDir(), "test.db")
+
+	poolSize := func(quota float64, ok bool) int {
+		t.Helper()
+		cgroupCPUQuota = func() (float64, bool) { return quota, ok }
+		db, err := OpenReadWriteCreate(fn, WithCgroupAwarePoolSizing())
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		return db.Stats().MaxOpenConnections
+	}
+
+	// A quota of 2.5 CPUs is rounded up to 3.
+	if got, want := poolSize(2.5, true), defaultPoolSize(ModeReadWriteCreate, min(procs, 3)); got != want {
+		t.Fatalf("pool size with quota = %d, want %d", got, want)
+	}
+	// A quota below one CPU still sizes for one.
+	if got, want := poolSize(0.2, true), defaultPoolSize(ModeReadWriteCreate, 1); got != want {
+		t.Fatalf("pool size with fractional quota = %d, want %d", got, want)
+	}
+	// Without a quota GOMAXPROCS is used.
+	if got, want := poolSize(0, false), defaultPoolSize(ModeReadWriteCreate, procs); got != want {
+		t.Fatalf("pool size without quota = %d, want %d", got, want)
+	}
+
+	if _, err := OpenReadWriteCreate(fn, WithCgroupAwarePoolSizing(), WithMaxOpenConns(4)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption with WithMaxOpenConns, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package sqlitebp
+
+import (
+	"sync/atomic"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ChangeOp is the kind of row change reported by WithChangeNotifier.
+type ChangeOp int
+
+// Row changes, as reported by SQLite's update hook.
+const (
+	ChangeInsert ChangeOp = sqlite3.SQLITE_INSERT
+	ChangeUpdate ChangeOp = sqlite3.SQLITE_UPDATE
+	ChangeDelete ChangeOp = sqlite3.SQLITE_DELETE
+)
+
+// String returns "INSERT", "UPDATE" or "DELETE".
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeInsert:
+		return "INSERT"
+	case ChangeUpdate:
+		return "UPDATE"
+	case ChangeDelete:
+		return "DELETE"
+	}
+	return "UNKNOWN"
+}
+
+// TableChange lists the rows of one table changed the same way by a transaction.
+type TableChange struct {
+	Database string // "main", "temp" or the name of an attached database
+	Table    string
+	Op       ChangeOp
+	RowIDs   []int64 // in the order first changed, without duplicates
+}
+
+// ChangeBatch is the changes of one committed transaction, sent by WithChangeNotifier.
+type ChangeBatch struct {
+	Changes []TableChange
+	// Dropped is the number of batches discarded because the channel was full since the
+	// last batch was delivered. If it is not 0, changes were missed and anything derived
+	// from the database, such as a cache, must be invalidated entirely.
+	Dropped int64
+}
+
+// changeNotifier is the channel of WithChangeNotifier, shared by a handle's connections.
+type changeNotifier struct {
+	ch      chan<- ChangeBatch
+	dropped atomic.Int64
+}
+
+// send delivers changes without blocking the committing connection.
+func (n *changeNotifier) send(changes []TableChange) {
+	batch := ChangeBatch{Changes: changes, Dropped: n.dropped.Swap(0)}
+	select {
+	case n.ch <- batch:
+	default:
+		n.dropped.Add(batch.Dropped + 1)
+	}
+}
+
+// txChanges coalesces the update hook events of a connection's current transaction.
+type txChanges struct {
+	changes []TableChange
+	index   map[changeKey]int            // into changes
+	seen    map[changeKey]map[int64]bool // rowids already in changes
+}
+
+// changeKey identifies a TableChange within a transaction.
+type changeKey struct {
+	database, table string
+	op              ChangeOp
+}
+
+// add records a row change; it is called by the update hook.
+func (t *txChanges) add(op int, database, table string, rowid int64) {
+	key := changeKey{database: database, table: table, op: ChangeOp(op)}
+	if t.index == nil {
+		t.index = map[changeKey]int{}
+		t.seen = map[changeKey]map[int64]bool{}
+	}
+	i, ok := t.index[key]
+	if !ok {
+		i = len(t.changes)
+		t.index[key] = i
+		t.seen[key] = map[int64]bool{}
+		t.changes = append(t.changes, TableChange{Database: database, Table: table, Op: ChangeOp(op)})
+	}
+	if !t.seen[key][rowid] {
+		t.seen[key][rowid] = true
+		t.changes[i].RowIDs = append(t.changes[i].RowIDs, rowid)
+	}
+}
+
+// take returns the recorded changes and starts over.
+func (t *txChanges) take() []TableChange {
+	changes := t.changes
+	*t = txChanges{}
+	return changes
+}
@@ -0,0 +1,99 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWithChangeNotifier(t *testing.T) {
+	ch := make(chan ChangeBatch, 1)
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "changes.db"), WithChangeNotifier(ch),
+		WithMigrations("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT); CREATE TABLE tags (id INTEGER PRIMARY KEY, item_id INTEGER)"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	next := func() ChangeBatch {
+		t.Helper()
+		select {
+		case batch := <-ch:
+			return batch
+		default:
+			t.Fatal("expected a batch")
+		}
+		return ChangeBatch{}
+	}
+
+	err = Transaction(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			"INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')",
+			"UPDATE items SET name = 'B' WHERE id = 2",
+			"UPDATE items SET name = upper(name) WHERE id IN (2, 3)",
+			"INSERT INTO tags (id, item_id) VALUES (10, 1)",
+		} {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		// Nothing is sent before the commit.
+		if len(ch) != 0 {
+			return errors.New("batch sent before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	want := []TableChange{
+		{Database: "main", Table: "items", Op: ChangeInsert, RowIDs: []int64{1, 2, 3}},
+		{Database: "main", Table: "items", Op: ChangeUpdate, RowIDs: []int64{2, 3}},
+		{Database: "main", Table: "tags", Op: ChangeInsert, RowIDs: []int64{10}},
+	}
+	if batch := next(); !reflect.DeepEqual(batch.Changes, want) || batch.Dropped != 0 {
+		t.Fatalf("batch = %+v, want %+v", batch, want)
+	}
+	if len(ch) != 0 {
+		t.Fatalf("expected a single batch, %d more queued", len(ch))
+	}
+
+	// Rolled back changes are not sent.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM items WHERE id = 1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if len(ch) != 0 {
+		t.Fatalf("rolled back changes were sent: %+v", <-ch)
+	}
+
+	// A full channel drops batches without blocking, and the next delivered batch says so.
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("DELETE FROM items WHERE id = ?", i+1); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	batch := next()
+	if want := []TableChange{{Database: "main", Table: "items", Op: ChangeDelete, RowIDs: []int64{1}}}; !reflect.DeepEqual(batch.Changes, want) || batch.Dropped != 0 {
+		t.Fatalf("batch = %+v, want %+v", batch, want)
+	}
+	if _, err := db.Exec("DELETE FROM tags WHERE true"); err != nil {
+		t.Fatalf("delete tags: %v", err)
+	}
+	if batch := next(); batch.Dropped != 2 || batch.Changes[0].Table != "tags" {
+		t.Fatalf("batch = %+v, want tags with 2 dropped", batch)
+	}
+
+	if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "x.db"), WithChangeNotifier(make(chan ChangeBatch))); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an unbuffered channel, got %v", err)
+	}
+}
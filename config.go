@@ -0,0 +1,423 @@
+package sqlitebp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Config is a declarative alternative to functional options, for settings loaded from
+// JSON, YAML or similar files. Each field mirrors a With* option; zero values (nil
+// pointers, empty strings, zero numbers) leave the option unset so the defaults apply.
+// Callback fields cannot be serialized and are skipped by encoding/json.
+type Config struct {
+	Optimize           *bool  `json:"optimize,omitempty"`             // WithOptimize
+	BusyTimeoutSeconds *int   `json:"busy_timeout_seconds,omitempty"` // WithBusyTimeoutSeconds
+	CacheSizeMiB       int    `json:"cache_size_mib,omitempty"`       // WithCacheSizeMiB
+	JournalMode        string `json:"journal_mode,omitempty"`         // WithJournalMode
+	Synchronous        string `json:"synchronous,omitempty"`          // WithSynchronous
+	TransactionLock    string `json:"transaction_lock,omitempty"`     // WithTransactionLock
+	ForeignKeys        *bool  `json:"foreign_keys,omitempty"`         // WithForeignKeys
+	TempStore          string `json:"temp_store,omitempty"`           // WithTempStore
+	MMapSize           *int64 `json:"mmap_size,omitempty"`            // WithMMapSize
+	CaseSensitiveLike  *bool  `json:"case_sensitive_like,omitempty"`  // WithCaseSensitiveLike
+	RecursiveTriggers  *bool  `json:"recursive_triggers,omitempty"`   // WithRecursiveTriggers
+	SecureDelete       string `json:"secure_delete,omitempty"`        // WithSecureDelete
+	PageSize           int    `json:"page_size,omitempty"`            // WithPageSize
+	ChunkSize          int    `json:"chunk_size,omitempty"`           // WithChunkSize
+	PersistentWAL      bool   `json:"persistent_wal,omitempty"`       // WithPersistentWAL
+	WALSizeLimit       int64  `json:"wal_size_limit,omitempty"`       // WithWALSizeLimit
+	ShmMode            string `json:"shm_mode,omitempty"`             // WithShmMode
+
+	// Pragmas are applied with WithPragma in name order.
+	Pragmas map[string]string `json:"pragmas,omitempty"`
+	// Limits maps sqlite3.SQLITE_LIMIT_* ids to values (WithLimit).
+	Limits map[int]int `json:"limits,omitempty"`
+	// MinimumSQLiteVersion is a "major.minor.patch" version (WithMinimumSQLiteVersion).
+	MinimumSQLiteVersion string `json:"minimum_sqlite_version,omitempty"`
+	// ValidationQuery is run after the open (WithValidationQuery).
+	ValidationQuery string `json:"validation_query,omitempty"`
+	// Metadata is stamped into write-capable opens (WithMetadata).
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// SchemaAssertions maps table names to their expected columns (WithSchemaAssertion).
+	SchemaAssertions map[string][]ColumnSpec `json:"schema_assertions,omitempty"`
+	// AttachReadOnly maps schema names to databases attached read-only, in name order
+	// (WithAttachReadOnly).
+	AttachReadOnly map[string]string `json:"attach_read_only,omitempty"`
+	// UnionViews maps view names to the table they combine (WithUnionView).
+	UnionViews map[string]string `json:"union_views,omitempty"`
+	// Migrations are applied in order at open, tracked by user_version (WithMigrations).
+	Migrations []string `json:"migrations,omitempty"`
+
+	RequiredFeatures        []Feature         `json:"required_features,omitempty"`         // WithRequiredFeatures
+	Extensions              []ExtensionConfig `json:"extensions,omitempty"`                // WithLoadExtension
+	InitSQL                 []string          `json:"init_sql,omitempty"`                  // WithInitSQL
+	ReindexOnOpen           []string          `json:"reindex_on_open,omitempty"`           // WithReindexOnOpen
+	AdvisoryLocks           []string          `json:"advisory_locks,omitempty"`            // WithAdvisoryLock
+	RedactedParams          []string          `json:"redacted_params,omitempty"`           // WithRedactedParams
+	NoFollow                bool              `json:"no_follow,omitempty"`                 // WithNoFollow
+	OpenFlags               []int             `json:"open_flags,omitempty"`                // WithOpenFlag
+	Immutable               bool              `json:"immutable,omitempty"`                 // WithImmutable
+	MMapWholeFile           bool              `json:"mmap_whole_file,omitempty"`           // WithMMapWholeFile
+	WithoutWALReplay        bool              `json:"without_wal_replay,omitempty"`        // WithoutWALReplay
+	ReadOnlySchemaRefresh   bool              `json:"read_only_schema_refresh,omitempty"`  // WithReadOnlySchemaRefresh
+	RestoreOverwrite        bool              `json:"restore_overwrite,omitempty"`         // WithRestoreOverwrite
+	AuditIdentity           string            `json:"audit_identity,omitempty"`            // WithAuditIdentity
+	Name                    string            `json:"name,omitempty"`                      // WithName
+	Profile                 Profile           `json:"profile,omitempty"`                   // WithProfile
+	DeterministicRandomSeed *int64            `json:"deterministic_random_seed,omitempty"` // WithDeterministicRandom
+
+	MaxOpenConns          int      `json:"max_open_conns,omitempty"`           // WithMaxOpenConns
+	ConnMaxIdleTime       Duration `json:"conn_max_idle_time,omitempty"`       // WithConnMaxIdleTime
+	ConnMaxLifetime       Duration `json:"conn_max_lifetime,omitempty"`        // WithConnMaxLifetimeJitter
+	ConnMaxLifetimeJitter Duration `json:"conn_max_lifetime_jitter,omitempty"` // WithConnMaxLifetimeJitter
+	ValidationInterval    Duration `json:"validation_interval,omitempty"`      // WithValidationInterval
+	ConnectionInitTimeout Duration `json:"connection_init_timeout,omitempty"`  // WithConnectionInitTimeout
+	OpenRetryAttempts     int      `json:"open_retry_attempts,omitempty"`      // WithOpenRetry
+	OpenRetryBackoff      Duration `json:"open_retry_backoff,omitempty"`       // WithOpenRetry
+	ConnectHookAttempts   int      `json:"connect_hook_attempts,omitempty"`    // WithConnectHookErrorRecovery
+	ConnectHookBackoff    Duration `json:"connect_hook_backoff,omitempty"`     // WithConnectHookErrorRecovery
+	InterruptOnCancel     bool     `json:"interrupt_on_cancel,omitempty"`      // WithInterruptOnCancel
+	TxOptions             bool     `json:"tx_options,omitempty"`               // WithTxOptions
+	DeadlineAwareBusy     bool     `json:"deadline_aware_busy,omitempty"`      // WithDeadlineAwareBusyHandler
+	AutoReconnect         bool     `json:"auto_reconnect,omitempty"`           // WithAutoReconnect
+	StatementTimeout      Duration `json:"statement_timeout,omitempty"`        // WithDefaultStatementTimeout
+	StatementRunTimeout   Duration `json:"statement_run_timeout,omitempty"`    // WithStatementTimeout
+	ConnectionLabels      bool     `json:"connection_labels,omitempty"`        // WithConnectionLabels
+	Warmup                bool     `json:"warmup,omitempty"`                   // WithWarmup
+	CgroupAwarePoolSizing bool     `json:"cgroup_aware_pool_sizing,omitempty"` // WithCgroupAwarePoolSizing
+
+	WALHook            func(dbName string, pages int) int     `json:"-"` // WithWALHook
+	ErrorLog           func(code int, msg string)             `json:"-"` // WithErrorLogCallback
+	ProgressOps        int                                    `json:"-"` // WithProgressHandler
+	ProgressHandler    func() bool                            `json:"-"` // WithProgressHandler
+	Funcs              []FuncConfig                           `json:"-"` // WithFunc
+	Collations         map[string]func(a, b string) int       `json:"-"` // WithCollation, in name order
+	ConnectHooks       []func(conn *sqlite3.SQLiteConn) error `json:"-"` // WithConnectHook
+	ConnectHookOnError func(err error, attempt int)           `json:"-"` // WithConnectHookErrorRecovery
+	QueryPlanThreshold Duration                               `json:"-"` // WithQueryPlanOnSlow
+	QueryPlanSink      func(query, plan string)               `json:"-"` // WithQueryPlanOnSlow
+	OnConnect          func(connID int)                       `json:"-"` // WithPoolEvents
+	OnReset            func(connID int)                       `json:"-"` // WithPoolEvents
+	OnClose            func(connID int)                       `json:"-"` // WithPoolEvents
+	ChangeNotifier     chan<- ChangeBatch                     `json:"-"` // WithChangeNotifier
+	BusyDiagnostics    func(event BusyEvent)                  `json:"-"` // WithBusyDiagnostics
+	StatementFilter    func(query string) error               `json:"-"` // WithStatementFilter
+	AuditHook          func(event OpenEvent)                  `json:"-"` // WithAuditHook
+	VFSName            string                                 `json:"-"` // WithVFSImplementation
+	VFS                VFS                                    `json:"-"` // WithVFSImplementation
+	SessionTables      []string                               `json:"-"` // WithSession
+	SessionSink        func(changeset []byte)                 `json:"-"` // WithSession
+	TableFuncs         map[string]TableModule                 `json:"-"` // WithTableFunc, in name order
+}
+
+// ExtensionConfig is a run-time loadable extension, see WithLoadExtension.
+type ExtensionConfig struct {
+	Path  string `json:"path"`
+	Entry string `json:"entry,omitempty"`
+}
+
+// FuncConfig is an SQL function registration, see WithFunc.
+type FuncConfig struct {
+	Name string
+	Impl any
+	Pure bool
+}
+
+// Duration is a time.Duration that encodes as text such as "30s" or "5m".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using time.ParseDuration.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid duration %q: %w", text, err))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Options translates the set fields of c into the equivalent functional options.
+func (c Config) Options() []Option {
+	var opts []Option
+	if c.Optimize != nil {
+		opts = append(opts, WithOptimize(*c.Optimize))
+	}
+	if c.BusyTimeoutSeconds != nil {
+		opts = append(opts, WithBusyTimeoutSeconds(*c.BusyTimeoutSeconds))
+	}
+	if c.CacheSizeMiB != 0 {
+		opts = append(opts, WithCacheSizeMiB(c.CacheSizeMiB))
+	}
+	if c.JournalMode != "" {
+		opts = append(opts, WithJournalMode(c.JournalMode))
+	}
+	if c.Synchronous != "" {
+		opts = append(opts, WithSynchronous(c.Synchronous))
+	}
+	if c.TransactionLock != "" {
+		opts = append(opts, WithTransactionLock(c.TransactionLock))
+	}
+	if c.ForeignKeys != nil {
+		opts = append(opts, WithForeignKeys(*c.ForeignKeys))
+	}
+	if c.TempStore != "" {
+		opts = append(opts, WithTempStore(c.TempStore))
+	}
+	if c.MMapSize != nil {
+		opts = append(opts, WithMMapSize(*c.MMapSize))
+	}
+	if c.CaseSensitiveLike != nil {
+		opts = append(opts, WithCaseSensitiveLike(*c.CaseSensitiveLike))
+	}
+	if c.RecursiveTriggers != nil {
+		opts = append(opts, WithRecursiveTriggers(*c.RecursiveTriggers))
+	}
+	if c.SecureDelete != "" {
+		opts = append(opts, WithSecureDelete(c.SecureDelete))
+	}
+	if c.PageSize != 0 {
+		opts = append(opts, WithPageSize(c.PageSize))
+	}
+	if c.ChunkSize != 0 {
+		opts = append(opts, WithChunkSize(c.ChunkSize))
+	}
+	if c.PersistentWAL {
+		opts = append(opts, WithPersistentWAL(true))
+	}
+	if c.WALSizeLimit != 0 {
+		opts = append(opts, WithWALSizeLimit(c.WALSizeLimit))
+	}
+	if c.ShmMode != "" {
+		opts = append(opts, WithShmMode(c.ShmMode))
+	}
+	if c.Profile != "" {
+		opts = append(opts, WithProfile(c.Profile))
+	}
+	names := make([]string, 0, len(c.Pragmas))
+	for name := range c.Pragmas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts = append(opts, WithPragma(name, c.Pragmas[name]))
+	}
+	ids := make([]int, 0, len(c.Limits))
+	for id := range c.Limits {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		opts = append(opts, WithLimit(id, c.Limits[id]))
+	}
+	if c.MinimumSQLiteVersion != "" {
+		var major, minor, patch int
+		if _, err := fmt.Sscanf(c.MinimumSQLiteVersion, "%d.%d.%d", &major, &minor, &patch); err != nil {
+			opts = append(opts, func(*openConfig) error {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid minimum SQLite version %q", c.MinimumSQLiteVersion))
+			})
+		} else {
+			opts = append(opts, WithMinimumSQLiteVersion(major, minor, patch))
+		}
+	}
+	if len(c.Metadata) > 0 {
+		opts = append(opts, WithMetadata(c.Metadata))
+	}
+	if len(c.ReindexOnOpen) > 0 {
+		opts = append(opts, WithReindexOnOpen(c.ReindexOnOpen...))
+	}
+	for _, name := range c.AdvisoryLocks {
+		opts = append(opts, WithAdvisoryLock(name))
+	}
+	if c.ValidationQuery != "" {
+		opts = append(opts, WithValidationQuery(c.ValidationQuery))
+	}
+	tables := make([]string, 0, len(c.SchemaAssertions))
+	for table := range c.SchemaAssertions {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		opts = append(opts, WithSchemaAssertion(table, c.SchemaAssertions[table]))
+	}
+	schemas := make([]string, 0, len(c.AttachReadOnly))
+	for schema := range c.AttachReadOnly {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	for _, schema := range schemas {
+		opts = append(opts, WithAttachReadOnly(schema, c.AttachReadOnly[schema]))
+	}
+	views := make([]string, 0, len(c.UnionViews))
+	for view := range c.UnionViews {
+		views = append(views, view)
+	}
+	sort.Strings(views)
+	for _, view := range views {
+		opts = append(opts, WithUnionView(view, c.UnionViews[view]))
+	}
+	if len(c.Migrations) > 0 {
+		opts = append(opts, WithMigrations(c.Migrations...))
+	}
+	if len(c.RequiredFeatures) > 0 {
+		opts = append(opts, WithRequiredFeatures(c.RequiredFeatures...))
+	}
+	for _, ext := range c.Extensions {
+		opts = append(opts, WithLoadExtension(ext.Path, ext.Entry))
+	}
+	if len(c.InitSQL) > 0 {
+		opts = append(opts, WithInitSQL(c.InitSQL...))
+	}
+	if len(c.RedactedParams) > 0 {
+		opts = append(opts, WithRedactedParams(c.RedactedParams...))
+	}
+	if c.NoFollow {
+		opts = append(opts, WithNoFollow())
+	}
+	for _, flag := range c.OpenFlags {
+		opts = append(opts, WithOpenFlag(flag))
+	}
+	if c.Immutable {
+		opts = append(opts, WithImmutable())
+	}
+	if c.MMapWholeFile {
+		opts = append(opts, WithMMapWholeFile())
+	}
+	if c.WithoutWALReplay {
+		opts = append(opts, WithoutWALReplay())
+	}
+	if c.ReadOnlySchemaRefresh {
+		opts = append(opts, WithReadOnlySchemaRefresh())
+	}
+	if c.RestoreOverwrite {
+		opts = append(opts, WithRestoreOverwrite())
+	}
+	if c.Name != "" {
+		opts = append(opts, WithName(c.Name))
+	}
+	if c.AuditIdentity != "" {
+		opts = append(opts, WithAuditIdentity(c.AuditIdentity))
+	}
+	if c.DeterministicRandomSeed != nil {
+		opts = append(opts, WithDeterministicRandom(*c.DeterministicRandomSeed))
+	}
+	if c.MaxOpenConns != 0 {
+		opts = append(opts, WithMaxOpenConns(c.MaxOpenConns))
+	}
+	if c.ConnMaxIdleTime != 0 {
+		opts = append(opts, WithConnMaxIdleTime(time.Duration(c.ConnMaxIdleTime)))
+	}
+	if c.ConnMaxLifetime != 0 || c.ConnMaxLifetimeJitter != 0 {
+		opts = append(opts, WithConnMaxLifetimeJitter(time.Duration(c.ConnMaxLifetime), time.Duration(c.ConnMaxLifetimeJitter)))
+	}
+	if c.ValidationInterval != 0 {
+		opts = append(opts, WithValidationInterval(time.Duration(c.ValidationInterval)))
+	}
+	if c.ConnectionInitTimeout != 0 {
+		opts = append(opts, WithConnectionInitTimeout(time.Duration(c.ConnectionInitTimeout)))
+	}
+	if c.OpenRetryAttempts != 0 || c.OpenRetryBackoff != 0 {
+		opts = append(opts, WithOpenRetry(c.OpenRetryAttempts, time.Duration(c.OpenRetryBackoff)))
+	}
+	if c.ConnectHookAttempts != 0 || c.ConnectHookBackoff != 0 || c.ConnectHookOnError != nil {
+		opts = append(opts, WithConnectHookErrorRecovery(c.ConnectHookAttempts, time.Duration(c.ConnectHookBackoff), c.ConnectHookOnError))
+	}
+	if c.InterruptOnCancel {
+		opts = append(opts, WithInterruptOnCancel())
+	}
+	if c.TxOptions {
+		opts = append(opts, WithTxOptions())
+	}
+	if c.DeadlineAwareBusy {
+		opts = append(opts, WithDeadlineAwareBusyHandler())
+	}
+	if c.AutoReconnect {
+		opts = append(opts, WithAutoReconnect())
+	}
+	if c.StatementTimeout != 0 {
+		opts = append(opts, WithDefaultStatementTimeout(time.Duration(c.StatementTimeout)))
+	}
+	if c.StatementRunTimeout != 0 {
+		opts = append(opts, WithStatementTimeout(time.Duration(c.StatementRunTimeout)))
+	}
+	if c.ConnectionLabels {
+		opts = append(opts, WithConnectionLabels())
+	}
+	if c.Warmup {
+		opts = append(opts, WithWarmup())
+	}
+	if c.CgroupAwarePoolSizing {
+		opts = append(opts, WithCgroupAwarePoolSizing())
+	}
+	if c.WALHook != nil {
+		opts = append(opts, WithWALHook(c.WALHook))
+	}
+	if c.ErrorLog != nil {
+		opts = append(opts, WithErrorLogCallback(c.ErrorLog))
+	}
+	if c.ProgressOps != 0 || c.ProgressHandler != nil {
+		opts = append(opts, WithProgressHandler(c.ProgressOps, c.ProgressHandler))
+	}
+	for _, f := range c.Funcs {
+		opts = append(opts, WithFunc(f.Name, f.Impl, f.Pure))
+	}
+	collations := make([]string, 0, len(c.Collations))
+	for name := range c.Collations {
+		collations = append(collations, name)
+	}
+	sort.Strings(collations)
+	for _, name := range collations {
+		opts = append(opts, WithCollation(name, c.Collations[name]))
+	}
+	for _, hook := range c.ConnectHooks {
+		opts = append(opts, WithConnectHook(hook))
+	}
+	if c.QueryPlanThreshold != 0 || c.QueryPlanSink != nil {
+		opts = append(opts, WithQueryPlanOnSlow(time.Duration(c.QueryPlanThreshold), c.QueryPlanSink))
+	}
+	if c.OnConnect != nil || c.OnReset != nil || c.OnClose != nil {
+		opts = append(opts, WithPoolEvents(c.OnConnect, c.OnReset, c.OnClose))
+	}
+	if c.ChangeNotifier != nil {
+		opts = append(opts, WithChangeNotifier(c.ChangeNotifier))
+	}
+	if c.BusyDiagnostics != nil {
+		opts = append(opts, WithBusyDiagnostics(c.BusyDiagnostics))
+	}
+	if c.StatementFilter != nil {
+		opts = append(opts, WithStatementFilter(c.StatementFilter))
+	}
+	if c.AuditHook != nil {
+		opts = append(opts, WithAuditHook(c.AuditHook))
+	}
+	if c.VFSName != "" || c.VFS != nil {
+		opts = append(opts, WithVFSImplementation(c.VFSName, c.VFS))
+	}
+	if c.SessionSink != nil || len(c.SessionTables) > 0 {
+		opts = append(opts, WithSession(c.SessionTables, c.SessionSink))
+	}
+	tableFuncs := make([]string, 0, len(c.TableFuncs))
+	for name := range c.TableFuncs {
+		tableFuncs = append(tableFuncs, name)
+	}
+	sort.Strings(tableFuncs)
+	for _, name := range tableFuncs {
+		opts = append(opts, tableFuncOption(name, c.TableFuncs[name]))
+	}
+	return opts
+}
+
+// OpenWithConfig opens filename in mode with the settings in cfg, validated exactly as
+// the equivalent functional options would be.
+func OpenWithConfig(filename string, mode Mode, cfg Config) (*sql.DB, error) {
+	return Open(filename, mode, cfg.Options()...)
+}
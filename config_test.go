@@ -0,0 +1,102 @@
+package sqlitebp
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenWithConfig_FromJSON(t *testing.T) {
+	const data = `{
+		"busy_timeout_seconds": 3,
+		"cache_size_mib": 8,
+		"synchronous": "FULL",
+		"foreign_keys": false,
+		"page_size": 8192,
+		"pragmas": {"user_version": "7"},
+		"max_open_conns": 2,
+		"conn_max_idle_time": "90s"
+	}`
+	var cfg Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if time.Duration(cfg.ConnMaxIdleTime) != 90*time.Second {
+		t.Fatalf("conn_max_idle_time=%v want 90s", time.Duration(cfg.ConnMaxIdleTime))
+	}
+
+	db, err := OpenWithConfig(filepath.Join(t.TempDir(), "config.db"), ModeReadWriteCreate, cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if got := db.Stats().MaxOpenConnections; got != 2 {
+		t.Fatalf("max open conns=%d want 2", got)
+	}
+	for pragma, want := range map[string]int{
+		"busy_timeout": 3000,
+		"cache_size":   -8192,
+		"synchronous":  2,
+		"foreign_keys": 0,
+		"page_size":    8192,
+		"user_version": 7,
+	} {
+		var got int
+		if err := db.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+			t.Fatalf("PRAGMA %s: %v", pragma, err)
+		}
+		if got != want {
+			t.Errorf("PRAGMA %s=%d want %d", pragma, got, want)
+		}
+	}
+}
+
+func TestOpenWithConfig_Validation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.db")
+	if _, err := OpenWithConfig(filename, ModeReadWriteCreate, Config{Synchronous: "SOMETIMES"}); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"conn_max_idle_time": "soon"}`), &cfg); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for bad duration, got %v", err)
+	}
+}
+
+func TestOpenWithConfig_Callbacks(t *testing.T) {
+	reverse := func(a, b string) int { return strings.Compare(b, a) }
+	var events []OpenEvent
+	cfg := Config{
+		Collations:    map[string]func(a, b string) int{"reverse": reverse},
+		AuditHook:     func(event OpenEvent) { events = append(events, event) },
+		AuditIdentity: "config",
+		OpenFlags:     []int{OpenFlagNoFollow},
+	}
+	filename := filepath.Join(t.TempDir(), "config.db")
+	db, err := OpenWithConfig(filename, ModeReadWriteCreate, cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if len(events) != 1 || events[0].Identity != "config" || events[0].Err != nil {
+		t.Fatalf("audit events = %+v, want one successful open", events)
+	}
+	var first string
+	if err := db.QueryRow("SELECT v FROM (SELECT 'a' AS v UNION ALL SELECT 'b') ORDER BY v COLLATE reverse LIMIT 1").Scan(&first); err != nil || first != "b" {
+		t.Fatalf("first = %q (err=%v), want b", first, err)
+	}
+
+	var flags Config
+	if err := json.Unmarshal([]byte(`{"open_flags": [2]}`), &flags); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if _, err := OpenWithConfig(filename, ModeReadWrite, flags); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an unsupported open flag, got %v", err)
+	}
+	// WithSession validates its arguments in every build.
+	if _, err := OpenWithConfig(filename, ModeReadWrite, Config{SessionTables: []string{"items"}}); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a session without a sink, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrReadOnly indicates a write was attempted through a handle opened read-only.
+	ErrReadOnly = errors.New("sqlitebp: database is read-only")
+	// ErrInvalidPragma indicates a pragma name or value rejected by GetPragma or SetPragma.
+	ErrInvalidPragma = errors.New("sqlitebp: invalid pragma")
+)
+
+// DB is a database handle that pairs the regular pool with a dedicated write connection.
+//
+// SQLite allows one writer at a time. When several pooled connections write at once they
+// contend for the lock and wait on (or exhaust) the busy timeout. Routing writes through
+// WriteConn serializes them in-process on a single connection instead, while reads keep
+// using the embedded pool concurrently.
+type DB struct {
+	*sql.DB
+
+	writer   *sql.DB // single connection; nil in read-only mode
+	filename string
+	mode     Mode
+	opts     []Option // kept to reopen the same way in AtomicReplace
+}
+
+// OpenDB opens filename in mode like Open, returning a DB. Write-capable modes also get a
+// second pool limited to a single connection for WriteConn, sharing the configuration of
+// the first; the open itself (audit event, migrations, ...) happens once.
+func OpenDB(filename string, mode Mode, opts ...Option) (*DB, error) {
+	db, writer, err := openPools(context.Background(), filename, mode, mode != ModeReadOnly, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db, writer: writer, filename: filename, mode: mode, opts: opts}, nil
+}
+
+// WriteConn returns the dedicated write connection, waiting until it is free or ctx is done.
+// The caller must Close the returned *sql.Conn to hand it back. Only one caller holds it
+// at a time, so writes through it never contend with each other for the write lock.
+func (db *DB) WriteConn(ctx context.Context) (*sql.Conn, error) {
+	if db.writer == nil {
+		return nil, errors.Join(ErrReadOnly, fmt.Errorf("no write connection for %q", db.filename))
+	}
+	return db.writer.Conn(ctx)
+}
+
+// Close closes the pool and the write connection.
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.writer != nil && db.writer != db.DB {
+		err = errors.Join(err, db.writer.Close())
+	}
+	return err
+}
+
+// AtomicReplace swaps the database file behind live for newFile, a database rebuilt
+// elsewhere (for example by OpenBulkLoad), and reopens live on the original filename with
+// the options it was opened with. newFile must be on the same filesystem and must not be
+// open; it no longer exists afterwards.
+//
+// newFile's WAL, if any, is checkpointed first so the rename moves a self-contained file.
+// live is then closed, which checkpoints its own WAL, leftover -wal and -shm files are
+// removed so they cannot be applied to the new file, and newFile is renamed over the
+// original, which is atomic on POSIX filesystems.
+//
+// live must not be used by other goroutines while AtomicReplace runs. Other processes
+// must not have the database open: SQLite cannot follow a rename, so they keep using the
+// old, unlinked file until they reopen it, and anything they write to it is lost. If the
+// reopen fails, live is left closed and the new file is in place.
+func AtomicReplace(ctx context.Context, live *DB, newFile string) error {
+	if newFile == "" {
+		return ErrEmptyFilename
+	}
+	if live.mode == ModeReadOnly {
+		return errors.Join(ErrReadOnly, fmt.Errorf("cannot replace %q through a read-only handle", live.filename))
+	}
+	if err := checkpointForRename(ctx, newFile); err != nil {
+		return err
+	}
+	if err := live.Close(); err != nil {
+		return fmt.Errorf("sqlitebp: failed to close %q: %w", live.filename, err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(live.filename + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("sqlitebp: failed to remove %q: %w", live.filename+suffix, err)
+		}
+	}
+	if err := os.Rename(newFile, live.filename); err != nil {
+		return fmt.Errorf("sqlitebp: failed to rename %q to %q: %w", newFile, live.filename, err)
+	}
+	if err := syncDir(filepath.Dir(live.filename)); err != nil {
+		return err
+	}
+	reopened, err := OpenDB(live.filename, live.mode, live.opts...)
+	if err != nil {
+		return err
+	}
+	*live = *reopened
+	return nil
+}
+
+// checkpointForRename folds filename's WAL into the main file and closes it, so that the
+// file can be renamed without its -wal and -shm companions.
+func checkpointForRename(ctx context.Context, filename string) error {
+	db, err := openWithMode(filename, ModeReadWrite, WithOptimize(false))
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		db.Close()
+		return errors.Join(ErrPragmaExec, fmt.Errorf("failed to checkpoint %q: %w", filename, err))
+	}
+	// Closing the last connection deletes the -wal and -shm files.
+	return db.Close()
+}
+
+// syncDir fsyncs dir so a rename within it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sqlitebp: failed to sync directory %q: %w", dir, err)
+	}
+	return nil
+}
+
+// dbPragmas lists the pragmas GetPragma reads, mapped to whether SetPragma may set them.
+// Pragmas that act when queried (optimize, wal_checkpoint, ...) or that can corrupt the
+// database or weaken its checks (writable_schema, schema_version, trusted_schema,
+// ignore_check_constraints, ...) are left out.
+var dbPragmas = map[string]bool{
+	"analysis_limit":            true,
+	"application_id":            true,
+	"auto_vacuum":               true,
+	"automatic_index":           true,
+	"busy_timeout":              true,
+	"cache_size":                true,
+	"cache_spill":               true,
+	"case_sensitive_like":       true,
+	"cell_size_check":           true,
+	"checkpoint_fullfsync":      true,
+	"defer_foreign_keys":        true,
+	"foreign_keys":              true,
+	"fullfsync":                 true,
+	"hard_heap_limit":           true,
+	"journal_mode":              true,
+	"journal_size_limit":        true,
+	"locking_mode":              true,
+	"max_page_count":            true,
+	"mmap_size":                 true,
+	"page_size":                 true,
+	"query_only":                true,
+	"read_uncommitted":          true,
+	"recursive_triggers":        true,
+	"reverse_unordered_selects": true,
+	"secure_delete":             true,
+	"soft_heap_limit":           true,
+	"synchronous":               true,
+	"temp_store":                true,
+	"threads":                   true,
+	"user_version":              true,
+	"wal_autocheckpoint":        true,
+	"data_version":              false,
+	"encoding":                  false,
+	"freelist_count":            false,
+	"page_count":                false,
+	"schema_version":            false,
+}
+
+// checkDBPragma fails with ErrInvalidConfigOption unless dbPragmas allows reading n, or
+// setting it if set is true.
+func checkDBPragma(n string, set bool) error {
+	settable, ok := dbPragmas[n]
+	if !ok || set && !settable {
+		return errors.Join(ErrInvalidConfigOption, ErrInvalidPragma, fmt.Errorf("pragma %q is not supported", n))
+	}
+	return nil
+}
+
+// GetPragma returns the value of PRAGMA name (the first column of the first row) from one
+// of the pooled connections. name must be a pragma SetPragma may set or one of
+// data_version, encoding, freelist_count, page_count and schema_version; others fail with
+// ErrInvalidConfigOption.
+func (db *DB) GetPragma(ctx context.Context, name string) (string, error) {
+	n := strings.ToLower(name)
+	if err := checkDBPragma(n, false); err != nil {
+		return "", err
+	}
+	rows, err := db.QueryContext(ctx, "PRAGMA "+n)
+	if err != nil {
+		return "", errors.Join(ErrPragmaExec, fmt.Errorf("failed to read pragma %s: %w", n, err))
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", errors.Join(ErrPragmaExec, fmt.Errorf("failed to read pragma %s: %w", n, err))
+		}
+		// Unknown pragmas are silently ignored by SQLite and return nothing.
+		return "", errors.Join(ErrInvalidPragma, fmt.Errorf("pragma %s returned no value", n))
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", errors.Join(ErrPragmaExec, fmt.Errorf("failed to read pragma %s: %w", n, err))
+	}
+	return values[0].String, nil
+}
+
+// SetPragma runs PRAGMA name=value on one of the pooled connections. value must be an
+// integer, a bare keyword or a single-quoted string literal. Only pragmas that are safe to
+// change at run time are accepted (cache_size, user_version, journal_mode, ...); others,
+// such as writable_schema, fail with ErrInvalidConfigOption.
+//
+// Most pragmas (cache_size, foreign_keys, busy_timeout, ...) are per connection and only
+// affect the connection that happened to run SetPragma; set those for every connection
+// with the matching option or WithPragma instead. Persistent pragmas such as user_version,
+// application_id or journal_mode=WAL apply to the database file and are safe to set here.
+func (db *DB) SetPragma(ctx context.Context, name, value string) error {
+	n := strings.ToLower(name)
+	if err := checkDBPragma(n, true); err != nil {
+		return err
+	}
+	if !pragmaValuePattern.MatchString(value) {
+		return errors.Join(ErrInvalidPragma, fmt.Errorf("invalid value %q for pragma %s", value, n))
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA "+n+"="+value); err != nil {
+		return errors.Join(ErrPragmaExec, fmt.Errorf("failed to set pragma %s: %w", n, err))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDB_WriteConnSerializesWriters(t *testing.T) {
+	ctx := context.Background()
+	// A zero busy timeout turns any lock contention into an immediate SQLITE_BUSY.
+	db, err := OpenDB(filepath.Join(t.TempDir(), "writeconn.db"), ModeReadWriteCreate, WithBusyTimeoutSeconds(0))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value INTEGER NOT NULL) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	const writers, perWriter = 32, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				conn, err := db.WriteConn(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = conn.ExecContext(ctx, "INSERT INTO test (value) VALUES (?)", w)
+				conn.Close()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != writers*perWriter {
+		t.Fatalf("count=%d err=%v want %d", n, err, writers*perWriter)
+	}
+}
+
+func TestOpenDB_OpensOnce(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var events []OpenEvent
+	db, err := OpenDB(filepath.Join(dir, "once.db"), ModeReadWriteCreate,
+		WithAuditHook(func(event OpenEvent) { events = append(events, event) }),
+		WithMigrations("CREATE TABLE test (id INTEGER PRIMARY KEY)"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	conn, err := db.WriteConn(ctx)
+	if err != nil {
+		t.Fatalf("write conn: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	conn.Close()
+
+	// The exclusive lock of heap mode leaves room for one connection, which serves both.
+	heap, err := OpenDB(filepath.Join(dir, "heap.db"), ModeReadWriteCreate, WithShmMode("heap"))
+	if err != nil {
+		t.Fatalf("open heap: %v", err)
+	}
+	defer heap.Close()
+	conn, err = heap.WriteConn(ctx)
+	if err != nil {
+		t.Fatalf("heap write conn: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY); INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("heap insert: %v", err)
+	}
+	conn.Close()
+	var n int
+	if err := heap.QueryRowContext(ctx, "SELECT count(*) FROM test").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("count=%d err=%v want 1", n, err)
+	}
+}
+
+func TestDB_WriteConnReadOnly(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "ro.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	rw.Close()
+	db, err := OpenDB(fn, ModeReadOnly)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.WriteConn(context.Background()); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestDB_GetSetPragma(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "pragma.db"), ModeReadWriteCreate, WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	if mode, err := db.GetPragma(ctx, "journal_mode"); err != nil || mode != "wal" {
+		t.Fatalf("journal_mode=%q err=%v want wal", mode, err)
+	}
+	// cache_size is per connection; the pool has just one.
+	if err := db.SetPragma(ctx, "cache_size", "-4096"); err != nil {
+		t.Fatalf("set cache_size: %v", err)
+	}
+	if size, err := db.GetPragma(ctx, "CACHE_SIZE"); err != nil || size != "-4096" {
+		t.Fatalf("cache_size=%q err=%v want -4096", size, err)
+	}
+
+	if _, err := db.GetPragma(ctx, "cache_size; DROP TABLE x"); !errors.Is(err, ErrInvalidPragma) {
+		t.Fatalf("expected ErrInvalidPragma for bad name, got %v", err)
+	}
+	if err := db.SetPragma(ctx, "user_version", "1; DROP TABLE x"); !errors.Is(err, ErrInvalidPragma) {
+		t.Fatalf("expected ErrInvalidPragma for bad value, got %v", err)
+	}
+	if _, err := db.GetPragma(ctx, "no_such_pragma"); !errors.Is(err, ErrInvalidPragma) {
+		t.Fatalf("expected ErrInvalidPragma for unknown pragma, got %v", err)
+	}
+	if count, err := db.GetPragma(ctx, "page_count"); err != nil || count == "0" {
+		t.Fatalf("page_count=%q err=%v", count, err)
+	}
+	for _, name := range []string{"writable_schema", "schema_version", "page_count"} {
+		if err := db.SetPragma(ctx, name, "1"); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption setting %s, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"writable_schema", "wal_checkpoint", "optimize"} {
+		if _, err := db.GetPragma(ctx, name); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption reading %s, got %v", name, err)
+		}
+	}
+}
+
+func TestAtomicReplace(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "live.db")
+	ctx := context.Background()
+	live, err := OpenDB(fn, ModeReadWriteCreate, WithMaxOpenConns(3), WithCacheSizeMiB(16))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { live.Close() }()
+	if _, err := live.Exec("CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('old')"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	// Build the replacement in a separate file.
+	rebuilt := filepath.Join(dir, "rebuilt.db")
+	b, err := OpenReadWriteCreate(rebuilt)
+	if err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if _, err := b.Exec("CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('new'), ('newer')"); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	b.Close()
+
+	if err := AtomicReplace(ctx, live, rebuilt); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	var n int
+	if err := live.QueryRow("SELECT COUNT(*) FROM t WHERE v LIKE 'new%'").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if got := live.Stats().MaxOpenConnections; got != 3 {
+		t.Fatalf("MaxOpenConnections = %d, want 3", got)
+	}
+	if got, err := live.GetPragma(ctx, "cache_size"); err != nil || got != "-16384" {
+		t.Fatalf("cache_size = %q (err=%v), want -16384", got, err)
+	}
+	conn, err := live.WriteConn(ctx)
+	if err != nil {
+		t.Fatalf("write conn: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES ('after')"); err != nil {
+		t.Fatalf("write after replace: %v", err)
+	}
+	conn.Close()
+	if _, err := os.Stat(rebuilt); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be gone, got %v", rebuilt, err)
+	}
+}
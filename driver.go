@@ -0,0 +1,648 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/cgo"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver wraps the go-sqlite3 driver for features that need per-connection state
+// database/sql does not provide. Every handle uses it, if only so that Snapshot can begin
+// a deferred read-only transaction; sql.Conn.Raw therefore returns a *sqliteConn, which
+// unwrapConn maps to the go-sqlite3 connection.
+type sqliteDriver struct {
+	*sqlite3.SQLiteDriver
+	cfg *openConfig
+}
+
+// sqliteConn wraps a go-sqlite3 connection. Embedding promotes all of the driver's
+// optional interfaces (ExecerContext, QueryerContext, ConnBeginTx, Pinger, ...).
+type sqliteConn struct {
+	*sqlite3.SQLiteConn
+	cfg       *openConfig
+	expiresAt time.Time    // zero if the connection never expires
+	progress  cgo.Handle   // per-connection progress handler, if installed
+	busy      cgo.Handle   // WithDeadlineAwareBusyHandler handler, if installed
+	busyStart time.Time    // when the handler was first called for the current lock
+	session   *connSession // change recorder for WithSession, if set
+	changes   *txChanges   // WithChangeNotifier events of the current transaction, if set
+	ioFailed  bool         // an I/O error was seen and WithAutoReconnect is set
+	label     uint64       // WithConnectionLabels id, 0 if unlabeled
+	eventID   int          // id passed to WithPoolEvents callbacks
+	schema    int64        // schema_version when opened, for WithReadOnlySchemaRefresh
+	idleSince time.Time    // when last returned to the pool, for WithValidationInterval
+	queryOnly bool         // in a read-only transaction (WithTxOptions)
+	discard   bool         // left in a state that must not be reused
+	openRows  atomic.Int32 // rows not yet closed, for WithBusyDiagnostics
+
+	mu     sync.Mutex
+	active map[uint64]statement // running statements
+	nextID uint64
+
+	// Set by the commit and rollback hooks (WithSession, WithChangeNotifier), consumed
+	// by flush.
+	committed, rolledBack bool
+}
+
+// statement is a running statement, tracked for the progress and busy handlers.
+type statement struct {
+	ctx   context.Context
+	start time.Time
+}
+
+// driver returns the driver.Driver to register for cfg.
+func (cfg *openConfig) driver() driver.Driver {
+	return &sqliteDriver{SQLiteDriver: &sqlite3.SQLiteDriver{}, cfg: cfg}
+}
+
+// readOnly reports whether cfg opens the database read-only.
+func (cfg *openConfig) readOnly() bool {
+	return cfg.params["mode"] == string(ModeReadOnly)
+}
+
+// optimizeOnConnect reports whether new connections run PRAGMA optimize. Read-only
+// connections never do: they cannot write the statistics ANALYZE gathers, so it would only
+// add latency to every connection.
+func (cfg *openConfig) optimizeOnConnect() bool {
+	return !cfg.disableOptimize && !cfg.readOnly()
+}
+
+// busyTimeout returns the busy timeout set with _busy_timeout (WithBusyTimeoutSeconds).
+func (cfg *openConfig) busyTimeout() time.Duration {
+	ms, _ := strconv.Atoi(cfg.params["_busy_timeout"])
+	return time.Duration(ms) * time.Millisecond
+}
+
+// sqliteConnector opens connections with the context of the call that needs them, which
+// database/sql only passes to a driver.Connector.
+type sqliteConnector struct {
+	driver *sqliteDriver
+	dsn    string
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *sqliteDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return &sqliteConnector{driver: d, dsn: dsn}, nil
+}
+
+// Connect implements driver.Connector.
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.open(ctx, c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Open implements driver.Driver.
+func (d *sqliteDriver) Open(dsn string) (driver.Conn, error) {
+	return d.open(context.Background(), dsn)
+}
+
+// open opens a connection for dsn, initializing it with cfg.connect. ctx only bounds
+// the waits between WithConnectHookErrorRecovery attempts.
+func (d *sqliteDriver) open(ctx context.Context, dsn string) (driver.Conn, error) {
+	hooked := *d.SQLiteDriver
+	hooked.ConnectHook = func(conn *sqlite3.SQLiteConn) error {
+		return d.cfg.connect(ctx, conn)
+	}
+	c, err := hooked.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn := &sqliteConn{SQLiteConn: c.(*sqlite3.SQLiteConn), cfg: d.cfg}
+	if d.cfg.connLabels != nil {
+		label := d.cfg.connLabels.Add(1)
+		conn.label = label
+		if err := conn.RegisterFunc("sqlitebp_conn_id", func() int64 { return int64(label) }, false); err != nil {
+			conn.Close()
+			return nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to register sqlitebp_conn_id: %w", err))
+		}
+	}
+	if d.cfg.connMaxLifetime > 0 {
+		lifetime := d.cfg.connMaxLifetime
+		if d.cfg.connMaxLifetimeJitter > 0 {
+			lifetime += time.Duration(rand.Int63n(int64(d.cfg.connMaxLifetimeJitter)))
+		}
+		conn.expiresAt = time.Now().Add(lifetime)
+	}
+	if d.cfg.interruptOnCancel || d.cfg.stepTimeout > 0 {
+		// The progress handler needs this connection's state, so it is installed here
+		// rather than from the ConnectHook, replacing any handler installed there.
+		ops := 1000
+		if d.cfg.progressFn != nil {
+			ops = d.cfg.progressOps
+		}
+		conn.progress = cgo.NewHandle(conn.progressHandler)
+		setProgressHandler(conn.SQLiteConn, ops, conn.progress)
+	}
+	if d.cfg.schemaRefresh {
+		if conn.schema, err = intPragma(conn.SQLiteConn, "schema_version"); err != nil {
+			conn.Close()
+			return nil, errors.Join(ErrPragmaExec, fmt.Errorf("failed to read schema_version: %w", err))
+		}
+	}
+	if d.cfg.deadlineBusy {
+		conn.busy = cgo.NewHandle(conn.busyHandler)
+		setBusyHandler(conn.SQLiteConn, conn.busy)
+	}
+	if d.cfg.sessionSink != nil {
+		session, err := newConnSession(conn.SQLiteConn, d.cfg.sessionTables, d.cfg.sessionSink)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.session = session
+	}
+	if d.cfg.changeNotifier != nil {
+		conn.changes = &txChanges{}
+		conn.RegisterUpdateHook(conn.changes.add)
+	}
+	if conn.session != nil || conn.changes != nil {
+		conn.RegisterCommitHook(func() int {
+			conn.committed = true
+			return 0
+		})
+		conn.RegisterRollbackHook(func() {
+			conn.rolledBack = true
+		})
+	}
+	if d.cfg.busyDiag != nil {
+		d.cfg.busyDiag.add(conn)
+	}
+	if events := d.cfg.poolEvents; events != nil {
+		if conn.label != 0 {
+			conn.eventID = int(conn.label)
+		} else {
+			conn.eventID = int(events.lastID.Add(1))
+		}
+		if events.onConnect != nil {
+			events.onConnect(conn.eventID)
+		}
+	}
+	return conn, nil
+}
+
+// progressHandler aborts the running statement when its context is done or it has run
+// longer than WithStatementTimeout, and otherwise defers to the user's handler from
+// WithProgressHandler.
+func (c *sqliteConn) progressHandler() bool {
+	c.mu.Lock()
+	for _, st := range c.active {
+		if c.cfg.interruptOnCancel && st.ctx.Err() != nil ||
+			c.cfg.stepTimeout > 0 && time.Since(st.start) > c.cfg.stepTimeout {
+			c.mu.Unlock()
+			return true
+		}
+	}
+	c.mu.Unlock()
+	return c.cfg.progressFn != nil && c.cfg.progressFn()
+}
+
+// busyDelays is the back-off of SQLite's default busy handler (sqliteDefaultBusyCallback).
+var busyDelays = [...]time.Duration{
+	1 * time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	15 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond, 25 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+}
+
+// busyHandler waits for a lock held by another connection like the busy timeout does, but
+// gives up once the context of a running statement is done or the next sleep would reach
+// its deadline (see WithDeadlineAwareBusyHandler).
+func (c *sqliteConn) busyHandler(count int) bool {
+	now := time.Now()
+	if count == 0 {
+		c.busyStart = now
+	}
+	remaining := c.cfg.busyTimeout() - now.Sub(c.busyStart)
+	c.mu.Lock()
+	for _, st := range c.active {
+		if st.ctx.Err() != nil {
+			c.mu.Unlock()
+			return false
+		}
+		if deadline, ok := st.ctx.Deadline(); ok {
+			remaining = min(remaining, deadline.Sub(now))
+		}
+	}
+	c.mu.Unlock()
+	if remaining <= 0 {
+		return false
+	}
+	time.Sleep(min(busyDelays[min(count, len(busyDelays)-1)], remaining))
+	return true
+}
+
+// track registers ctx as belonging to a running statement until the returned func is called.
+// A connection may have several statements in progress (e.g. nested queries in a
+// transaction); cancelling any of them interrupts whichever one is stepping.
+func (c *sqliteConn) track(ctx context.Context) func() {
+	if c.cfg.stepTimeout <= 0 && (!c.cfg.interruptOnCancel && !c.cfg.deadlineBusy || ctx.Done() == nil) {
+		return func() {}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == nil {
+		c.active = make(map[uint64]statement)
+	}
+	c.nextID++
+	id := c.nextID
+	c.active[id] = statement{ctx: ctx, start: time.Now()}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.active, id)
+	}
+}
+
+// checkError records an SQLITE_IOERR so the connection is discarded once it is returned
+// to the pool (WithAutoReconnect), reports busy errors of query (WithBusyDiagnostics),
+// marks writes attempted on a read-only handle with ErrReadOnly, and prefixes SQLite
+// errors with the connection label (WithConnectionLabels).
+func (c *sqliteConn) checkError(query string, err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	if c.cfg.busyDiag != nil {
+		c.cfg.busyDiag.report(c, query, err)
+	}
+	if c.cfg.autoReconnect && sqliteErr.Code == sqlite3.ErrIoErr {
+		c.ioFailed = true
+	}
+	if c.label != 0 {
+		err = fmt.Errorf("sqlitebp conn %d: %w", c.label, err)
+	}
+	if sqliteErr.Code == sqlite3.ErrReadonly && (c.cfg.readOnly() || c.queryOnly) {
+		err = errors.Join(ErrReadOnly, err)
+	}
+	return err
+}
+
+// withTimeout bounds ctx by the default statement timeout, if any. An earlier deadline
+// already on ctx still applies.
+func (c *sqliteConn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.cfg.statementTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.cfg.statementTimeout)
+}
+
+// contextError reports ctx.Err() in place of the SQLITE_INTERRUPT our progress handler caused.
+func contextError(ctx context.Context, err error) error {
+	var sqliteErr sqlite3.Error
+	if err != nil && ctx.Err() != nil && errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrInterrupt {
+		return ctx.Err()
+	}
+	return err
+}
+
+// filter applies WithStatementFilter to query. The open's own statements, such as
+// migrations, are not subject to it.
+func (c *sqliteConn) filter(query string) error {
+	if c.cfg.statementFilter == nil || !c.cfg.opened.Load() {
+		return nil
+	}
+	if err := c.cfg.statementFilter(query); err != nil {
+		return errors.Join(ErrStatementRejected, err)
+	}
+	return nil
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.filter(query); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	defer c.track(ctx)()
+	defer c.flush()
+	start := time.Now()
+	res, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	c.observeSlow(query, args, start)
+	return res, c.checkError(query, contextError(ctx, err))
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.filter(query); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	untrack := c.track(ctx)
+	start := time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		untrack()
+		cancel()
+		return nil, c.checkError(query, contextError(ctx, err))
+	}
+	c.openRows.Add(1)
+	return &sqliteRows{SQLiteRows: rows.(*sqlite3.SQLiteRows), conn: c, ctx: ctx, untrack: untrack, cancel: cancel,
+		query: query, args: args, start: start}, nil
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	// BEGIN IMMEDIATE waits for the write lock, so the busy handler needs ctx.
+	defer c.track(ctx)()
+	snapshot, _ := ctx.Value(snapshotKey{}).(bool)
+	if !snapshot && (!c.cfg.txOptions || (opts.Isolation == driver.IsolationLevel(sql.LevelDefault) && !opts.ReadOnly)) {
+		tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, c.checkError("BEGIN", err)
+		}
+		return &sqliteTx{Tx: tx, conn: c}, nil
+	}
+	begin, err := beginStatement(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Read-only handles are always query_only.
+	if opts.ReadOnly && !c.cfg.readOnly() {
+		if _, err := c.SQLiteConn.ExecContext(ctx, "PRAGMA query_only=ON", nil); err != nil {
+			return nil, c.checkError("PRAGMA query_only=ON", err)
+		}
+		c.queryOnly = true
+	}
+	if _, err := c.SQLiteConn.ExecContext(ctx, begin, nil); err != nil {
+		c.endQueryOnly()
+		return nil, c.checkError(begin, contextError(ctx, err))
+	}
+	return &sqliteTx{Tx: &mappedTx{conn: c}, conn: c}, nil
+}
+
+// snapshotKey marks the context of the read-only transaction Snapshot begins, which is
+// mapped like one under WithTxOptions whether or not that is set.
+type snapshotKey struct{}
+
+// beginStatement returns the BEGIN statement for opts under WithTxOptions.
+func beginStatement(opts driver.TxOptions) (string, error) {
+	if opts.ReadOnly {
+		return "BEGIN DEFERRED", nil
+	}
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelSerializable, sql.LevelLinearizable:
+		return "BEGIN IMMEDIATE", nil
+	case sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSnapshot:
+		return "BEGIN DEFERRED", nil
+	}
+	return "", fmt.Errorf("sqlitebp: unsupported isolation level %s", sql.IsolationLevel(opts.Isolation))
+}
+
+// endQueryOnly clears query_only after a read-only transaction. If that fails the
+// connection would stay read-only, so it is discarded instead.
+func (c *sqliteConn) endQueryOnly() {
+	if !c.queryOnly {
+		return
+	}
+	c.queryOnly = false
+	if _, err := c.SQLiteConn.ExecContext(context.Background(), "PRAGMA query_only=OFF", nil); err != nil {
+		c.discard = true
+	}
+}
+
+// mappedTx is a transaction started by BeginTx under WithTxOptions. It mirrors
+// go-sqlite3's SQLiteTx, which cannot be created with a custom BEGIN.
+type mappedTx struct {
+	conn *sqliteConn
+}
+
+// Commit implements driver.Tx.
+func (tx *mappedTx) Commit() error {
+	defer tx.conn.endQueryOnly()
+	_, err := tx.conn.SQLiteConn.ExecContext(context.Background(), "COMMIT", nil)
+	if err != nil {
+		// As in go-sqlite3: database/sql considers the transaction over either way.
+		tx.conn.SQLiteConn.ExecContext(context.Background(), "ROLLBACK", nil)
+	}
+	return err
+}
+
+// Rollback implements driver.Tx.
+func (tx *mappedTx) Rollback() error {
+	defer tx.conn.endQueryOnly()
+	_, err := tx.conn.SQLiteConn.ExecContext(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+// flush hands the changes of a just-completed transaction to the session and change
+// sinks. Hooks cannot run SQL or block, so this happens after the statement or COMMIT
+// returns.
+func (c *sqliteConn) flush() {
+	if !c.committed && !c.rolledBack {
+		return
+	}
+	committed := c.committed && !c.rolledBack
+	c.committed, c.rolledBack = false, false
+	if c.session != nil {
+		c.session.flush(committed)
+	}
+	if c.changes != nil {
+		if changes := c.changes.take(); committed && len(changes) > 0 {
+			c.cfg.changeNotifier.send(changes)
+		}
+	}
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.filter(query); err != nil {
+		return nil, err
+	}
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, c.checkError(query, err)
+	}
+	return &sqliteStmt{SQLiteStmt: stmt.(*sqlite3.SQLiteStmt), conn: c, query: query}, nil
+}
+
+// Close implements driver.Conn.
+func (c *sqliteConn) Close() error {
+	if c.session != nil {
+		c.session.close()
+		c.session = nil
+	}
+	if c.cfg.busyDiag != nil {
+		c.cfg.busyDiag.remove(c)
+	}
+	err := c.SQLiteConn.Close()
+	if c.progress != 0 {
+		c.progress.Delete()
+		c.progress = 0
+	}
+	if c.busy != 0 {
+		c.busy.Delete()
+		c.busy = 0
+	}
+	// eventID is only set once the connection was reported to onConnect.
+	if events := c.cfg.poolEvents; events != nil && events.onClose != nil && c.eventID != 0 {
+		events.onClose(c.eventID)
+	}
+	return err
+}
+
+// sqliteStmt tracks the contexts of prepared statement executions.
+type sqliteStmt struct {
+	*sqlite3.SQLiteStmt
+	conn  *sqliteConn
+	query string
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (s *sqliteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, cancel := s.conn.withTimeout(ctx)
+	defer cancel()
+	defer s.conn.track(ctx)()
+	defer s.conn.flush()
+	start := time.Now()
+	res, err := s.SQLiteStmt.ExecContext(ctx, args)
+	s.conn.observeSlow(s.query, args, start)
+	return res, s.conn.checkError(s.query, contextError(ctx, err))
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, cancel := s.conn.withTimeout(ctx)
+	untrack := s.conn.track(ctx)
+	start := time.Now()
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	if err != nil {
+		untrack()
+		cancel()
+		return nil, s.conn.checkError(s.query, contextError(ctx, err))
+	}
+	s.conn.openRows.Add(1)
+	return &sqliteRows{SQLiteRows: rows.(*sqlite3.SQLiteRows), conn: s.conn, ctx: ctx, untrack: untrack, cancel: cancel,
+		query: s.query, args: args, start: start}, nil
+}
+
+// sqliteTx flushes the session and change notifier after the transaction ends.
+type sqliteTx struct {
+	driver.Tx
+	conn *sqliteConn
+}
+
+// Commit implements driver.Tx.
+func (tx *sqliteTx) Commit() error {
+	defer tx.conn.flush()
+	return tx.conn.checkError("COMMIT", tx.Tx.Commit())
+}
+
+// Rollback implements driver.Tx.
+func (tx *sqliteTx) Rollback() error {
+	defer tx.conn.flush()
+	return tx.Tx.Rollback()
+}
+
+// sqliteRows keeps its query's context tracked, and its statement timeout running,
+// until the rows are closed, since statements step (and can run long) while the
+// caller iterates.
+type sqliteRows struct {
+	*sqlite3.SQLiteRows
+	conn    *sqliteConn
+	ctx     context.Context
+	untrack func()
+	cancel  context.CancelFunc
+
+	// For WithQueryPlanOnSlow and WithBusyDiagnostics.
+	query string
+	args  []driver.NamedValue
+	start time.Time
+}
+
+// Next implements driver.Rows.
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	return r.conn.checkError(r.query, contextError(r.ctx, r.SQLiteRows.Next(dest)))
+}
+
+// Close implements driver.Rows.
+func (r *sqliteRows) Close() error {
+	defer r.cancel()
+	defer r.untrack()
+	// A statement such as INSERT ... RETURNING commits when it is reset.
+	defer r.conn.flush()
+	defer r.conn.openRows.Add(-1)
+	err := r.SQLiteRows.Close()
+	r.conn.observeSlow(r.query, r.args, r.start)
+	return err
+}
+
+// expired reports whether the connection must be replaced: it is past its lifetime, hit
+// an I/O error (WithAutoReconnect), could not leave query_only mode or can no longer
+// record changes for its session.
+func (c *sqliteConn) expired() bool {
+	if c.ioFailed || c.discard || (c.session != nil && c.session.err != nil) {
+		return true
+	}
+	return !c.expiresAt.IsZero() && time.Now().After(c.expiresAt)
+}
+
+// ResetSession implements driver.SessionResetter. It runs before a pooled connection is
+// reused; returning driver.ErrBadConn makes database/sql discard it and pick another.
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	c.flush()
+	if events := c.cfg.poolEvents; events != nil && events.onReset != nil {
+		events.onReset(c.eventID)
+	}
+	if c.expired() || c.schemaChanged() || !c.validate(ctx) {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// validate checks a connection that has been idle for longer than WithValidationInterval.
+// Connections idle for less time, or never returned to the pool, are not checked.
+func (c *sqliteConn) validate(ctx context.Context) bool {
+	interval := c.cfg.validationInterval
+	if interval <= 0 || c.idleSince.IsZero() || time.Since(c.idleSince) <= interval {
+		return true
+	}
+	if fileMoved(c.SQLiteConn) {
+		return false
+	}
+	_, err := c.SQLiteConn.ExecContext(ctx, "SELECT 1 FROM sqlite_schema LIMIT 1", nil)
+	return err == nil
+}
+
+// schemaChanged reports whether the schema was changed since the connection was opened
+// (WithReadOnlySchemaRefresh). A failure to check counts as a change.
+func (c *sqliteConn) schemaChanged() bool {
+	if !c.cfg.schemaRefresh {
+		return false
+	}
+	version, err := intPragma(c.SQLiteConn, "schema_version")
+	return err != nil || version != c.schema
+}
+
+// IsValid implements driver.Validator. It runs when a connection is returned to the pool;
+// invalid connections are closed instead of being kept idle.
+func (c *sqliteConn) IsValid() bool {
+	if c.cfg.validationInterval > 0 {
+		c.idleSince = time.Now()
+	}
+	return !c.expired()
+}
+
+// unwrapConn returns the go-sqlite3 connection behind a driver connection from sql.Conn.Raw.
+func unwrapConn(dc any) (*sqlite3.SQLiteConn, bool) {
+	switch c := dc.(type) {
+	case *sqlite3.SQLiteConn:
+		return c, true
+	case *sqliteConn:
+		return c.SQLiteConn, true
+	}
+	return nil, false
+}
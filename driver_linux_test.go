@@ -0,0 +1,66 @@
+package sqlitebp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// breakFileHandles points every descriptor this process holds on filename at a
+// directory, so that further I/O through them fails like on a broken volume.
+func breakFileHandles(t *testing.T, filename string) {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot inspect file descriptors: %v", err)
+	}
+	dir, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("open dir: %v", err)
+	}
+	defer dir.Close()
+	broken := 0
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", e.Name()))
+		if err != nil || target != filename {
+			continue
+		}
+		fd, _ := strconv.Atoi(e.Name())
+		if err := syscall.Dup3(int(dir.Fd()), fd, 0); err != nil {
+			t.Fatalf("dup3: %v", err)
+		}
+		broken++
+	}
+	if broken == 0 {
+		t.Fatalf("no open descriptors for %s", filename)
+	}
+}
+
+func TestWithAutoReconnect_DiscardsConnectionAfterIOError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ioerr.db")
+	db, err := OpenReadWriteCreate(filename, WithJournalMode("DELETE"), WithMaxOpenConns(1), WithAutoReconnect())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY); INSERT INTO test VALUES (1)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	breakFileHandles(t, filename)
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n)
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrIoErr {
+		t.Fatalf("expected SQLITE_IOERR from broken handle, got %v", err)
+	}
+	// The poisoned connection was discarded; this query runs on a fresh one.
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("count=%d err=%v after reconnect", n, err)
+	}
+}
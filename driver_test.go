@@ -0,0 +1,613 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// holdConns checks out n distinct connections from db.
+func holdConns(t *testing.T, db *sql.DB, n int) []*sql.Conn {
+	t.Helper()
+	conns := make([]*sql.Conn, n)
+	for i := range conns {
+		c, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("conn: %v", err)
+		}
+		conns[i] = c
+	}
+	return conns
+}
+
+// connExpiry returns the expiry assigned to the driver connection behind c.
+func connExpiry(t *testing.T, c *sql.Conn) time.Time {
+	t.Helper()
+	var expiresAt time.Time
+	if err := c.Raw(func(dc any) error {
+		expiresAt = dc.(*sqliteConn).expiresAt
+		return nil
+	}); err != nil {
+		t.Fatalf("raw: %v", err)
+	}
+	return expiresAt
+}
+
+func TestWithConnMaxLifetimeJitter_SpreadsExpiry(t *testing.T) {
+	const base, jitter = time.Hour, 10 * time.Minute
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "jitter.db"), WithConnMaxLifetimeJitter(base, jitter), WithMaxOpenConns(8))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	conns := holdConns(t, db, 8)
+	var earliest, latest time.Time
+	for i, c := range conns {
+		e := connExpiry(t, c)
+		if i == 0 || e.Before(earliest) {
+			earliest = e
+		}
+		if i == 0 || e.After(latest) {
+			latest = e
+		}
+		c.Close()
+	}
+	if earliest.Sub(start) < base-time.Minute || latest.Sub(start) > base+jitter+time.Minute {
+		t.Errorf("expiries outside [base, base+jitter): %v..%v", earliest.Sub(start), latest.Sub(start))
+	}
+	// Eight draws from a 10 minute window landing within one second of each other is vanishingly unlikely.
+	if latest.Sub(earliest) < time.Second {
+		t.Errorf("expiries clustered: spread %v", latest.Sub(earliest))
+	}
+}
+
+func TestWithConnMaxLifetime_ReplacesExpiredConnections(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "lifetime.db"), WithConnMaxLifetime(50*time.Millisecond), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	conns := holdConns(t, db, 1)
+	first := connExpiry(t, conns[0])
+	conns[0].Close()
+	time.Sleep(100 * time.Millisecond)
+	conns = holdConns(t, db, 1)
+	defer conns[0].Close()
+	second := connExpiry(t, conns[0])
+	if !second.After(first) {
+		t.Errorf("expired connection was reused")
+	}
+	// Raw access through the wrapper still reaches the go-sqlite3 connection.
+	if err := conns[0].Raw(func(dc any) error {
+		if _, ok := unwrapConn(dc); !ok {
+			t.Errorf("unwrap failed for %T", dc)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("raw: %v", err)
+	}
+}
+
+func TestDriver_WrappedByDefault(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "plain.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conns := holdConns(t, db, 1)
+	defer conns[0].Close()
+	if err := conns[0].Raw(func(dc any) error {
+		if _, ok := dc.(*sqliteConn); !ok {
+			t.Errorf("got %T want *sqliteConn", dc)
+		}
+		if _, ok := unwrapConn(dc); !ok {
+			t.Errorf("unwrap failed for %T", dc)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("raw: %v", err)
+	}
+}
+
+func TestReadOnly_WriteReturnsErrReadOnly(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "ro.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := rw.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	rw.Close()
+
+	db, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Exec: expected ErrReadOnly, got %v", err)
+	}
+	stmt, err := db.Prepare("INSERT INTO t VALUES (?)")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(1); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Stmt.Exec: expected ErrReadOnly, got %v", err)
+	}
+	// The SQLite error is still available.
+	var sqliteErr sqlite3.Error
+	if _, err := db.Exec("DELETE FROM t"); !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrReadonly {
+		t.Fatalf("expected SQLITE_READONLY, got %v", err)
+	}
+	// Other errors are not affected.
+	if _, err := db.Exec("SELECT * FROM missing"); err == nil || errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a non-read-only error, got %v", err)
+	}
+}
+
+func TestReadOnly_QueryOnly(t *testing.T) {
+	ctx := context.Background()
+	fn := filepath.Join(t.TempDir(), "ro.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := rw.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	rw.Close()
+
+	db, err := OpenReadOnly(fn, WithMaxOpenConns(1), WithTxOptions(),
+		WithInitSQL("CREATE TEMP VIEW doubled AS SELECT 2 * x AS x FROM t"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	queryOnly := func() bool {
+		t.Helper()
+		var on bool
+		if err := db.QueryRow("PRAGMA query_only").Scan(&on); err != nil {
+			t.Fatalf("query_only: %v", err)
+		}
+		return on
+	}
+	if !queryOnly() {
+		t.Fatal("query_only is off on a read-only connection")
+	}
+	// mode=ro alone allows TEMP writes.
+	var sqliteErr sqlite3.Error
+	if _, err := db.Exec("CREATE TEMP TABLE scratch (x INTEGER)"); !errors.Is(err, ErrReadOnly) || !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrReadonly {
+		t.Fatalf("expected ErrReadOnly creating a TEMP table, got %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM doubled").Scan(&n); err != nil {
+		t.Fatalf("init SQL TEMP view: %v", err)
+	}
+
+	// Ending a read-only transaction or a snapshot leaves it on.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	tx.Rollback()
+	if !queryOnly() {
+		t.Fatal("query_only cleared by a read-only transaction")
+	}
+	if err := Snapshot(ctx, db, func(*sql.Tx) error { return nil }); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if !queryOnly() {
+		t.Fatal("query_only cleared by Snapshot")
+	}
+}
+
+func TestWithInterruptOnCancel_StopsLongQuery(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "interrupt.db"), WithInterruptOnCancel())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n"
+	for _, run := range []struct {
+		name string
+		fn   func(ctx context.Context) error
+	}{
+		{"query", func(ctx context.Context) error {
+			var n int
+			return db.QueryRowContext(ctx, endless).Scan(&n)
+		}},
+		{"exec", func(ctx context.Context) error {
+			_, err := db.ExecContext(ctx, "CREATE TEMP TABLE IF NOT EXISTS t AS "+endless)
+			return err
+		}},
+		{"prepared", func(ctx context.Context) error {
+			stmt, err := db.PrepareContext(context.Background(), endless)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+			var n int
+			return stmt.QueryRowContext(ctx).Scan(&n)
+		}},
+	} {
+		t.Run(run.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			err := run.fn(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("returned after %v", elapsed)
+			}
+		})
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("connection unusable after interrupt: n=%d err=%v", n, err)
+	}
+}
+
+func TestWithDefaultStatementTimeout(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "timeout.db"),
+		WithFunc("sleep_ms", func(ms int64) int64 {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return ms
+		}, false),
+		WithDefaultStatementTimeout(200*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	// 100 rows of 20ms each is far past the default timeout.
+	slow := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100) SELECT sum(sleep_ms(20)) FROM n"
+	start := time.Now()
+	var n int
+	if err := db.QueryRow(slow).Scan(&n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("default timeout returned after %v", elapsed)
+	}
+
+	// A shorter deadline on the caller's context still wins.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := db.ExecContext(ctx, "CREATE TEMP TABLE t AS "+slow); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("caller deadline returned after %v", elapsed)
+	}
+
+	if err := db.QueryRow("SELECT sleep_ms(1)").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("fast query: n=%d err=%v", n, err)
+	}
+}
+
+func TestWithStatementTimeout(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "timeout.db"), WithStatementTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	// An unbounded recursive query never finishes on its own.
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n"
+	start := time.Now()
+	var n int
+	err = db.QueryRow(endless).Scan(&n)
+	elapsed := time.Since(start)
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrInterrupt {
+		t.Fatalf("expected SQLITE_INTERRUPT, got %v", err)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("statement aborted after %v, want about 200ms", elapsed)
+	}
+
+	// The limit applies per statement, so the connection keeps working.
+	if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("fast query: n=%d err=%v", n, err)
+	}
+}
+
+func TestWithDeadlineAwareBusyHandler(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "busy.db"),
+		WithBusyTimeoutSeconds(10), WithDeadlineAwareBusyHandler())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	// Hold the write lock so every other writer has to wait for it.
+	holder, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer holder.Rollback()
+
+	waitFor := func(name string, fn func(ctx context.Context) error) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := fn(ctx)
+		elapsed := time.Since(start)
+		if err == nil {
+			t.Fatalf("%s: expected an error while the write lock is held", name)
+		}
+		if elapsed > 2*time.Second {
+			t.Errorf("%s gave up after %v, want about the 200ms deadline, not the 10s busy timeout", name, elapsed)
+		}
+	}
+	waitFor("exec", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "INSERT INTO test DEFAULT VALUES")
+		return err
+	})
+	waitFor("begin", func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err == nil {
+			tx.Rollback()
+		}
+		return err
+	})
+
+	// Without a deadline the handler keeps waiting, so the write goes through once the
+	// lock is released.
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.Exec("INSERT INTO test DEFAULT VALUES")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := holder.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("insert after release: %v", err)
+	}
+}
+
+func TestWithPoolEvents(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		open    = map[int]bool{}
+		connect int
+		reset   int
+		closed  int
+	)
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "events.db"),
+		WithMaxOpenConns(3),
+		WithPoolEvents(
+			func(id int) {
+				mu.Lock()
+				defer mu.Unlock()
+				connect++
+				open[id] = true
+			},
+			func(id int) {
+				mu.Lock()
+				defer mu.Unlock()
+				reset++
+			},
+			func(id int) {
+				mu.Lock()
+				defer mu.Unlock()
+				closed++
+				if !open[id] {
+					t.Errorf("close of unknown connection %d", id)
+				}
+				delete(open, id)
+			},
+		))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	conns := holdConns(t, db, 3)
+	for _, c := range conns {
+		if _, err := c.ExecContext(context.Background(), "SELECT 1"); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		c.Close()
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.Exec("SELECT 1"); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connect < 3 || connect != closed || len(open) != 0 {
+		t.Errorf("connects=%d closes=%d still open=%v", connect, closed, open)
+	}
+	if reset == 0 {
+		t.Errorf("expected reused connections to be reset")
+	}
+}
+
+func TestWithConnectionLabels_DistinctAcrossPool(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "labels.db"), WithMaxOpenConns(4), WithConnectionLabels())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	seen := make(map[uint64]bool)
+	for _, c := range holdConns(t, db, 4) {
+		defer c.Close()
+		label, err := ConnectionLabel(c)
+		if err != nil || label == 0 {
+			t.Fatalf("label=%d err=%v", label, err)
+		}
+		if seen[label] {
+			t.Fatalf("label %d assigned twice", label)
+		}
+		seen[label] = true
+		var fromSQL uint64
+		if err := c.QueryRowContext(ctx, "SELECT sqlitebp_conn_id()").Scan(&fromSQL); err != nil || fromSQL != label {
+			t.Fatalf("sqlitebp_conn_id()=%d err=%v want %d", fromSQL, err, label)
+		}
+		_, err = c.ExecContext(ctx, "SELECT * FROM missing")
+		var sqliteErr sqlite3.Error
+		if !errors.As(err, &sqliteErr) || !strings.HasPrefix(err.Error(), fmt.Sprintf("sqlitebp conn %d: ", label)) {
+			t.Fatalf("expected labeled sqlite3.Error, got %v", err)
+		}
+	}
+
+	plain, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "plain.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer plain.Close()
+	c, err := plain.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer c.Close()
+	if label, err := ConnectionLabel(c); err != nil || label != 0 {
+		t.Fatalf("unlabeled handle: label=%d err=%v", label, err)
+	}
+}
+
+func TestWithReadOnlySchemaRefresh(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "schema.db")
+	writer, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("open writer: %v", err)
+	}
+	defer writer.Close()
+	if _, err := writer.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY); INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	reader, err := OpenReadOnly(fn, WithReadOnlySchemaRefresh(), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open reader: %v", err)
+	}
+	defer reader.Close()
+	stmt, err := reader.Prepare("SELECT * FROM test")
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	defer stmt.Close()
+	columns := func() []string {
+		t.Helper()
+		rows, err := stmt.Query()
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatalf("columns: %v", err)
+		}
+		return cols
+	}
+	if got := columns(); !reflect.DeepEqual(got, []string{"id"}) {
+		t.Fatalf("columns = %v, want [id]", got)
+	}
+
+	if _, err := writer.Exec("ALTER TABLE test ADD COLUMN name TEXT"); err != nil {
+		t.Fatalf("alter: %v", err)
+	}
+	// The prepared statement is rebuilt on a fresh connection that knows the new column.
+	if got := columns(); !reflect.DeepEqual(got, []string{"id", "name"}) {
+		t.Fatalf("columns after ALTER = %v, want [id name]", got)
+	}
+
+	if _, err := OpenReadWrite(fn, WithReadOnlySchemaRefresh()); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a writable open, got %v", err)
+	}
+}
+
+func TestWithValidationInterval(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "validate.db")
+	create := func(value string) {
+		t.Helper()
+		db, err := OpenReadWriteCreate(fn)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("CREATE TABLE test (value TEXT); INSERT INTO test VALUES (?)", value); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	read := func(db *sql.DB) string {
+		t.Helper()
+		var value string
+		if err := db.QueryRow("SELECT value FROM test").Scan(&value); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		return value
+	}
+	create("old")
+
+	for _, tc := range []struct {
+		opts []Option
+		want string
+	}{
+		// The idle connection still has the deleted file open.
+		{nil, "old"},
+		{[]Option{WithValidationInterval(10 * time.Millisecond)}, "new"},
+	} {
+		db, err := OpenReadOnly(fn, append(tc.opts, WithMaxOpenConns(1))...)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if got := read(db); got != "old" {
+			t.Fatalf("value=%q before replacing the file", got)
+		}
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(fn + suffix)
+		}
+		create("new")
+		time.Sleep(30 * time.Millisecond)
+		if got := read(db); got != tc.want {
+			t.Errorf("%d options: value=%q after replacing the file, want %q", len(tc.opts), got, tc.want)
+		}
+		// A connection idle for less than the interval is reused without a check.
+		if got := read(db); got != tc.want {
+			t.Errorf("%d options: value=%q on reuse, want %q", len(tc.opts), got, tc.want)
+		}
+		db.Close()
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(fn + suffix)
+		}
+		create("old")
+	}
+
+	if _, err := OpenReadOnly(fn, WithValidationInterval(0)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package sqlitebp
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Feature identifies an optional SQLite capability that depends on compile options.
+type Feature string
+
+const (
+	// FeatureLoadExtension is run-time loading of extensions (sqlite3_load_extension).
+	FeatureLoadExtension Feature = "load_extension"
+	// FeatureFTS5 is the FTS5 full-text search module.
+	FeatureFTS5 Feature = "fts5"
+	// FeatureJSON is the built-in JSON SQL functions.
+	FeatureJSON Feature = "json"
+	// FeatureSession is the session extension (SQLITE_ENABLE_SESSION with
+	// SQLITE_ENABLE_PREUPDATE_HOOK), used by WithSession.
+	FeatureSession Feature = "session"
+)
+
+// featureChecks maps each feature to a predicate over the compile options and a
+// human-readable description used in errors.
+var featureChecks = map[Feature]struct {
+	available   func(opts map[string]bool) bool
+	description string
+}{
+	FeatureLoadExtension: {
+		available:   func(opts map[string]bool) bool { return !opts["OMIT_LOAD_EXTENSION"] },
+		description: "extension loading",
+	},
+	FeatureFTS5: {
+		available:   func(opts map[string]bool) bool { return opts["ENABLE_FTS5"] },
+		description: "FTS5",
+	},
+	FeatureJSON: {
+		// JSON is built in since 3.38.0 unless explicitly omitted.
+		available:   func(opts map[string]bool) bool { return !opts["OMIT_JSON"] },
+		description: "JSON",
+	},
+	FeatureSession: {
+		available:   func(opts map[string]bool) bool { return opts["ENABLE_SESSION"] && opts["ENABLE_PREUPDATE_HOOK"] },
+		description: "the session extension",
+	},
+}
+
+// compileOptions returns the set of options reported by PRAGMA compile_options,
+// with any "=value" suffix stripped (e.g. "THREADSAFE=1" is stored as "THREADSAFE").
+func compileOptions(conn *sqlite3.SQLiteConn) (map[string]bool, error) {
+	rows, err := conn.Query("PRAGMA compile_options", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	opts := make(map[string]bool)
+	dest := make([]driver.Value, 1)
+	for {
+		if err := rows.Next(dest); err != nil {
+			if err == io.EOF {
+				return opts, nil
+			}
+			return nil, err
+		}
+		var opt string
+		switch v := dest[0].(type) {
+		case string:
+			opt = v
+		case []byte:
+			opt = string(v)
+		}
+		name, _, _ := strings.Cut(opt, "=")
+		opts[name] = true
+	}
+}
+
+// checkFeatures returns ErrFeatureUnavailable describing the first feature not
+// compiled into the SQLite build described by opts.
+func checkFeatures(opts map[string]bool, features []Feature) error {
+	for _, f := range features {
+		check, ok := featureChecks[f]
+		if !ok {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("unknown feature %q", f))
+		}
+		if !check.available(opts) {
+			return errors.Join(ErrFeatureUnavailable, fmt.Errorf("%s not compiled into this SQLite build", check.description))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckFeatures_MissingLoadExtension(t *testing.T) {
+	opts := map[string]bool{"OMIT_LOAD_EXTENSION": true, "THREADSAFE": true}
+	err := checkFeatures(opts, []Feature{FeatureJSON, FeatureLoadExtension})
+	if !errors.Is(err, ErrFeatureUnavailable) {
+		t.Fatalf("expected ErrFeatureUnavailable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "extension loading not compiled into this SQLite build") {
+		t.Errorf("unexpected message: %v", err)
+	}
+	if err := checkFeatures(map[string]bool{}, []Feature{FeatureLoadExtension, FeatureJSON}); err != nil {
+		t.Errorf("expected features available, got %v", err)
+	}
+}
+
+func TestOpen_RequiredFeatureUnavailable(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "features.db")
+	db, err := OpenReadWriteCreate(fn, WithRequiredFeatures(FeatureFTS5))
+	if err == nil {
+		// Built with the sqlite_fts5 tag; the feature is present.
+		db.Close()
+		t.Skip("FTS5 compiled into this build")
+	}
+	if !errors.Is(err, ErrFeatureUnavailable) || !strings.Contains(err.Error(), "FTS5 not compiled into this SQLite build") {
+		t.Fatalf("expected friendly FTS5 error, got %v", err)
+	}
+}
+
+func TestOpen_RequiredFeatureAvailable(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "json.db")
+	db, err := OpenReadWriteCreate(fn, WithRequiredFeatures(FeatureJSON))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.Close()
+}
+
+func TestOpen_UnknownFeature(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "unknown.db")
+	if _, err := OpenReadWriteCreate(fn, WithRequiredFeatures("bogus")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected invalid option, got %v", err)
+	}
+}
+
+func TestOpen_LoadExtensionMissingLibrary(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "ext.db")
+	if _, err := OpenReadWriteCreate(fn, WithLoadExtension(filepath.Join(t.TempDir(), "missing.so"), "")); err == nil {
+		t.Fatalf("expected load failure")
+	}
+}
+
+func TestWithMinimumSQLiteVersion(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "version.db")
+	db, err := OpenReadWriteCreate(fn, WithMinimumSQLiteVersion(3, 35, 0))
+	if err != nil {
+		t.Fatalf("current version rejected: %v", err)
+	}
+	db.Close()
+
+	_, err = OpenReadWriteCreate(fn, WithMinimumSQLiteVersion(3, 999, 0))
+	if !errors.Is(err, ErrFeatureUnavailable) || !strings.Contains(err.Error(), "older than the required 3.999.0") {
+		t.Fatalf("expected ErrFeatureUnavailable for future version, got %v", err)
+	}
+	if _, err := OpenReadWriteCreate(fn, WithMinimumSQLiteVersion(2, 8, 17)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
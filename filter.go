@@ -0,0 +1,163 @@
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrStatementRejected indicates a statement was refused by WithStatementFilter.
+var ErrStatementRejected = errors.New("sqlitebp: statement rejected")
+
+// SelectOnlyFilter returns a WithStatementFilter function that accepts a single SELECT
+// statement (optionally with a WITH clause) reading only allowedTables, compared without
+// regard to case; if none are given any table may be read. Table names are taken from
+// FROM and JOIN clauses, including table-valued functions, which must be allowed like
+// tables. A name qualified with a schema must be allowed in that form ("aux.items").
+//
+// The check is lexical and best-effort. For defense in depth, run the statements on a
+// read-only handle, whose connections are query_only, and register an authorizer with
+// RegisterAuthorizer in WithConnectHook, which SQLite consults with the exact tables and
+// columns a statement reads.
+func SelectOnlyFilter(allowedTables ...string) func(query string) error {
+	allowed := make(map[string]bool, len(allowedTables))
+	for _, table := range allowedTables {
+		allowed[strings.ToLower(table)] = true
+	}
+	return func(query string) error {
+		tokens := sqlTokens(query)
+		for len(tokens) > 0 && isSymbol(tokens[len(tokens)-1], ";") {
+			tokens = tokens[:len(tokens)-1]
+		}
+		if len(tokens) == 0 {
+			return fmt.Errorf("empty statement")
+		}
+		if first := strings.ToUpper(tokens[0].text); tokens[0].quoted || (first != "SELECT" && first != "WITH") {
+			return fmt.Errorf("only SELECT statements are allowed, got %s", tokens[0].text)
+		}
+		ctes := map[string]bool{}
+		if strings.EqualFold(tokens[0].text, "WITH") {
+			for _, name := range cteNames(tokens) {
+				ctes[strings.ToLower(name)] = true
+			}
+		}
+		for i, t := range tokens {
+			if isSymbol(t, ";") {
+				return fmt.Errorf("multiple statements are not allowed")
+			}
+			if t.quoted || (!strings.EqualFold(t.text, "FROM") && !strings.EqualFold(t.text, "JOIN")) {
+				continue
+			}
+			for _, table := range fromTables(tokens[i+1:]) {
+				name := strings.ToLower(table)
+				if len(allowed) > 0 && !allowed[name] && !ctes[name] {
+					return fmt.Errorf("table %q is not allowed", table)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// isSymbol reports whether t is the unquoted punctuation s.
+func isSymbol(t sqlToken, s string) bool {
+	return !t.quoted && t.text == s
+}
+
+// cteNames returns the names of the common table expressions of a statement starting
+// with WITH.
+func cteNames(tokens []sqlToken) []string {
+	var names []string
+	depth := 0
+	expectName := true
+	for _, t := range tokens[1:] {
+		switch {
+		case isSymbol(t, "("):
+			depth++
+		case isSymbol(t, ")"):
+			depth--
+		case depth > 0:
+		case isSymbol(t, ","):
+			expectName = true
+		case !t.quoted && strings.EqualFold(t.text, "RECURSIVE"):
+		case !t.quoted && strings.EqualFold(t.text, "SELECT"):
+			return names
+		case expectName:
+			names = append(names, t.text)
+			expectName = false
+		}
+	}
+	return names
+}
+
+// fromTables returns the tables named by the FROM clause or join in tokens, which follow
+// the FROM or JOIN keyword: comma-separated table references, each optionally qualified
+// by a schema and followed by an alias. Subqueries are skipped; the caller checks their
+// own FROM clauses. Parenthesized table references, such as "(secrets)", are not.
+func fromTables(tokens []sqlToken) []string {
+	var tables []string
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		if isSymbol(t, "(") {
+			if i+1 < len(tokens) && !isSubqueryStart(tokens[i+1]) {
+				tables = append(tables, fromTables(tokens[i+1:])...)
+			}
+		} else {
+			if !t.quoted && !isIdentStart(t.text) {
+				return tables
+			}
+			name := t.text
+			i++
+			if i+1 < len(tokens) && isSymbol(tokens[i], ".") {
+				name += "." + tokens[i+1].text
+				i += 2
+			}
+			tables = append(tables, name)
+		}
+		// Skip a subquery, the arguments of a table-valued function, an alias and any join
+		// constraint, up to the next comma at this level.
+		depth := 0
+		for ; i < len(tokens); i++ {
+			if isSymbol(tokens[i], "(") {
+				depth++
+			} else if isSymbol(tokens[i], ")") {
+				if depth == 0 {
+					return tables
+				}
+				depth--
+			} else if depth == 0 && isSymbol(tokens[i], ",") {
+				break
+			} else if depth == 0 && !tokens[i].quoted && isClauseKeyword(tokens[i].text) {
+				return tables
+			}
+		}
+		i++
+	}
+	return tables
+}
+
+// isSubqueryStart reports whether t, the first token inside parentheses in a FROM clause,
+// starts a subquery rather than a table reference.
+func isSubqueryStart(t sqlToken) bool {
+	switch strings.ToUpper(t.text) {
+	case "SELECT", "WITH", "VALUES":
+		return !t.quoted
+	}
+	return false
+}
+
+// isIdentStart reports whether s, an unquoted token, is a word rather than punctuation.
+func isIdentStart(s string) bool {
+	c := s[0]
+	return c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// isClauseKeyword reports whether s ends a FROM clause's list of tables.
+func isClauseKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "WHERE", "GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT", "UNION", "INTERSECT", "EXCEPT",
+		"JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "NATURAL", "ON", "USING", "SELECT", "RETURNING":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectOnlyFilter(t *testing.T) {
+	filter := SelectOnlyFilter("items", "Tags", "aux.notes", "json_each")
+	for _, tc := range []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT * FROM items", true},
+		{"  select name FROM Items WHERE id = ?;", true},
+		{"SELECT i.name, t.tag FROM items AS i JOIN tags t ON t.item_id = i.id", true},
+		{"SELECT * FROM items, tags", true},
+		{"SELECT * FROM aux.notes", true},
+		{"SELECT value FROM json_each(?)", true},
+		{"SELECT * FROM (SELECT id FROM items) s, tags", true},
+		{"WITH recent AS (SELECT * FROM items) SELECT * FROM recent", true},
+		{"SELECT 'DELETE FROM secrets' -- FROM secrets", true},
+		{"SELECT 1", true},
+		{"SELECT * FROM (items)", true},
+		{"SELECT * FROM (items i JOIN tags t ON t.item_id = i.id)", true},
+		{"SELECT * FROM (VALUES (1), (2))", true},
+
+		{"DELETE FROM items", false},
+		{"INSERT INTO items SELECT * FROM items", false},
+		{"/* SELECT */ PRAGMA table_info(items)", false},
+		{"SELECT * FROM secrets", false},
+		{"SELECT * FROM items JOIN secrets ON 1", false},
+		{"SELECT * FROM items, secrets", false},
+		{"SELECT * FROM (SELECT id FROM items) s, secrets", false},
+		{"SELECT * FROM items WHERE id IN (SELECT id FROM secrets)", false},
+		{"SELECT * FROM main.items", false},
+		{`SELECT * FROM "secrets"`, false},
+		{"SELECT name FROM sqlite_schema", false},
+		{"SELECT 1; DELETE FROM items", false},
+		{"SELECT * FROM (secrets)", false},
+		{"SELECT * FROM items, (secrets)", false},
+		{"SELECT * FROM items JOIN (secrets)", false},
+		{"SELECT * FROM ((items JOIN secrets))", false},
+		{"", false},
+	} {
+		if err := filter(tc.query); (err == nil) != tc.ok {
+			t.Errorf("filter(%q) = %v, want ok=%v", tc.query, err, tc.ok)
+		}
+	}
+	if err := SelectOnlyFilter()("SELECT * FROM anything"); err != nil {
+		t.Errorf("filter without tables rejected a SELECT: %v", err)
+	}
+}
+
+func TestWithStatementFilter(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.db")
+	// The open's own statements are not filtered.
+	db, err := OpenReadWriteCreate(fn, WithStatementFilter(SelectOnlyFilter("items")),
+		WithMigrations("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO items (name) VALUES ('a'), ('b')"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM items").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("count=%d err=%v, want 2", n, err)
+	}
+	if _, err := db.Exec("DELETE FROM items"); !errors.Is(err, ErrStatementRejected) {
+		t.Fatalf("expected ErrStatementRejected, got %v", err)
+	}
+	if _, err := db.Prepare("DELETE FROM items"); !errors.Is(err, ErrStatementRejected) {
+		t.Fatalf("expected ErrStatementRejected preparing, got %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_schema").Scan(&n); !errors.Is(err, ErrStatementRejected) {
+		t.Fatalf("expected ErrStatementRejected for a table not allowed, got %v", err)
+	}
+	if _, err := OpenReadWrite(fn, WithStatementFilter(SelectOnlyFilter()), WithStatementFilter(SelectOnlyFilter())); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+
+	fromConfig, err := OpenWithConfig(fn, ModeReadWrite, Config{StatementFilter: SelectOnlyFilter("items")})
+	if err != nil {
+		t.Fatalf("open with config: %v", err)
+	}
+	defer fromConfig.Close()
+	if _, err := fromConfig.Exec("DELETE FROM items"); !errors.Is(err, ErrStatementRejected) {
+		t.Fatalf("expected ErrStatementRejected from Config.StatementFilter, got %v", err)
+	}
+}
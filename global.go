@@ -0,0 +1,119 @@
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// GlobalOption configures process-wide SQLite settings, see Configure.
+type GlobalOption func(*globalConfig) error
+
+type globalConfig struct {
+	threading    int
+	mmapSet      bool
+	mmapDefault  int64
+	mmapMaxLimit int64
+}
+
+var configureState struct {
+	sync.Mutex
+	done bool
+}
+
+// Configure applies process-global SQLite settings (sqlite3_config). SQLite only accepts
+// them before it is initialized, which happens when the first connection is opened by
+// any package in the process, so Configure must be called before the first Open, e.g. at
+// the start of main. Once it has succeeded, later calls return an error wrapping
+// ErrGlobalConfig, as does a call made after a connection was opened. Invalid options are
+// reported before anything is applied and leave Configure callable again.
+func Configure(opts ...GlobalOption) error {
+	cfg := &globalConfig{}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return err
+		}
+	}
+	configureState.Lock()
+	defer configureState.Unlock()
+	if configureState.done {
+		return errors.Join(ErrGlobalConfig, fmt.Errorf("Configure already called"))
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	configureState.done = true
+	return nil
+}
+
+// apply calls sqlite3_config for each setting. If a later setting fails, the threading
+// mode is put back to go-sqlite3's serialized default, which succeeds unless SQLite was
+// initialized in between.
+func (cfg *globalConfig) apply() error {
+	if cfg.threading != 0 {
+		if err := configThreading(cfg.threading); err != nil {
+			return errors.Join(ErrGlobalConfig, err)
+		}
+	}
+	if cfg.mmapSet {
+		if err := configMMapSize(cfg.mmapDefault, cfg.mmapMaxLimit); err != nil {
+			if cfg.threading != 0 {
+				err = errors.Join(err, configThreading(3)) // SQLITE_CONFIG_SERIALIZED
+			}
+			return errors.Join(ErrGlobalConfig, err)
+		}
+	}
+	return nil
+}
+
+// WithThreadingMode sets SQLite's threading mode: "serialized" (the go-sqlite3 default)
+// or "multithread". Multithread mode drops the per-connection mutexes, which is safe with
+// database/sql since a connection is only used by one goroutine at a time. go-sqlite3
+// opens connections with SQLITE_OPEN_FULLMUTEX unless the DSN says otherwise, so this
+// mainly matters for code sharing the process's SQLite library. Single-thread mode is not
+// supported: the pool uses connections from several goroutines concurrently.
+func WithThreadingMode(mode string) GlobalOption {
+	return func(c *globalConfig) error {
+		switch strings.ToLower(mode) {
+		case "serialized":
+			c.threading = 3 // SQLITE_CONFIG_SERIALIZED
+		case "multithread":
+			c.threading = 2 // SQLITE_CONFIG_MULTITHREAD
+		default:
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid threading mode %q (want serialized or multithread)", mode))
+		}
+		return nil
+	}
+}
+
+// WithGlobalMmapLimit sets the process-wide default mmap_size for new connections and the
+// hard upper bound for PRAGMA mmap_size (and WithMMapSize), both in bytes. SQLite silently
+// lowers larger requests to maxBytes, which itself cannot exceed the compile-time
+// SQLITE_MAX_MMAP_SIZE.
+func WithGlobalMmapLimit(defaultBytes, maxBytes int64) GlobalOption {
+	return func(c *globalConfig) error {
+		if defaultBytes < 0 || maxBytes < 0 || defaultBytes > maxBytes {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid mmap limit: need 0 <= default (%d) <= max (%d)", defaultBytes, maxBytes))
+		}
+		c.mmapSet = true
+		c.mmapDefault = defaultBytes
+		c.mmapMaxLimit = maxBytes
+		return nil
+	}
+}
+
+// MemoryUsed returns the number of bytes of heap memory SQLite currently has allocated,
+// across all connections in the process (sqlite3_memory_used). Use it with
+// MemoryHighwater to see SQLite's footprint, e.g. when sizing caches.
+func MemoryUsed() int64 {
+	return memoryUsed()
+}
+
+// MemoryHighwater returns the most heap memory SQLite has had allocated at once since the
+// process started or the mark was last reset (sqlite3_memory_highwater). If reset is true
+// the mark is lowered to the current MemoryUsed after it is read, so successive calls
+// report the peak of each interval.
+func MemoryHighwater(reset bool) int64 {
+	return memoryHighwater(reset)
+}
@@ -0,0 +1,110 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestConfigure runs in a child process: Configure only works before SQLite is
+// initialized, and other tests in this binary open connections first.
+func TestConfigure(t *testing.T) {
+	if os.Getenv("SQLITEBP_CONFIGURE_CHILD") == "1" {
+		testConfigureChild(t)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestConfigure$", "-test.v")
+	cmd.Env = append(os.Environ(), "SQLITEBP_CONFIGURE_CHILD=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child process failed: %v\n%s", err, out)
+	}
+
+	// In this process SQLite is already initialized.
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.Close()
+	if err := Configure(WithThreadingMode("multithread")); !errors.Is(err, ErrGlobalConfig) {
+		t.Fatalf("expected ErrGlobalConfig after open, got %v", err)
+	}
+}
+
+func testConfigureChild(t *testing.T) {
+	// An invalid option applies nothing and leaves Configure callable.
+	if err := Configure(WithGlobalMmapLimit(1<<20, 4<<20), WithThreadingMode("single")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+	if err := Configure(WithThreadingMode("multithread"), WithGlobalMmapLimit(1<<20, 4<<20)); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	dir := t.TempDir()
+	db, err := OpenReadWriteCreate(filepath.Join(dir, "default.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var size int64
+	if err := db.QueryRow("PRAGMA mmap_size").Scan(&size); err != nil || size != 1<<20 {
+		t.Fatalf("default mmap_size = %d (err=%v), want %d", size, err, 1<<20)
+	}
+	capped, err := OpenReadWriteCreate(filepath.Join(dir, "capped.db"), WithMMapSize(64<<20))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer capped.Close()
+	if err := capped.QueryRow("PRAGMA mmap_size").Scan(&size); err != nil || size != 4<<20 {
+		t.Fatalf("capped mmap_size = %d (err=%v), want %d", size, err, 4<<20)
+	}
+	if err := Configure(WithThreadingMode("serialized")); !errors.Is(err, ErrGlobalConfig) {
+		t.Fatalf("expected ErrGlobalConfig on second call, got %v", err)
+	}
+}
+
+func TestGlobalOptions_Validation(t *testing.T) {
+	for _, opt := range []GlobalOption{
+		WithThreadingMode("single"),
+		WithGlobalMmapLimit(8, 4),
+		WithGlobalMmapLimit(-1, 4),
+	} {
+		if err := opt(&globalConfig{}); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+		}
+	}
+}
+
+func TestMemoryUsed(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "memory.db"), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer conn.Close()
+
+	before := MemoryUsed()
+	if before <= 0 {
+		t.Fatalf("MemoryUsed() = %d with an open connection", before)
+	}
+	MemoryHighwater(true)
+	// Fill the connection's page cache and temp store; both stay allocated afterwards.
+	if _, err := conn.ExecContext(context.Background(), `
+		CREATE TEMP TABLE big AS
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20000)
+		SELECT i, randomblob(200) AS data FROM n`); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	used := MemoryUsed()
+	if used <= before {
+		t.Errorf("MemoryUsed() = %d, want more than %d", used, before)
+	}
+	if high := MemoryHighwater(false); high < used {
+		t.Errorf("MemoryHighwater() = %d, want >= MemoryUsed() %d", high, used)
+	}
+}
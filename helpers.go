@@ -0,0 +1,290 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// quoteIdent quotes an SQL identifier (table, column, schema) for safe interpolation.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteString quotes an SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// JSONAvailable reports whether the JSON SQL functions are available on db.
+func JSONAvailable(ctx context.Context, db *sql.DB) (bool, error) {
+	var valid int
+	err := db.QueryRowContext(ctx, "SELECT json_valid('{}')").Scan(&valid)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such function") {
+			return false, nil
+		}
+		return false, err
+	}
+	return valid == 1, nil
+}
+
+// EnsureJSONColumn enforces that table.column only ever holds valid JSON (or NULL).
+// SQLite cannot add a CHECK constraint to an existing table, so the constraint is
+// installed as BEFORE INSERT and BEFORE UPDATE triggers that abort on invalid JSON.
+// New tables can instead declare CHECK (json_valid(column)) directly.
+// Calling it again for the same column is a no-op.
+func EnsureJSONColumn(ctx context.Context, db *sql.DB, table, column string) error {
+	if table == "" || column == "" {
+		return errors.Join(ErrInvalidConfigOption, fmt.Errorf("table and column cannot be empty"))
+	}
+	ok, err := JSONAvailable(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Join(ErrFeatureUnavailable, fmt.Errorf("JSON not compiled into this SQLite build"))
+	}
+	col := quoteIdent(column)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, event := range []string{"INSERT", "UPDATE OF " + col} {
+		name := fmt.Sprintf("_sqlitebp_json_%s_%s_%s", table, column, strings.ToLower(strings.Fields(event)[0]))
+		statement := fmt.Sprintf(
+			"CREATE TRIGGER IF NOT EXISTS %s BEFORE %s ON %s "+
+				"WHEN NEW.%s IS NOT NULL AND NOT json_valid(NEW.%s) "+
+				"BEGIN SELECT RAISE(ABORT, %s); END",
+			quoteIdent(name), event, quoteIdent(table), col, col,
+			quoteString(fmt.Sprintf("%s.%s must be valid JSON", table, column)),
+		)
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("sqlitebp: failed to create JSON trigger on %s.%s: %w", table, column, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// withRawConn runs fn with the go-sqlite3 connection behind a pooled connection.
+func withRawConn(ctx context.Context, db *sql.DB, fn func(*sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		c, ok := unwrapConn(dc)
+		if !ok {
+			return fmt.Errorf("sqlitebp: unexpected driver connection type %T", dc)
+		}
+		return fn(c)
+	})
+}
+
+// MaxVariableNumber returns the maximum number of host parameters (?) a single statement
+// may use on db's connections (SQLITE_LIMIT_VARIABLE_NUMBER). Use it to chunk large
+// IN (...) lists or multi-row inserts instead of assuming the historical 999.
+func MaxVariableNumber(ctx context.Context, db *sql.DB) (int, error) {
+	var n int
+	err := withRawConn(ctx, db, func(c *sqlite3.SQLiteConn) error {
+		n = c.GetLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER)
+		return nil
+	})
+	return n, err
+}
+
+// ConnectionLabel returns the label WithConnectionLabels assigned to conn's underlying
+// connection, or 0 if the handle was opened without labels.
+func ConnectionLabel(conn *sql.Conn) (uint64, error) {
+	var label uint64
+	err := conn.Raw(func(dc any) error {
+		if c, ok := dc.(*sqliteConn); ok {
+			label = c.label
+		}
+		return nil
+	})
+	return label, err
+}
+
+// BulkInsert inserts rows into the given columns of table using multi-row
+// INSERT ... VALUES (...), (...) statements, all in one transaction, and returns the
+// number of rows inserted. Each statement holds at most batchSize rows (no limit if
+// batchSize is 0), further capped so it stays within MaxVariableNumber host parameters.
+// Every row must have one value per column; invalid arguments fail with
+// ErrInvalidConfigOption. On error nothing is inserted.
+func BulkInsert(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]any, batchSize int) (int64, error) {
+	if table == "" || len(columns) == 0 {
+		return 0, errors.Join(ErrInvalidConfigOption, fmt.Errorf("table and columns cannot be empty"))
+	}
+	if batchSize < 0 {
+		return 0, errors.Join(ErrInvalidConfigOption, fmt.Errorf("batch size must be >= 0"))
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return 0, errors.Join(ErrInvalidConfigOption, fmt.Errorf("row %d has %d values, want %d", i, len(row), len(columns)))
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	maxVars, err := MaxVariableNumber(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	perStmt := maxVars / len(columns)
+	if perStmt == 0 {
+		return 0, errors.Join(ErrInvalidConfigOption, fmt.Errorf("%d columns exceed the limit of %d host parameters", len(columns), maxVars))
+	}
+	if batchSize > 0 {
+		perStmt = min(perStmt, batchSize)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdent(c)
+	}
+	prefix := "INSERT INTO " + quoteIdent(table) + " (" + strings.Join(quoted, ", ") + ") VALUES "
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	insertSQL := func(n int) string {
+		return prefix + strings.TrimSuffix(strings.Repeat(tuple+", ", n), ", ")
+	}
+
+	var inserted int64
+	err = Transaction(ctx, db, func(tx *sql.Tx) error {
+		// All full batches share one prepared statement; only the remainder differs.
+		var full *sql.Stmt
+		args := make([]any, 0, perStmt*len(columns))
+		for start := 0; start < len(rows); start += perStmt {
+			batch := rows[start:min(start+perStmt, len(rows))]
+			args = args[:0]
+			for _, row := range batch {
+				args = append(args, row...)
+			}
+			var res sql.Result
+			var err error
+			if len(batch) == perStmt {
+				if full == nil {
+					if full, err = tx.PrepareContext(ctx, insertSQL(perStmt)); err != nil {
+						return err
+					}
+					defer full.Close()
+				}
+				res, err = full.ExecContext(ctx, args...)
+			} else {
+				res, err = tx.ExecContext(ctx, insertSQL(len(batch)), args...)
+			}
+			if err != nil {
+				return fmt.Errorf("sqlitebp: bulk insert into %s failed at row %d: %w", table, start, err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			inserted += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// Upsert inserts values (column name to value) into table, or, if a row with the same
+// key columns already exists, updates its other columns to the given values. key names
+// the columns of a PRIMARY KEY or UNIQUE constraint, which ON CONFLICT requires; each
+// must be present in values, or Upsert fails with ErrInvalidConfigOption. If values
+// contains only key columns an existing row is left unchanged.
+func Upsert(ctx context.Context, db *sql.DB, table string, key []string, values map[string]any) error {
+	if table == "" || len(key) == 0 {
+		return errors.Join(ErrInvalidConfigOption, fmt.Errorf("table and key cannot be empty"))
+	}
+	isKey := make(map[string]bool, len(key))
+	for _, k := range key {
+		if _, ok := values[k]; !ok {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("key column %q has no value", k))
+		}
+		isKey[k] = true
+	}
+	columns := make([]string, 0, len(values))
+	for c := range values {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	args := make([]any, len(columns))
+	var updates []string
+	for i, c := range columns {
+		quoted[i] = quoteIdent(c)
+		args[i] = values[c]
+		if !isKey[c] {
+			updates = append(updates, quoted[i]+" = excluded."+quoted[i])
+		}
+	}
+	quotedKey := make([]string, len(key))
+	for i, k := range key {
+		quotedKey[i] = quoteIdent(k)
+	}
+	action := "DO NOTHING"
+	if len(updates) > 0 {
+		action = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	query := "INSERT INTO " + quoteIdent(table) + " (" + strings.Join(quoted, ", ") + ") VALUES (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ") ON CONFLICT (" +
+		strings.Join(quotedKey, ", ") + ") " + action
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("sqlitebp: upsert into %s failed: %w", table, err)
+	}
+	return nil
+}
+
+// DataVersion returns PRAGMA data_version for conn. The value changes whenever another
+// connection, in this process or another, commits a change to the database, so comparing
+// it with an earlier value tells a reader whether its view of the file is stale (e.g. a
+// replica file refreshed by a separate process) without re-reading any data. Commits made
+// through conn itself do not change it.
+//
+// The value is only meaningful on the connection that produced it: each connection keeps
+// its own counter, which is why this takes a *sql.Conn rather than a pooled *sql.DB.
+func DataVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var v int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&v); err != nil {
+		return 0, errors.Join(ErrPragmaExec, fmt.Errorf("failed to read data_version: %w", err))
+	}
+	return v, nil
+}
+
+// LastInsertRowID returns last_insert_rowid() for conn: the rowid of the most recent
+// successful INSERT on conn into a rowid table, or 0 if there has been none. Changes
+// returns changes(): the number of rows inserted, updated or deleted by the most recent
+// INSERT, UPDATE or DELETE on conn. They are useful when the statement's sql.Result was
+// not captured, e.g. inside a transaction run statement by statement on conn.
+//
+// Both values are kept per connection and overwritten by every later write on it. A
+// *sql.DB may run the query on a different pooled connection than the write, or hand the
+// writing connection to another goroutine in between, so these take the *sql.Conn that
+// did the write.
+func LastInsertRowID(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var id int64
+	if err := conn.QueryRowContext(ctx, "SELECT last_insert_rowid()").Scan(&id); err != nil {
+		return 0, fmt.Errorf("sqlitebp: failed to read last_insert_rowid: %w", err)
+	}
+	return id, nil
+}
+
+// Changes returns changes() for conn, see LastInsertRowID.
+func Changes(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var n int64
+	if err := conn.QueryRowContext(ctx, "SELECT changes()").Scan(&n); err != nil {
+		return 0, fmt.Errorf("sqlitebp: failed to read changes: %w", err)
+	}
+	return n, nil
+}
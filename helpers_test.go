@@ -0,0 +1,290 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestJSONAvailable(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "json.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	ok, err := JSONAvailable(context.Background(), db)
+	if err != nil || !ok {
+		t.Fatalf("JSONAvailable=%v err=%v", ok, err)
+	}
+}
+
+func TestEnsureJSONColumn(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "jsoncol.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE docs (id INTEGER PRIMARY KEY, body TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	if err := EnsureJSONColumn(ctx, db, "docs", "body"); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if err := EnsureJSONColumn(ctx, db, "docs", "body"); err != nil {
+		t.Fatalf("ensure again: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO docs (id, body) VALUES (1, '{"a": 1}')`); err != nil {
+		t.Fatalf("valid insert: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO docs (id, body) VALUES (2, NULL)`); err != nil {
+		t.Fatalf("null insert: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO docs (id, body) VALUES (3, '{bad')`); err == nil || !strings.Contains(err.Error(), "must be valid JSON") {
+		t.Errorf("expected invalid insert to fail, got %v", err)
+	}
+	if _, err := db.Exec(`UPDATE docs SET body = 'nope' WHERE id = 1`); err == nil || !strings.Contains(err.Error(), "must be valid JSON") {
+		t.Errorf("expected invalid update to fail, got %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM docs").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("count=%d err=%v", n, err)
+	}
+	if err := EnsureJSONColumn(ctx, db, "docs", ""); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an empty column, got %v", err)
+	}
+}
+
+func TestMaxVariableNumber(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "limits.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	n, err := MaxVariableNumber(ctx, db)
+	if err != nil || n <= 0 {
+		t.Fatalf("max=%d err=%v", n, err)
+	}
+	placeholders := func(k int) (string, []any) {
+		args := make([]any, k)
+		for i := range args {
+			args[i] = i
+		}
+		return "SELECT 1 WHERE 1 IN (" + strings.TrimSuffix(strings.Repeat("?,", k), ",") + ")", args
+	}
+	query, args := placeholders(n)
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("query at limit: %v", err)
+	}
+	query, args = placeholders(n + 1)
+	if _, err := db.Exec(query, args...); err == nil || !strings.Contains(err.Error(), "too many SQL variables") {
+		t.Fatalf("expected too many variables, got %v", err)
+	}
+}
+
+func TestWithLimit_VariableNumber(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "withlimit.db"), WithLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER, 100))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	n, err := MaxVariableNumber(context.Background(), db)
+	if err != nil || n != 100 {
+		t.Fatalf("max=%d err=%v want 100", n, err)
+	}
+	if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "badlimit.db"), WithLimit(-1, 1)); err == nil {
+		t.Fatalf("expected invalid limit id error")
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "bulk.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, "group" TEXT, score REAL)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	const total = 100_000
+	rows := make([][]any, total)
+	for i := range rows {
+		rows[i] = []any{i + 1, fmt.Sprintf("g%d", i%7), float64(i) / 2}
+	}
+	// 3000-row batches leave a 1000-row remainder that needs its own statement.
+	n, err := BulkInsert(ctx, db, "items", []string{"id", "group", "score"}, rows, 3000)
+	if err != nil {
+		t.Fatalf("bulk insert: %v", err)
+	}
+	if n != total {
+		t.Fatalf("inserted %d want %d", n, total)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != total {
+		t.Fatalf("count=%d err=%v", count, err)
+	}
+	var group string
+	var score float64
+	if err := db.QueryRow(`SELECT "group", score FROM items WHERE id = 54321`).Scan(&group, &score); err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	if group != "g0" || score != 27160 {
+		t.Fatalf("sample group=%q score=%v", group, score)
+	}
+
+	// A failing batch rolls back the whole insert.
+	dup := [][]any{{total + 1, "x", 0.0}, {1, "dup", 0.0}}
+	if _, err := BulkInsert(ctx, db, "items", []string{"id", "group", "score"}, dup, 1); err == nil {
+		t.Fatalf("expected constraint error")
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != total {
+		t.Fatalf("count=%d err=%v after failed insert", count, err)
+	}
+	if _, err := BulkInsert(ctx, db, "items", []string{"id", "group"}, rows[:1], 0); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for row/column mismatch, got %v", err)
+	}
+	if _, err := BulkInsert(ctx, db, "", []string{"id"}, rows[:1], 0); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an empty table, got %v", err)
+	}
+	if _, err := BulkInsert(ctx, db, "items", []string{"id", "group", "score"}, rows[:1], -1); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a negative batch size, got %v", err)
+	}
+}
+
+func TestBulkInsert_CapsBatchesAtParameterLimit(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "bulk.db"), WithLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER, 10))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE pairs (a INTEGER, b INTEGER)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	rows := make([][]any, 101)
+	for i := range rows {
+		rows[i] = []any{i, -i}
+	}
+	// Unlimited batches must still be split into statements of at most 5 rows.
+	if n, err := BulkInsert(ctx, db, "pairs", []string{"a", "b"}, rows, 0); err != nil || n != 101 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+}
+
+func TestUpsert_CompositeKey(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "upsert.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE settings (scope TEXT, "key" TEXT, value TEXT, PRIMARY KEY (scope, "key"))`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	key := []string{"scope", "key"}
+	if err := Upsert(ctx, db, "settings", key, map[string]any{"scope": "user:1", "key": "theme", "value": "light"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := Upsert(ctx, db, "settings", key, map[string]any{"scope": "user:1", "key": "theme", "value": "dark"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	var count int
+	var value string
+	if err := db.QueryRow("SELECT COUNT(*), max(value) FROM settings").Scan(&count, &value); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if count != 1 || value != "dark" {
+		t.Fatalf("count=%d value=%q, want 1 row with dark", count, value)
+	}
+
+	// Only key columns: an existing row is kept as is.
+	if err := Upsert(ctx, db, "settings", key, map[string]any{"scope": "user:1", "key": "theme"}); err != nil {
+		t.Fatalf("key-only upsert: %v", err)
+	}
+	if err := Upsert(ctx, db, "settings", key, map[string]any{"scope": "user:1", "value": "x"}); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for missing key value, got %v", err)
+	}
+	if err := Upsert(ctx, db, "settings", nil, map[string]any{"scope": "user:1"}); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an empty key, got %v", err)
+	}
+}
+
+func TestDataVersion_ChangesOnExternalWrite(t *testing.T) {
+	ctx := context.Background()
+	fn := filepath.Join(t.TempDir(), "version.db")
+	writer, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("open writer: %v", err)
+	}
+	defer writer.Close()
+	if _, err := writer.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	reader, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("open reader: %v", err)
+	}
+	defer reader.Close()
+	conn, err := reader.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer conn.Close()
+
+	before, err := DataVersion(ctx, conn)
+	if err != nil {
+		t.Fatalf("data_version: %v", err)
+	}
+	if again, err := DataVersion(ctx, conn); err != nil || again != before {
+		t.Fatalf("data_version changed without a write: %d -> %d (err %v)", before, again, err)
+	}
+	if _, err := writer.Exec("INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if after, err := DataVersion(ctx, conn); err != nil || after == before {
+		t.Fatalf("data_version=%d err=%v, want change from %d after write", after, err, before)
+	}
+}
+
+func TestLastInsertRowIDAndChanges(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "rowid.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY, v TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN; INSERT INTO test (id, v) VALUES (41, 'a'), (42, 'b')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if id, err := LastInsertRowID(ctx, conn); err != nil || id != 42 {
+		t.Fatalf("last_insert_rowid=%d err=%v, want 42", id, err)
+	}
+	if n, err := Changes(ctx, conn); err != nil || n != 2 {
+		t.Fatalf("changes=%d err=%v, want 2", n, err)
+	}
+	if _, err := conn.ExecContext(ctx, "UPDATE test SET v = 'c' WHERE id = 41; COMMIT"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if n, err := Changes(ctx, conn); err != nil || n != 1 {
+		t.Fatalf("changes=%d err=%v, want 1 after update", n, err)
+	}
+}
@@ -0,0 +1,101 @@
+package sqlitebp
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// lockTable holds the advisory locks of AcquireLock, one row per lock name. owner is NULL
+// while the lock is free; expires_at is in Unix milliseconds.
+const lockTable = "_sqlitebp_locks"
+
+// ErrLockNotHeld indicates ReleaseLock was called by an owner that no longer holds the
+// lock, because it was released already or expired and was taken by someone else.
+var ErrLockNotHeld = errors.New("sqlitebp: advisory lock not held")
+
+// lockPollInterval is how often AcquireLock retries a lock that is held.
+const lockPollInterval = 10 * time.Millisecond
+
+// createLocks creates the lock table if needed and a free row for each name that has none.
+func createLocks(ctx context.Context, tx *sql.Tx, names ...string) error {
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+lockTable+" (name TEXT PRIMARY KEY, owner TEXT, expires_at INTEGER NOT NULL DEFAULT 0) STRICT"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO "+lockTable+" (name) VALUES (?)", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AcquireLock takes the advisory lock name, stored in a table of db, waiting until it is
+// free or ctx is done. It coordinates work that spans several transactions, such as a
+// maintenance job, between handles and processes using the same database file; SQLite's
+// own locking only serializes individual transactions. The lock is cooperative: it does
+// not stop anyone from writing.
+//
+// The lock expires after ttl so that a crashed owner does not hold it forever; an owner
+// that may run longer must choose a larger ttl. AcquireLock returns an owner token to pass
+// to ReleaseLock.
+func AcquireLock(ctx context.Context, db *sql.DB, name string, ttl time.Duration) (owner string, err error) {
+	if name == "" {
+		return "", errors.Join(ErrInvalidConfigOption, fmt.Errorf("lock name cannot be empty"))
+	}
+	if ttl <= 0 {
+		return "", errors.Join(ErrInvalidConfigOption, fmt.Errorf("lock ttl must be > 0"))
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	owner = hex.EncodeToString(b[:])
+	for {
+		var acquired bool
+		err := Transaction(ctx, db, func(tx *sql.Tx) error {
+			if err := createLocks(ctx, tx, name); err != nil {
+				return err
+			}
+			now := time.Now()
+			res, err := tx.ExecContext(ctx, "UPDATE "+lockTable+" SET owner = ?, expires_at = ? WHERE name = ? AND (owner IS NULL OR expires_at <= ?)",
+				owner, now.Add(ttl).UnixMilli(), name, now.UnixMilli())
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			acquired = n == 1
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("sqlitebp: failed to acquire lock %q: %w", name, err)
+		}
+		if acquired {
+			return owner, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// ReleaseLock releases the advisory lock name taken by AcquireLock with the given owner
+// token. It returns ErrLockNotHeld if owner no longer holds the lock.
+func ReleaseLock(ctx context.Context, db *sql.DB, name, owner string) error {
+	res, err := db.ExecContext(ctx, "UPDATE "+lockTable+" SET owner = NULL, expires_at = 0 WHERE name = ? AND owner = ?", name, owner)
+	if err != nil {
+		return fmt.Errorf("sqlitebp: failed to release lock %q: %w", name, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.Join(ErrLockNotHeld, fmt.Errorf("lock %q", name))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock_MutualExclusion(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "lock.db")
+	ctx := context.Background()
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for w := 0; w < 2; w++ {
+		// Separate handles, as two processes would have.
+		db, err := OpenReadWriteCreate(fn, WithAdvisoryLock("job"))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				owner, err := AcquireLock(ctx, db, "job", time.Minute)
+				if err != nil {
+					errs <- err
+					return
+				}
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+				holders.Add(-1)
+				if err := ReleaseLock(ctx, db, "job", owner); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("worker: %v", err)
+	}
+	if m := maxHolders.Load(); m != 1 {
+		t.Fatalf("lock held by %d owners at once", m)
+	}
+}
+
+func TestAcquireLock_ExpiryAndRelease(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "lock.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	first, err := AcquireLock(ctx, db, "job", 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLock(short, db, "job", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded while held, got %v", err)
+	}
+	// Another lock name is independent.
+	other, err := AcquireLock(ctx, db, "other", time.Minute)
+	if err != nil {
+		t.Fatalf("acquire other: %v", err)
+	}
+	if err := ReleaseLock(ctx, db, "other", other); err != nil {
+		t.Fatalf("release other: %v", err)
+	}
+
+	// Once expired the lock is taken over, and the previous owner cannot release it.
+	second, err := AcquireLock(ctx, db, "job", time.Minute)
+	if err != nil {
+		t.Fatalf("acquire after expiry: %v", err)
+	}
+	if err := ReleaseLock(ctx, db, "job", first); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := ReleaseLock(ctx, db, "job", second); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := ReleaseLock(ctx, db, "job", second); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("expected ErrLockNotHeld on a second release, got %v", err)
+	}
+}
+
+func TestWithAdvisoryLock_CreatesTable(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "lock.db"), WithAdvisoryLock("a"), WithAdvisoryLock("b"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM " + lockTable + " WHERE owner IS NULL").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("free locks=%d err=%v, want 2", n, err)
+	}
+	if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "lock.db"), WithAdvisoryLock("a"), WithAdvisoryLock("a")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
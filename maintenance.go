@@ -0,0 +1,395 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// VacuumAndReopen rebuilds filename with VACUUM and returns a freshly opened pool.
+//
+// VACUUM is the only way to change settings such as page_size on an existing database,
+// and connections opened before the rebuild keep using the old values. The rebuild runs
+// on a single connection with opts applied (e.g. WithPageSize), which is then closed
+// before the database is reopened in mode with the full pool.
+//
+// A WAL database cannot change its page size, so the journal is switched to DELETE for
+// the rebuild and restored afterwards. This requires that no other connection, in this
+// or another process, has the database open. mode must be write-capable.
+func VacuumAndReopen(filename string, mode Mode, opts ...Option) (*sql.DB, error) {
+	if mode == ModeReadOnly {
+		return nil, errors.Join(ErrInvalidMode, fmt.Errorf("cannot vacuum in read-only mode"))
+	}
+	cfg, err := newOpenConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := vacuumFile(filename, mode, cfg, opts); err != nil {
+		return nil, err
+	}
+	return openWithMode(filename, mode, opts...)
+}
+
+func vacuumFile(filename string, mode Mode, cfg *openConfig, opts []Option) error {
+	db, err := openWithMode(filename, mode, opts...)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var journalMode string
+	if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		return err
+	}
+	wal := journalMode == "wal"
+	if wal {
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode=DELETE").Scan(&journalMode); err != nil {
+			return fmt.Errorf("sqlitebp: failed to leave WAL mode for vacuum: %w", err)
+		}
+	}
+	if pageSize, ok := cfg.pragma("page_size"); ok {
+		if _, err := conn.ExecContext(ctx, "PRAGMA page_size="+pageSize); err != nil {
+			return errors.Join(ErrPragmaExec, fmt.Errorf("failed to set page_size: %w", err))
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("sqlitebp: vacuum failed: %w", err)
+	}
+	if wal {
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode=WAL").Scan(&journalMode); err != nil {
+			return fmt.Errorf("sqlitebp: failed to restore WAL mode after vacuum: %w", err)
+		}
+	}
+	return nil
+}
+
+// Vacuum rebuilds db's main schema with VACUUM on a dedicated connection, returning
+// freelist pages to the filesystem. Cancelling ctx interrupts the rebuild, which is then
+// rolled back, and Vacuum returns ctx.Err() promptly.
+//
+// VACUUM writes a complete copy of the database before replacing the original, so it
+// needs free disk space of up to twice the database size (the copy plus, in WAL mode, a
+// WAL of similar size until the next checkpoint). It holds the write lock throughout;
+// readers in WAL mode are not blocked.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var sc *sqlite3.SQLiteConn
+	if err := conn.Raw(func(dc any) error {
+		var ok bool
+		if sc, ok = unwrapConn(dc); !ok {
+			return fmt.Errorf("sqlitebp: unexpected driver connection %T", dc)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// go-sqlite3 interrupts once on cancellation, which is lost if the statement has not
+	// started stepping yet. Keep interrupting until VACUUM returns instead.
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(exited)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			interrupt(sc)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+	_, err = conn.ExecContext(context.Background(), "VACUUM")
+	close(done)
+	if !stop() {
+		// The interrupter has started; conn must stay open until it has exited.
+		<-exited
+	}
+	if err != nil {
+		return fmt.Errorf("sqlitebp: vacuum failed: %w", contextError(ctx, err))
+	}
+	return nil
+}
+
+// VacuumInto writes a compacted, transactionally consistent copy of db's main schema to
+// dest, which must not already exist. Writers are not blocked while the copy is made.
+func VacuumInto(ctx context.Context, db *sql.DB, dest string) error {
+	if dest == "" {
+		return ErrEmptyFilename
+	}
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", dest); err != nil {
+		return fmt.Errorf("sqlitebp: vacuum into %q failed: %w", dest, err)
+	}
+	return nil
+}
+
+// BackupToWriter streams a compacted, transactionally consistent copy of db's main schema
+// to w, e.g. an HTTP response or an object storage upload. The copy is first made with
+// VACUUM INTO in a private temporary directory (under os.TempDir, which needs room for it)
+// and then copied to w; the temporary file is removed in all cases. Cancelling ctx
+// interrupts either step. The bytes written are a complete database file, see
+// RestoreFromReader.
+func BackupToWriter(ctx context.Context, db *sql.DB, w io.Writer) error {
+	dir, err := os.MkdirTemp("", "sqlitebp-backup-")
+	if err != nil {
+		return fmt.Errorf("sqlitebp: backup failed: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "backup.db")
+	if err := VacuumInto(ctx, db, snapshot); err != nil {
+		return err
+	}
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return fmt.Errorf("sqlitebp: backup failed: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, &contextReader{ctx: ctx, r: f}); err != nil {
+		return fmt.Errorf("sqlitebp: backup failed: %w", err)
+	}
+	return nil
+}
+
+// ErrDatabaseExists indicates RestoreFromReader found a database at its destination and
+// WithRestoreOverwrite was not given.
+var ErrDatabaseExists = errors.New("sqlitebp: database already exists")
+
+// RestoreFromReader writes the database file read from r (e.g. produced by BackupToWriter)
+// to destPath and opens it read/write with opts. The data is written to a temporary file
+// next to destPath, synced and checked with PRAGMA integrity_check before it is renamed
+// into place, so destPath never holds a partial or corrupt copy. Cancelling ctx stops the
+// copy and leaves destPath untouched.
+//
+// An existing SQLite database at destPath is only replaced with WithRestoreOverwrite; its
+// -wal and -shm files are then removed so they cannot be applied to the restored file.
+// Like AtomicReplace, it must not be open anywhere while it is replaced.
+func RestoreFromReader(ctx context.Context, destPath string, r io.Reader, opts ...Option) (*sql.DB, error) {
+	if destPath == "" {
+		return nil, ErrEmptyFilename
+	}
+	cfg, err := newOpenConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := isDatabaseFile(destPath)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitebp: restore failed: %w", err)
+	}
+	if exists && !cfg.restoreOverwrite {
+		return nil, errors.Join(ErrDatabaseExists, fmt.Errorf("refusing to overwrite %q", destPath))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("sqlitebp: restore failed: %w", err)
+	}
+	tmpName := tmp.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmpName)
+		}
+	}()
+	_, err = io.Copy(tmp, &contextReader{ctx: ctx, r: r})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlitebp: restore failed: %w", err)
+	}
+	if err := checkRestored(ctx, tmpName); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(destPath + suffix); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("sqlitebp: failed to remove %q: %w", destPath+suffix, err)
+			}
+		}
+	}
+	if err := os.Rename(tmpName, destPath); err != nil {
+		return nil, fmt.Errorf("sqlitebp: failed to rename %q to %q: %w", tmpName, destPath, err)
+	}
+	renamed = true
+	if err := syncDir(filepath.Dir(destPath)); err != nil {
+		return nil, err
+	}
+	return openWithMode(destPath, ModeReadWrite, opts...)
+}
+
+// checkRestored runs PRAGMA integrity_check on the private file filename. It is opened
+// immutable so that no -wal or -shm file is created or consulted.
+func checkRestored(ctx context.Context, filename string) error {
+	db, err := openWithMode(filename, ModeReadOnly, WithImmutable(), WithOptimize(false), WithMaxOpenConns(1))
+	if err != nil {
+		return fmt.Errorf("sqlitebp: restored data is not a valid database: %w", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := integrityCheck(ctx, conn); err != nil {
+		return fmt.Errorf("sqlitebp: restored data is not a valid database: %w", err)
+	}
+	return nil
+}
+
+// isDatabaseFile reports whether filename exists and starts with the SQLite header.
+func isDatabaseFile(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil {
+		// Shorter than a header: empty or truncated, not a database.
+		return false, nil
+	}
+	return string(header) == "SQLite format 3\x00", nil
+}
+
+// contextReader is r, failing with ctx.Err() once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// FreelistStats returns the number of unused pages (PRAGMA freelist_count) and the total
+// number of pages (PRAGMA page_count) in db's main schema.
+func FreelistStats(ctx context.Context, db *sql.DB) (freePages, totalPages int64, err error) {
+	if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&totalPages); err != nil {
+		return 0, 0, err
+	}
+	return freePages, totalPages, nil
+}
+
+// FragmentationRatio returns the fraction of pages on the freelist (0 for an empty database).
+// Space on the freelist is reused by later writes but only returned to the filesystem by
+// VACUUM, so a maintenance job can vacuum once the ratio crosses a threshold.
+func FragmentationRatio(ctx context.Context, db *sql.DB) (float64, error) {
+	free, total, err := FreelistStats(ctx, db)
+	if err != nil || total == 0 {
+		return 0, err
+	}
+	return float64(free) / float64(total), nil
+}
+
+// WritableSchema runs fn on a single connection with PRAGMA writable_schema=ON, allowing
+// direct edits of sqlite_schema for repairs such as fixing the SQL of a botched migration.
+//
+// This is dangerous: an incorrect edit makes the database unreadable. Take a backup first.
+// writable_schema is always switched off again, even if fn fails or panics, then the
+// schema is reloaded (writable_schema=RESET) and PRAGMA integrity_check must report ok.
+// schema_version is incremented before that, so that other connections, which do not
+// otherwise notice direct edits of sqlite_schema, reload the schema too.
+func WritableSchema(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA writable_schema=ON"); err != nil {
+		return errors.Join(ErrPragmaExec, fmt.Errorf("failed to enable writable_schema: %w", err))
+	}
+	defer func() {
+		// Use a fresh context so cancellation of ctx cannot leave the pragma enabled.
+		off := context.Background()
+		if bumpErr := bumpSchemaVersion(off, conn); bumpErr != nil {
+			err = errors.Join(err, ErrPragmaExec, fmt.Errorf("failed to increment schema_version: %w", bumpErr))
+		}
+		if _, offErr := conn.ExecContext(off, "PRAGMA writable_schema=OFF"); offErr != nil {
+			// Never return a connection to the pool with writable_schema still on.
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			err = errors.Join(err, ErrPragmaExec, fmt.Errorf("failed to disable writable_schema: %w", offErr))
+			return
+		}
+		if err != nil {
+			return
+		}
+		if _, err = conn.ExecContext(off, "PRAGMA writable_schema=RESET"); err != nil {
+			err = errors.Join(ErrPragmaExec, fmt.Errorf("failed to reload schema: %w", err))
+			return
+		}
+		err = integrityCheck(off, conn)
+	}()
+	return fn(conn)
+}
+
+// bumpSchemaVersion increments PRAGMA schema_version on conn.
+func bumpSchemaVersion(ctx context.Context, conn *sql.Conn) error {
+	var version int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA schema_version").Scan(&version); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA schema_version=%d", version+1))
+	return err
+}
+
+// integrityCheck runs PRAGMA integrity_check on conn and returns its findings as an error.
+func integrityCheck(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("sqlitebp: integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
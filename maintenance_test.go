@@ -0,0 +1,316 @@
+package sqlitebp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVacuumAndReopen_ChangesPageSize(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "pagesize.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.Exec("INSERT INTO test (value) VALUES (?)", "row"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	var pageSize int
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil || pageSize != 4096 {
+		t.Fatalf("initial page_size=%d err=%v", pageSize, err)
+	}
+	db.Close()
+
+	db, err = VacuumAndReopen(fn, ModeReadWrite, WithPageSize(8192))
+	if err != nil {
+		t.Fatalf("vacuum and reopen: %v", err)
+	}
+	defer db.Close()
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil || pageSize != 8192 {
+		t.Fatalf("page_size=%d err=%v want 8192", pageSize, err)
+	}
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Fatalf("journal_mode=%s err=%v want wal", journalMode, err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 100 {
+		t.Fatalf("count=%d err=%v", n, err)
+	}
+	if db.Stats().MaxOpenConnections < 2 {
+		t.Errorf("reopened pool should use the full pool size")
+	}
+}
+
+func TestVacuumAndReopen_ReadOnly(t *testing.T) {
+	if _, err := VacuumAndReopen(filepath.Join(t.TempDir(), "ro.db"), ModeReadOnly); err == nil {
+		t.Fatalf("expected read-only mode to be rejected")
+	}
+}
+
+func TestFreelistStats_GrowsAfterDelete(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "freelist.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, data BLOB) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	if _, err := db.Exec("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 500) INSERT INTO test (data) SELECT randomblob(2000) FROM n"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	free, total, err := FreelistStats(ctx, db)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if total == 0 {
+		t.Fatalf("page_count=0")
+	}
+	if _, err := db.Exec("DELETE FROM test WHERE id > 100"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	freeAfter, totalAfter, err := FreelistStats(ctx, db)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if freeAfter <= free {
+		t.Errorf("freelist did not grow: before=%d after=%d", free, freeAfter)
+	}
+	ratio, err := FragmentationRatio(ctx, db)
+	if err != nil {
+		t.Fatalf("ratio: %v", err)
+	}
+	if want := float64(freeAfter) / float64(totalAfter); ratio != want || ratio <= 0 || ratio >= 1 {
+		t.Errorf("ratio=%v want %v in (0, 1)", ratio, want)
+	}
+}
+
+func TestWritableSchema_EditsViewAndChecksIntegrity(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "schema.db"), WithMaxOpenConns(2))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE VIEW answer AS SELECT 41 AS x"); err != nil {
+		t.Fatalf("view: %v", err)
+	}
+	// Another connection has parsed the schema before the edit.
+	other, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer other.Close()
+	var x int
+	if err := other.QueryRowContext(ctx, "SELECT x FROM answer").Scan(&x); err != nil || x != 41 {
+		t.Fatalf("x=%d err=%v want 41", x, err)
+	}
+
+	err = WritableSchema(ctx, db, func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "UPDATE sqlite_schema SET sql = 'CREATE VIEW answer AS SELECT 42 AS x' WHERE type = 'view' AND name = 'answer'")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("writable schema: %v", err)
+	}
+	if err := other.QueryRowContext(ctx, "SELECT x FROM answer").Scan(&x); err != nil || x != 42 {
+		t.Fatalf("other connection: x=%d err=%v want 42", x, err)
+	}
+	other.Close()
+	var writable int
+	if err := db.QueryRow("PRAGMA writable_schema").Scan(&writable); err != nil || writable != 0 {
+		t.Fatalf("writable_schema=%d err=%v want 0", writable, err)
+	}
+
+	errEdit := errors.New("edit failed")
+	if err := WritableSchema(ctx, db, func(*sql.Conn) error { return errEdit }); !errors.Is(err, errEdit) {
+		t.Fatalf("err=%v want %v", err, errEdit)
+	}
+	if err := db.QueryRow("PRAGMA writable_schema").Scan(&writable); err != nil || writable != 0 {
+		t.Fatalf("writable_schema=%d err=%v after failure, want 0", writable, err)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY, data BLOB);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+		INSERT INTO t (data) SELECT randomblob(1000) FROM n;
+		DELETE FROM t WHERE id > 1000`); err != nil {
+		t.Fatalf("fragment: %v", err)
+	}
+	if free, _, err := FreelistStats(ctx, db); err != nil || free == 0 {
+		t.Fatalf("expected freelist pages before vacuum, got %d (err=%v)", free, err)
+	}
+	if err := Vacuum(ctx, db); err != nil {
+		t.Fatalf("vacuum: %v", err)
+	}
+	if free, _, err := FreelistStats(ctx, db); err != nil || free != 0 {
+		t.Fatalf("expected empty freelist after vacuum, got %d (err=%v)", free, err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 1000 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+}
+
+func TestVacuum_Cancel(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY, data BLOB);
+		CREATE INDEX t_data ON t (data);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 50000)
+		INSERT INTO t (data) SELECT randomblob(500) FROM n`); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err = Vacuum(ctx, db)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v after %v", err, elapsed)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("vacuum took %v to return after cancellation", elapsed)
+	}
+	// The rebuild was rolled back and the pool is still usable.
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 50000 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+}
+
+func TestBackupToWriter(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "source.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT;
+		INSERT INTO test (value) VALUES ('a'), ('b'), ('c')`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := BackupToWriter(ctx, db, &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(restored, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	copied, err := OpenReadOnly(restored)
+	if err != nil {
+		t.Fatalf("open copy: %v", err)
+	}
+	defer copied.Close()
+	var check, values string
+	if err := copied.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil || check != "ok" {
+		t.Fatalf("integrity_check=%q err=%v", check, err)
+	}
+	if err := copied.QueryRow("SELECT group_concat(value, '') FROM test").Scan(&values); err != nil || values != "abc" {
+		t.Fatalf("values=%q err=%v, want abc", values, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := BackupToWriter(cancelled, db, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files left behind after cancel: %v", entries)
+	}
+}
+
+func TestRestoreFromReader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := OpenReadWriteCreate(filepath.Join(dir, "source.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT;
+		INSERT INTO test (value) VALUES ('a'), ('b'), ('c')`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := BackupToWriter(ctx, db, &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	dest := filepath.Join(dir, "restored.db")
+	restored, err := RestoreFromReader(ctx, dest, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	var values string
+	if err := restored.QueryRow("SELECT group_concat(value, '') FROM test").Scan(&values); err != nil || values != "abc" {
+		t.Fatalf("values=%q err=%v, want abc", values, err)
+	}
+	if _, err := restored.Exec("INSERT INTO test (value) VALUES ('d')"); err != nil {
+		t.Fatalf("restored database is not writable: %v", err)
+	}
+	restored.Close()
+
+	if _, err := RestoreFromReader(ctx, dest, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrDatabaseExists) {
+		t.Fatalf("expected ErrDatabaseExists, got %v", err)
+	}
+	if _, err := RestoreFromReader(ctx, dest, strings.NewReader("not a database")); !errors.Is(err, ErrDatabaseExists) {
+		t.Fatalf("expected ErrDatabaseExists before reading input, got %v", err)
+	}
+
+	restored, err = RestoreFromReader(ctx, dest, bytes.NewReader(buf.Bytes()), WithRestoreOverwrite())
+	if err != nil {
+		t.Fatalf("restore with overwrite: %v", err)
+	}
+	defer restored.Close()
+	if err := restored.QueryRow("SELECT group_concat(value, '') FROM test").Scan(&values); err != nil || values != "abc" {
+		t.Fatalf("values=%q err=%v, want abc after overwrite", values, err)
+	}
+
+	bad := filepath.Join(dir, "bad.db")
+	if _, err := RestoreFromReader(ctx, bad, strings.NewReader(strings.Repeat("garbage", 1000))); err == nil {
+		t.Fatal("expected invalid input to fail")
+	}
+	if _, err := os.Stat(bad); !os.IsNotExist(err) {
+		t.Errorf("destination created from invalid input: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.restore-*"))
+	if len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
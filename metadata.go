@@ -0,0 +1,58 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// metadataTable holds the WithMetadata key/value pairs.
+const metadataTable = "_sqlitebp_meta"
+
+// stampMetadata creates the metadata table if needed and inserts the pairs of kv whose
+// keys are not stored yet, all in one transaction. Existing values are kept, so the
+// metadata records the provenance of the database rather than of the latest open.
+func stampMetadata(ctx context.Context, db *sql.DB, kv map[string]string) error {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return Transaction(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+metadataTable+" (key TEXT PRIMARY KEY, value TEXT NOT NULL) STRICT"); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO "+metadataTable+" (key, value) VALUES (?, ?)", k, kv[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Metadata returns the key/value pairs stored with WithMetadata, or an empty map if the
+// database has none.
+func Metadata(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	kv := make(map[string]string)
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = ?)", metadataTable).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return kv, nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT key, value FROM "+metadataTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		kv[k] = v
+	}
+	return kv, rows.Err()
+}
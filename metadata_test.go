@@ -0,0 +1,62 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWithMetadata(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(fn, WithMetadata(map[string]string{
+		"app":        "inventory",
+		"version":    "1.2.0",
+		"created_at": "2026-10-15T12:00:00Z",
+	}))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+
+	// Reopening keeps the original values and adds new keys.
+	db, err = OpenReadWrite(fn, WithMetadata(map[string]string{"version": "2.0.0", "owner": "ops"}))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	db.Close()
+
+	ro, err := OpenReadOnly(fn, WithMetadata(map[string]string{"reader": "yes"}))
+	if err != nil {
+		t.Fatalf("read-only open: %v", err)
+	}
+	defer ro.Close()
+	got, err := Metadata(ctx, ro)
+	if err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	want := map[string]string{
+		"app":        "inventory",
+		"version":    "1.2.0",
+		"created_at": "2026-10-15T12:00:00Z",
+		"owner":      "ops",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata = %v, want %v", got, want)
+	}
+
+	plain, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "plain.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer plain.Close()
+	if got, err := Metadata(ctx, plain); err != nil || len(got) != 0 {
+		t.Fatalf("expected no metadata, got %v (err=%v)", got, err)
+	}
+
+	if _, err := OpenReadWriteCreate(fn, WithMetadata(nil)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
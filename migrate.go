@@ -0,0 +1,37 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// migrate checks db's user_version against migrations and, if apply is set, runs the
+// pending ones (see WithMigrations).
+func migrate(ctx context.Context, db *sql.DB, migrations []string, apply bool) error {
+	return Transaction(ctx, db, func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+			return errors.Join(ErrPragmaExec, fmt.Errorf("failed to read user_version: %w", err))
+		}
+		if version > len(migrations) {
+			return errors.Join(ErrSchemaNewerThanBinary, fmt.Errorf("database is at version %d but the latest migration is %d", version, len(migrations)))
+		}
+		if !apply {
+			return nil
+		}
+		for i := version; i < len(migrations); i++ {
+			if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+				return errors.Join(ErrInitSQL, fmt.Errorf("migration %d failed: %w", i+1, err))
+			}
+		}
+		if version < len(migrations) {
+			// PRAGMA arguments cannot be bound.
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+				return errors.Join(ErrPragmaExec, fmt.Errorf("failed to set user_version: %w", err))
+			}
+		}
+		return nil
+	})
+}
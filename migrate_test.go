@@ -0,0 +1,110 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithMigrations(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "migrate.db")
+	migrations := []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL) STRICT",
+		"ALTER TABLE users ADD COLUMN name TEXT",
+	}
+
+	version := func(fn string) int {
+		t.Helper()
+		db, err := OpenReadOnly(fn)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		var v int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+			t.Fatalf("user_version: %v", err)
+		}
+		return v
+	}
+
+	db, err := OpenReadWriteCreate(fn, WithMigrations(migrations[:1]...))
+	if err != nil {
+		t.Fatalf("first migration: %v", err)
+	}
+	db.Close()
+	if v := version(fn); v != 1 {
+		t.Fatalf("user_version = %d, want 1", v)
+	}
+
+	// Only the new migration runs on the next open.
+	db, err = OpenReadWrite(fn, WithMigrations(migrations...))
+	if err != nil {
+		t.Fatalf("second migration: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (email, name) VALUES ('a@example.com', 'A')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	db.Close()
+	if v := version(fn); v != 2 {
+		t.Fatalf("user_version = %d, want 2", v)
+	}
+
+	// A failing migration leaves the previous version in place.
+	_, err = OpenReadWrite(fn, WithMigrations(append(migrations, "CREATE TABLE users (id INTEGER)")...))
+	if !errors.Is(err, ErrInitSQL) {
+		t.Fatalf("expected ErrInitSQL, got %v", err)
+	}
+	if v := version(fn); v != 2 {
+		t.Fatalf("user_version = %d after failed migration, want 2", v)
+	}
+}
+
+func TestWithMigrations_SchemaNewerThanBinary(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "newer.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatalf("user_version: %v", err)
+	}
+	db.Close()
+
+	migrations := []string{"CREATE TABLE a (id INTEGER PRIMARY KEY)", "CREATE TABLE b (id INTEGER PRIMARY KEY)"}
+	if _, err := OpenReadWrite(fn, WithMigrations(migrations...)); !errors.Is(err, ErrSchemaNewerThanBinary) {
+		t.Fatalf("expected ErrSchemaNewerThanBinary, got %v", err)
+	}
+	if _, err := OpenReadOnly(fn, WithMigrations(migrations...)); !errors.Is(err, ErrSchemaNewerThanBinary) {
+		t.Fatalf("expected ErrSchemaNewerThanBinary for read-only open, got %v", err)
+	}
+
+	// Nothing was applied.
+	db, err = OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_schema").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("schema objects=%d err=%v, want none", n, err)
+	}
+}
+
+func TestWithMigrations_OutlastOpenStepTimeout(t *testing.T) {
+	orig := openStepTimeout
+	t.Cleanup(func() { openStepTimeout = orig })
+	openStepTimeout = 20 * time.Millisecond
+
+	sleep := WithFunc("sleep_ms", func(ms int64) int64 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return ms
+	}, false)
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "slow.db"), sleep,
+		WithMigrations("CREATE TABLE t AS SELECT sleep_ms(100) AS v"),
+		WithValidationQuery("SELECT count(*) FROM t"))
+	if err != nil {
+		t.Fatalf("open with a slow migration: %v", err)
+	}
+	db.Close()
+}
@@ -3,22 +3,268 @@ package sqlitebp
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"regexp"
+	"runtime/cgo"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 // openConfig holds user-specified parameters and per-connection pragmas.
 // params are translated into DSN key/value pairs.
-// pragmas are explicit PRAGMA statements applied via the driver ConnectHook for each connection.
+// pragmas are explicit PRAGMA statements applied via the driver ConnectHook for each connection,
+// in insertion order apart from the few that must run first (see pragmaPriority).
 type openConfig struct {
-	params          map[string]string
-	pragmas         map[string]string
-	disableOptimize bool
+	params           map[string]string
+	pragmas          []pragma
+	disableOptimize  bool
+	profile          Profile // WithProfile, "" if none
+	walHook          func(dbName string, pages int) int
+	walSizeLimit     int64
+	features         []Feature
+	extensions       []extension
+	attachments      []attachment // WithAttachReadOnly and OpenSharded
+	unionViews       []unionView  // WithUnionView
+	unionCommon      bool         // OpenDateRange: union views of the tables all attachments have
+	maxOpenConns     int
+	connMaxIdleTime  time.Duration
+	errorLog         func(code int, msg string)
+	funcs            []function
+	collations       []collation
+	tableFuncs       []tableFunc // WithTableFunc
+	initSQL          []string
+	connectHooks     []func(conn *sqlite3.SQLiteConn) error
+	reindex          []string // WithReindexOnOpen
+	connInitTimeout  time.Duration
+	openAttempts     int // WithOpenRetry, 0 for a single attempt
+	openBackoff      time.Duration
+	limits           map[int]int
+	noFollow         bool
+	immutable        bool
+	noWALReplay      bool // WithoutWALReplay
+	mmapWholeFile    bool // WithMMapWholeFile
+	schemaRefresh    bool // WithReadOnlySchemaRefresh
+	restoreOverwrite bool // WithRestoreOverwrite
+	chunkSize        int
+	persistWAL       bool
+	memory           bool // OpenMemory and OpenSharedMemory
+	vfs              VFS  // WithVFSImplementation, registered as params["vfs"]
+
+	schemaAssertions []schemaAssertion
+	migrations       []string
+	redactedParams   []string // lower case, see WithRedactedParams
+	validationQuery  string
+	name             string // WithName
+	warmup           bool
+	cgroupPoolSizing bool
+	shmMode          string
+	metadata         map[string]string
+	advisoryLocks    []string // WithAdvisoryLock
+	minVersion       int      // SQLITE_VERSION_NUMBER form, 0 if unset
+
+	connMaxLifetime       time.Duration
+	connMaxLifetimeJitter time.Duration
+	validationInterval    time.Duration // WithValidationInterval
+
+	progressOps       int
+	progressFn        func() bool
+	interruptOnCancel bool
+	autoReconnect     bool
+	statementTimeout  time.Duration
+	stepTimeout       time.Duration  // WithStatementTimeout
+	txOptions         bool           // WithTxOptions
+	deadlineBusy      bool           // WithDeadlineAwareBusyHandler
+	connLabels        *atomic.Uint64 // last assigned label, if WithConnectionLabels
+	poolEvents        *poolEvents
+
+	slowPlanThreshold time.Duration
+	slowPlanSink      func(query, plan string)
+	statementFilter   func(query string) error // WithStatementFilter
+
+	sessionTables []string
+	sessionSink   func(changeset []byte)
+
+	changeNotifier *changeNotifier // WithChangeNotifier
+
+	busyDiag *busyDiagnostics // WithBusyDiagnostics
+
+	auditHook     func(event OpenEvent) // WithAuditHook
+	auditIdentity string                // WithAuditIdentity
+
+	hookAttempts int // WithConnectHookErrorRecovery, 0 for a single attempt
+	hookBackoff  time.Duration
+	hookOnError  func(err error, attempt int)
+
+	// Set once the open succeeded; connect hook failures before then fail the open.
+	opened atomic.Bool
+
+	// Set by openWithMode.
+	filename       string
+	walHookState   *walHook   // from walHook and walSizeLimit
+	walHookHandle  cgo.Handle // of walHookState
+	progressHandle cgo.Handle // from progressFn
+}
+
+// function is a Go function registered as an SQL function on each connection.
+type function struct {
+	name string
+	impl any
+	pure bool
+}
+
+// collation is a collating sequence registered with WithCollation.
+type collation struct {
+	name string
+	cmp  func(a, b string) int
+}
+
+// tableFunc is a virtual table module registered with WithTableFunc. register wraps
+// CreateModule, which only exists in builds with the sqlite_vtable tag.
+type tableFunc struct {
+	name     string
+	register func(conn *sqlite3.SQLiteConn) error
+}
+
+// pragma is a PRAGMA name and value applied on each new connection.
+type pragma struct {
+	name, value string
+}
+
+// pragmaPriority orders pragmas that must run before others. key (SQLCipher) must precede
+// any access to the file; encoding and page_size only take effect before the database is
+// first written, which switching journal_mode to WAL does. Unlisted pragmas run afterwards
+// in the order they were set.
+var pragmaPriority = map[string]int{
+	"key":          0,
+	"encoding":     1,
+	"page_size":    2,
+	"journal_mode": 3,
+}
+
+// pragma returns the value set for name.
+func (c *openConfig) pragma(name string) (string, bool) {
+	for _, p := range c.pragmas {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// setPragma appends name=value, replacing any earlier value in place.
+func (c *openConfig) setPragma(name, value string) {
+	for i, p := range c.pragmas {
+		if p.name == name {
+			c.pragmas[i].value = value
+			return
+		}
+	}
+	c.pragmas = append(c.pragmas, pragma{name: name, value: value})
+}
+
+// orderedPragmas returns the pragmas in the order they must be applied.
+func (c *openConfig) orderedPragmas() []pragma {
+	ordered := slices.Clone(c.pragmas)
+	rank := func(p pragma) int {
+		if r, ok := pragmaPriority[p.name]; ok {
+			return r
+		}
+		return len(pragmaPriority)
+	}
+	slices.SortStableFunc(ordered, func(a, b pragma) int { return rank(a) - rank(b) })
+	return ordered
+}
+
+// extension is a run-time loadable extension and its entry point.
+type extension struct {
+	path, entry string
+}
+
+// attachment is a database attached to each connection under schema, read-only unless
+// writable (OpenSharded).
+type attachment struct {
+	schema, filename string
+	writable         bool
+}
+
+// uri returns the URI filename ATTACH opens a.filename with, using vfs if not empty; an
+// attached database otherwise uses the VFS of the main database. SQLite decodes %HH escapes
+// in the path and ends it at "?" or "#", so those characters are escaped.
+func (a attachment) uri(vfs string) string {
+	mode := "ro"
+	if a.writable {
+		mode = "rwc"
+	}
+	uri := "file:" + uriPathEscaper.Replace(a.filename) + "?mode=" + mode
+	if vfs != "" {
+		uri += "&vfs=" + vfs
+	}
+	return uri
+}
+
+// unionView is a TEMP view over a table of every attached database, see WithUnionView.
+type unionView struct {
+	view, table string
+}
+
+// sql returns the CREATE statement of v over the tables in attachments.
+func (v unionView) sql(attachments []attachment) string {
+	selects := make([]string, len(attachments))
+	for i, a := range attachments {
+		selects[i] = "SELECT * FROM " + quoteIdent(a.schema) + "." + quoteIdent(v.table)
+	}
+	return "CREATE TEMP VIEW " + quoteIdent(v.view) + " AS " + strings.Join(selects, " UNION ALL ")
+}
+
+// maxAttached is SQLITE_MAX_ATTACHED, the default and most databases SQLite can attach.
+const maxAttached = 10
+
+// attachLimit returns the number of databases each connection can attach.
+func (c *openConfig) attachLimit() int {
+	if limit, ok := c.limits[sqlite3.SQLITE_LIMIT_ATTACHED]; ok {
+		return min(limit, maxAttached)
+	}
+	return maxAttached
+}
+
+var uriPathEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// requireFeature records f for the preflight check unless already present.
+func (c *openConfig) requireFeature(f Feature) {
+	for _, existing := range c.features {
+		if existing == f {
+			return
+		}
+	}
+	c.features = append(c.features, f)
 }
 
 // Option configures database parameters prior to opening.
 type Option func(*openConfig) error
 
+// newOpenConfig returns a config with the user options applied (defaults are merged later).
+func newOpenConfig(opts ...Option) (*openConfig, error) {
+	cfg := &openConfig{
+		params: make(map[string]string),
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
 // WithOptimize enables or disables running PRAGMA optimize on each new connection (default enabled).
+// Read-only opens never run it.
 func WithOptimize(enabled bool) Option {
 	return func(c *openConfig) error {
 		// default is enabled; only store disabled state
@@ -57,6 +303,8 @@ func WithCacheSizeMiB(mib int) Option {
 }
 
 // WithJournalMode sets journal mode (ignored in read-only opens where we do not force WAL).
+// WAL2 is only available in SQLite builds from the experimental wal2 branch; other builds
+// fail the open with ErrPragmaExec instead of silently keeping the previous mode.
 func WithJournalMode(mode string) Option {
 	return func(c *openConfig) error {
 		if _, exists := c.params["_journal_mode"]; exists {
@@ -64,7 +312,7 @@ func WithJournalMode(mode string) Option {
 		}
 		m := strings.ToUpper(mode)
 		switch m {
-		case "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
+		case "WAL", "WAL2", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
 			c.params["_journal_mode"] = m
 		default:
 			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid journal mode %q", mode))
@@ -73,6 +321,72 @@ func WithJournalMode(mode string) Option {
 	}
 }
 
+// Profile is a named bundle of defaults, see WithProfile.
+type Profile string
+
+const (
+	// HighThroughput favors write throughput: synchronous=NORMAL, a 128 MiB page cache and
+	// WAL checkpoints every 10000 pages instead of 1000.
+	HighThroughput Profile = "high_throughput"
+	// Durable makes each commit durable across power loss: synchronous=FULL and fullfsync
+	// (which only has an effect on macOS).
+	Durable Profile = "durable"
+	// LowMemory minimizes memory use: a 2 MiB page cache, no memory-mapped I/O and
+	// temporary tables and indices on disk.
+	LowMemory Profile = "low_memory"
+)
+
+// profileSettings are the DSN params and pragmas each Profile sets.
+var profileSettings = map[Profile]struct {
+	params  map[string]string
+	pragmas []pragma
+}{
+	HighThroughput: {
+		params:  map[string]string{"_synchronous": "NORMAL", "_cache_size": "-131072"},
+		pragmas: []pragma{{name: "wal_autocheckpoint", value: "10000"}},
+	},
+	Durable: {
+		params:  map[string]string{"_synchronous": "FULL"},
+		pragmas: []pragma{{name: "fullfsync", value: "ON"}},
+	},
+	LowMemory: {
+		params:  map[string]string{"_cache_size": "-2048"},
+		pragmas: []pragma{{name: "mmap_size", value: "0"}, {name: "temp_store", value: "FILE"}},
+	},
+}
+
+// WithProfile applies the settings of a Profile (HighThroughput, Durable or LowMemory) as
+// defaults: the options that set the same pragma, such as WithSynchronous,
+// WithCacheSizeMiB or WithPragma("wal_autocheckpoint", ...), take precedence whatever
+// their order.
+func WithProfile(p Profile) Option {
+	return func(c *openConfig) error {
+		if _, ok := profileSettings[p]; !ok {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid profile %q", p))
+		}
+		if c.profile != "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("profile already specified"))
+		}
+		c.profile = p
+		return nil
+	}
+}
+
+// applyProfile fills in the settings of c.profile that options left unset.
+func (c *openConfig) applyProfile() {
+	settings := profileSettings[c.profile]
+	for k, v := range settings.params {
+		if _, ok := c.params[k]; !ok {
+			c.params[k] = v
+		}
+	}
+	for _, p := range settings.pragmas {
+		if _, ok := c.pragma(p.name); !ok {
+			c.setPragma(p.name, p.value)
+		}
+	}
+}
+
 // WithSynchronous sets the synchronous level.
 func WithSynchronous(level string) Option {
 	return func(c *openConfig) error {
@@ -90,6 +404,56 @@ func WithSynchronous(level string) Option {
 	}
 }
 
+// WithTransactionLock sets the locking behavior of BEGIN (DEFERRED, IMMEDIATE, EXCLUSIVE).
+// The default for write-capable opens is IMMEDIATE; ignored in read-only opens.
+func WithTransactionLock(lock string) Option {
+	return func(c *openConfig) error {
+		if _, exists := c.params["_txlock"]; exists {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("_txlock already specified"))
+		}
+		l := strings.ToLower(lock)
+		switch l {
+		case "deferred", "immediate", "exclusive":
+			c.params["_txlock"] = l
+		default:
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid transaction lock %q", lock))
+		}
+		return nil
+	}
+}
+
+// WithTxOptions makes BeginTx honor sql.TxOptions, which go-sqlite3 ignores:
+//
+//   - ReadOnly starts a DEFERRED transaction with PRAGMA query_only set until it ends, so
+//     it never takes the write lock and writes in it fail with ErrReadOnly.
+//   - LevelSerializable and LevelLinearizable start an IMMEDIATE transaction, taking the
+//     write lock up front even if WithTransactionLock("DEFERRED") is set.
+//   - LevelReadUncommitted, LevelReadCommitted, LevelRepeatableRead and LevelSnapshot
+//     start a DEFERRED transaction: SQLite transactions are always serializable, and in WAL
+//     mode a deferred transaction reads from a single snapshot.
+//   - LevelDefault keeps the WithTransactionLock behavior. Other levels are rejected.
+func WithTxOptions() Option {
+	return func(c *openConfig) error {
+		c.txOptions = true
+		return nil
+	}
+}
+
+// WithDeadlineAwareBusyHandler replaces the busy timeout's fixed wait with a busy handler
+// that gives up, returning SQLITE_BUSY ("database is locked"), as soon as the context of
+// the statement or BeginTx waiting for the lock is done or past its deadline. It waits
+// with the same back-off as SQLite's built-in handler and still for at most the busy
+// timeout (WithBusyTimeoutSeconds). Without it a caller whose deadline is shorter than the
+// busy timeout sleeps past the deadline before seeing an error.
+//
+// Setting PRAGMA busy_timeout on a connection replaces the handler.
+func WithDeadlineAwareBusyHandler() Option {
+	return func(c *openConfig) error {
+		c.deadlineBusy = true
+		return nil
+	}
+}
+
 // WithForeignKeys enables or disables foreign key enforcement.
 func WithForeignKeys(enabled bool) Option {
 	return func(c *openConfig) error {
@@ -109,13 +473,13 @@ func WithForeignKeys(enabled bool) Option {
 // This cannot be reliably set via DSN driver underscore parameter; we apply it through ConnectHook.
 func WithTempStore(store string) Option {
 	return func(c *openConfig) error {
-		if _, exists := c.pragmas["temp_store"]; exists {
+		if _, exists := c.pragma("temp_store"); exists {
 			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("temp_store already specified"))
 		}
 		s := strings.ToUpper(store)
 		switch s {
 		case "DEFAULT", "FILE", "MEMORY":
-			c.pragmas["temp_store"] = s
+			c.setPragma("temp_store", s)
 		default:
 			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid temp_store %q", store))
 		}
@@ -123,16 +487,34 @@ func WithTempStore(store string) Option {
 	}
 }
 
-// WithMMapSize sets the mmap size in bytes (0 disables memory mapping growth beyond default). Applies via DSN.
+// WithMMapSize sets the maximum number of bytes of the database file to memory-map
+// (0 disables memory-mapped I/O). Applied per connection with PRAGMA mmap_size. Read-only
+// opens default to defaultReadOnlyMMapSize; other modes use SQLite's default of 0.
 func WithMMapSize(bytes int64) Option {
 	return func(c *openConfig) error {
 		if bytes < 0 {
 			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("mmap size must be >= 0"))
 		}
-		if _, exists := c.params["_mmap_size"]; exists {
-			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("_mmap_size already specified"))
+		if _, exists := c.pragma("mmap_size"); exists || c.mmapWholeFile {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("mmap_size already specified"))
+		}
+		c.setPragma("mmap_size", fmt.Sprintf("%d", bytes))
+		return nil
+	}
+}
+
+// WithMMapWholeFile memory-maps the entire database file of a read-only open, for a
+// database that fits in RAM and is read heavily. mmap_size is set to the size of the file
+// when it is opened, up to SQLITE_MAX_MMAP_SIZE (about 2 GiB); pages added by another
+// process afterwards are read with read() as usual. Only valid with
+// ModeReadOnly, and cannot be combined with WithMMapSize. WithGlobalMmapLimit still caps
+// the mapping.
+func WithMMapWholeFile() Option {
+	return func(c *openConfig) error {
+		if _, exists := c.pragma("mmap_size"); exists || c.mmapWholeFile {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("mmap_size already specified"))
 		}
-		c.params["_mmap_size"] = fmt.Sprintf("%d", bytes)
+		c.mmapWholeFile = true
 		return nil
 	}
 }
@@ -183,3 +565,1121 @@ func WithSecureDelete(mode string) Option {
 		return nil
 	}
 }
+
+// WithWALHook registers fn to be called after each commit in WAL mode with the schema
+// name and the number of pages now in the WAL. It fires on the connection that committed.
+// fn should return 0 (SQLITE_OK); any other value is reported as an error by the commit.
+// The default auto-checkpoint (PASSIVE at 1000 pages) is preserved after fn returns.
+func WithWALHook(fn func(dbName string, pages int) int) Option {
+	return func(c *openConfig) error {
+		if fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("wal hook cannot be nil"))
+		}
+		if c.walHook != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("wal hook already specified"))
+		}
+		c.walHook = fn
+		return nil
+	}
+}
+
+// WithWALSizeLimit keeps the WAL from growing past about limit bytes. The automatic
+// checkpoint is PASSIVE: it never waits for readers, so under a steady stream of
+// overlapping reads it cannot reset the WAL, which then grows without bound. With a limit,
+// the commit that takes the WAL past it runs a TRUNCATE checkpoint instead, which waits
+// for readers up to the busy timeout (blocking the committing caller, and new writers,
+// meanwhile) and truncates the file to zero bytes. Each forced checkpoint is reported to
+// the WithErrorLogCallback callback as SQLITE_NOTICE, or SQLITE_WARNING if it failed, in
+// which case the next commit tries again. A single transaction larger than limit still
+// grows the WAL past it. PRAGMA journal_size_limit defaults to limit as well, so the file
+// is also cut back after ordinary checkpoints. Requires a writable mode.
+func WithWALSizeLimit(limit int64) Option {
+	return func(c *openConfig) error {
+		if limit <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("wal size limit must be > 0"))
+		}
+		if c.walSizeLimit != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("wal size limit already specified"))
+		}
+		c.walSizeLimit = limit
+		return nil
+	}
+}
+
+// WithRequiredFeatures fails the open with ErrFeatureUnavailable if the linked SQLite
+// build lacks any of the given features. Checked against PRAGMA compile_options.
+func WithRequiredFeatures(features ...Feature) Option {
+	return func(c *openConfig) error {
+		for _, f := range features {
+			if _, ok := featureChecks[f]; !ok {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("unknown feature %q", f))
+			}
+			c.requireFeature(f)
+		}
+		return nil
+	}
+}
+
+// WithLoadExtension loads the extension at path on each new connection.
+// An empty entry uses the default entry point "sqlite3_extension_init".
+// Implies FeatureLoadExtension, so builds without extension support fail with a clear error.
+func WithLoadExtension(path, entry string) Option {
+	return func(c *openConfig) error {
+		if path == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("extension path cannot be empty"))
+		}
+		if entry == "" {
+			entry = "sqlite3_extension_init"
+		}
+		c.requireFeature(FeatureLoadExtension)
+		c.extensions = append(c.extensions, extension{path: path, entry: entry})
+		return nil
+	}
+}
+
+// WithAttachReadOnly attaches the existing database filename to each new connection as
+// schema, opened read-only whatever the mode of the main database, e.g. for reference data
+// queried as schema.table alongside a read/write main database. Writes to its tables fail
+// with SQLITE_READONLY. Attachments are made before WithInitSQL statements run.
+func WithAttachReadOnly(schema, filename string) Option {
+	return attach(schema, filename, false)
+}
+
+// attach attaches filename as schema, creating it if writable.
+func attach(schema, filename string, writable bool) Option {
+	return func(c *openConfig) error {
+		if schema == "" || filename == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("attach schema name and filename cannot be empty"))
+		}
+		if strings.EqualFold(schema, "main") || strings.EqualFold(schema, "temp") {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("cannot attach as reserved schema %q", schema))
+		}
+		for _, a := range c.attachments {
+			if strings.EqualFold(a.schema, schema) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("attach schema %q already specified", schema))
+			}
+		}
+		c.attachments = append(c.attachments, attachment{schema: schema, filename: filename, writable: writable})
+		return nil
+	}
+}
+
+// WithUnionView creates the TEMP view named view on each new connection, combining table
+// from every attached database with UNION ALL, in the order they were attached, e.g. to
+// query the shards of OpenSharded as one table. The tables must have the same columns.
+// May be given more than once.
+func WithUnionView(view, table string) Option {
+	return func(c *openConfig) error {
+		if view == "" || table == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("union view and table names cannot be empty"))
+		}
+		for _, v := range c.unionViews {
+			if strings.EqualFold(v.view, view) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("union view %q already specified", view))
+			}
+		}
+		c.unionViews = append(c.unionViews, unionView{view: view, table: table})
+		return nil
+	}
+}
+
+// WithMaxOpenConns overrides the default pool size (n > 0). Idle connections are kept up to the same limit.
+func WithMaxOpenConns(n int) Option {
+	return func(c *openConfig) error {
+		if n <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("max open conns must be > 0"))
+		}
+		if c.maxOpenConns != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("max open conns already specified"))
+		}
+		c.maxOpenConns = n
+		return nil
+	}
+}
+
+// WithCgroupAwarePoolSizing sizes the default pool for the container's CPU limit
+// (cgroup v1 or v2 CPU quota, rounded up) when it is lower than GOMAXPROCS, which on
+// Kubernetes may otherwise reflect all cores of the node. Without a quota the default
+// sizing is unchanged. It cannot be combined with WithMaxOpenConns.
+func WithCgroupAwarePoolSizing() Option {
+	return func(c *openConfig) error {
+		c.cgroupPoolSizing = true
+		return nil
+	}
+}
+
+// WithConnMaxIdleTime closes pooled connections that have been idle longer than d (default 0, never).
+// Useful for read-only handles on network filesystems: recycled connections release stale file
+// handles and pick up a database file that was atomically replaced (e.g. by a deploy).
+// database/sql checks for idle connections at most once per second.
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(c *openConfig) error {
+		if d < 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("conn max idle time must be >= 0"))
+		}
+		if c.connMaxIdleTime != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("conn max idle time already specified"))
+		}
+		c.connMaxIdleTime = d
+		return nil
+	}
+}
+
+// WithValidationInterval checks a pooled connection that has been idle for longer than d
+// before it is handed out again: its database file must still be the one at the path it
+// was opened with (not deleted, renamed or replaced), and reading the schema must succeed.
+// A connection that fails is closed and database/sql opens a new one. Unlike
+// WithConnMaxIdleTime, connections that pass are kept.
+func WithValidationInterval(d time.Duration) Option {
+	return func(c *openConfig) error {
+		if d <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("validation interval must be > 0"))
+		}
+		if c.validationInterval != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("validation interval already specified"))
+		}
+		c.validationInterval = d
+		return nil
+	}
+}
+
+// WithErrorLogCallback registers fn as SQLite's error log callback (SQLITE_CONFIG_LOG),
+// which reports errors and warnings that never reach a Go error return, such as WAL
+// recovery notices and corruption warnings. code is the (extended) result code.
+//
+// The callback is process-global: the first open that supplies one installs it for
+// every connection in the process and later callbacks are ignored. fn may be invoked
+// concurrently from any connection and must not use SQLite itself.
+func WithErrorLogCallback(fn func(code int, msg string)) Option {
+	return func(c *openConfig) error {
+		if fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("error log callback cannot be nil"))
+		}
+		c.errorLog = fn
+		return nil
+	}
+}
+
+// WithFunc registers impl as the SQL function name on each new connection.
+// impl follows go-sqlite3's RegisterFunc rules; pure marks it deterministic so it can be used in indexes.
+func WithFunc(name string, impl any, pure bool) Option {
+	return func(c *openConfig) error {
+		if name == "" || impl == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("function name and implementation are required"))
+		}
+		for _, f := range c.funcs {
+			if strings.EqualFold(f.name, name) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("function %q already specified", name))
+			}
+		}
+		c.funcs = append(c.funcs, function{name: name, impl: impl, pure: pure})
+		return nil
+	}
+}
+
+// WithCollation registers cmp as the collating sequence name on each new connection,
+// before anything that might use it (an index on a COLLATE name column cannot be read
+// without it, not even by PRAGMA optimize). cmp returns a negative number, zero or a
+// positive number as a sorts before, equal to or after b. If cmp changes between
+// releases, rebuild the indexes that use it with WithReindexOnOpen.
+func WithCollation(name string, cmp func(a, b string) int) Option {
+	return func(c *openConfig) error {
+		if name == "" || cmp == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("collation name and comparison function are required"))
+		}
+		for _, coll := range c.collations {
+			if strings.EqualFold(coll.name, name) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("collation %q already specified", name))
+			}
+		}
+		c.collations = append(c.collations, collation{name: name, cmp: cmp})
+		return nil
+	}
+}
+
+// WithInitSQL runs the given statements, in order, on each new connection after all
+// other settings are applied (e.g. TEMP tables or views, ATTACH). Failures wrap ErrInitSQL.
+// May be given more than once; statements accumulate.
+func WithInitSQL(statements ...string) Option {
+	return func(c *openConfig) error {
+		for _, s := range statements {
+			if strings.TrimSpace(s) == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("init sql statement cannot be empty"))
+			}
+		}
+		c.initSQL = append(c.initSQL, statements...)
+		return nil
+	}
+}
+
+// WithConnectHook calls fn on each new connection after WithInitSQL statements, for
+// setup that needs the driver connection itself (e.g. RegisterAggregator, SetTrace or
+// RegisterUpdateHook). Foreign key enforcement and all pragmas are already in effect.
+// May be given more than once; hooks run in order. An error fails the connection, and an
+// error on the open's first connection fails the open with ErrConnectHook (see
+// WithConnectHookErrorRecovery).
+func WithConnectHook(fn func(conn *sqlite3.SQLiteConn) error) Option {
+	return func(c *openConfig) error {
+		if fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("connect hook cannot be nil"))
+		}
+		c.connectHooks = append(c.connectHooks, fn)
+		return nil
+	}
+}
+
+// WithConnectHookErrorRecovery sets how WithConnectHook failures on connections opened
+// after the handle are handled, such as an ATTACH of a file that was since removed. The
+// failing hook is retried up to attempts times in all, waiting backoff before the second try
+// and doubling the wait each time up to a minute, and onError, if not nil, is called with
+// each failure and its attempt number. The connection fails once the attempts run out, or
+// when the context it was requested with (e.g. of DB.Conn) is done while waiting. Failures
+// during the open itself are never retried: the open fails with ErrConnectHook.
+func WithConnectHookErrorRecovery(attempts int, backoff time.Duration, onError func(err error, attempt int)) Option {
+	return func(c *openConfig) error {
+		if attempts < 1 || backoff <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("connect hook recovery requires attempts >= 1 and backoff > 0"))
+		}
+		if c.hookAttempts != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("connect hook recovery already specified"))
+		}
+		c.hookAttempts = attempts
+		c.hookBackoff = backoff
+		c.hookOnError = onError
+		return nil
+	}
+}
+
+// WithReindexOnOpen runs REINDEX for each named collation when a write-capable handle is
+// opened, rebuilding the indexes that use it. SQLite stores index entries in collation
+// order, so after a collation registered with WithCollation changes how it compares,
+// lookups and ORDER BY through its indexes are wrong until they are rebuilt. May be given
+// more than once. Like WithMigrations, the rebuild has no timeout of its own; only the
+// context of OpenContext bounds it.
+func WithReindexOnOpen(collations ...string) Option {
+	return func(c *openConfig) error {
+		for _, name := range collations {
+			if name == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("reindex collation name cannot be empty"))
+			}
+			if slices.Contains(c.reindex, name) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("reindex of collation %q already specified", name))
+			}
+			c.reindex = append(c.reindex, name)
+		}
+		return nil
+	}
+}
+
+// WithConnectionInitTimeout bounds the time spent initializing each new connection
+// (PRAGMAs and WithInitSQL statements). A statement still running at the deadline is
+// interrupted and the connection fails with an error wrapping context.DeadlineExceeded.
+func WithConnectionInitTimeout(d time.Duration) Option {
+	return func(c *openConfig) error {
+		if d <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("connection init timeout must be > 0"))
+		}
+		if c.connInitTimeout != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("connection init timeout already specified"))
+		}
+		c.connInitTimeout = d
+		return nil
+	}
+}
+
+// WithPageSize sets the database page size in bytes (a power of two from 512 to 65536).
+// It only takes effect when the database is created or rebuilt; use VacuumAndReopen to
+// change the page size of an existing database.
+func WithPageSize(bytes int) Option {
+	return func(c *openConfig) error {
+		if bytes < 512 || bytes > 65536 || bytes&(bytes-1) != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("page size must be a power of two between 512 and 65536"))
+		}
+		if _, exists := c.pragma("page_size"); exists {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("page_size already specified"))
+		}
+		c.setPragma("page_size", fmt.Sprintf("%d", bytes))
+		return nil
+	}
+}
+
+// WithChunkSize makes SQLite grow (and truncate) the database file in multiples of
+// bytes (SQLITE_FCNTL_CHUNK_SIZE), reducing filesystem fragmentation for databases that
+// grow steadily, at the cost of up to one chunk of preallocated space. bytes must be a
+// positive multiple of 64 KiB, which keeps it a multiple of every page size, and at
+// most 1 GiB. The setting is per connection and applies to the main database file only.
+func WithChunkSize(bytes int) Option {
+	return func(c *openConfig) error {
+		if bytes <= 0 || bytes%(64<<10) != 0 || bytes > 1<<30 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("chunk size must be a positive multiple of 64 KiB up to 1 GiB"))
+		}
+		if c.chunkSize != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("chunk size already specified"))
+		}
+		c.chunkSize = bytes
+		return nil
+	}
+}
+
+// WithPersistentWAL keeps the -wal file when the last connection closes
+// (SQLITE_FCNTL_PERSIST_WAL), truncating it instead of deleting it, so that frequently
+// restarted processes do not pay to recreate it (default disabled). The -wal and -shm
+// files then remain next to the database while it is not in use; readers on read-only
+// media need them to be present.
+func WithPersistentWAL(enabled bool) Option {
+	return func(c *openConfig) error {
+		c.persistWAL = enabled
+		return nil
+	}
+}
+
+// WithLimit sets a run-time limit (one of the sqlite3.SQLITE_LIMIT_* constants) on each new connection.
+// Limits cannot be raised above the compile-time maximum; larger values are silently clamped by SQLite.
+func WithLimit(id, value int) Option {
+	return func(c *openConfig) error {
+		if id < sqlite3.SQLITE_LIMIT_LENGTH || id > sqlite3.SQLITE_LIMIT_WORKER_THREADS {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid limit id %d", id))
+		}
+		if value < 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("limit value must be >= 0"))
+		}
+		if c.limits == nil {
+			c.limits = make(map[int]int)
+		}
+		if _, exists := c.limits[id]; exists {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("limit %d already specified", id))
+		}
+		c.limits[id] = value
+		return nil
+	}
+}
+
+// WithNoFollow refuses to open the database if its path goes through a symbolic link,
+// like SQLITE_OPEN_NOFOLLOW. It guards against a writable directory being used to redirect
+// the database to another file. go-sqlite3 does not pass open flags, so files are opened
+// through a VFS over the default one that fails paths resolving through a link with
+// SQLITE_CANTOPEN_SYMLINK, as SQLite does for the flag; the resolved path is then opened
+// with O_NOFOLLOW, so a link swapped in after the check is not followed either. Every new
+// connection is checked, and a link as the final path component is reported by name.
+// Not valid with in-memory databases or WithVFSImplementation.
+func WithNoFollow() Option {
+	return func(c *openConfig) error {
+		c.noFollow = true
+		return nil
+	}
+}
+
+// OpenFlagNoFollow is SQLITE_OPEN_NOFOLLOW, see WithOpenFlag.
+const OpenFlagNoFollow = 0x01000000
+
+// WithOpenFlag applies an sqlite3_open_v2 flag that go-sqlite3 does not expose. Only
+// OpenFlagNoFollow is supported, which is WithNoFollow; other flags are rejected.
+func WithOpenFlag(flag int) Option {
+	return func(c *openConfig) error {
+		if flag != OpenFlagNoFollow {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("unsupported open flag %#x", flag))
+		}
+		c.noFollow = true
+		return nil
+	}
+}
+
+// WithDeterministicRandom replaces the random() and randomblob(N) SQL functions with
+// versions driven by a pseudo-random generator seeded with seed, so that a fresh database
+// produces the same sequence of values every run. SQLite's built-in generator cannot be
+// seeded. The generator is shared by all connections of the handle, so the sequence is
+// reproducible as long as statements run in the same order.
+//
+// Intended for tests and golden files only; the output is predictable by design.
+func WithDeterministicRandom(seed int64) Option {
+	return func(c *openConfig) error {
+		var mu sync.Mutex
+		rng := rand.New(rand.NewSource(seed))
+		random := func() int64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return int64(rng.Uint64())
+		}
+		randomblob := func(n int64) []byte {
+			// Like the built-in, sizes below 1 yield a single byte.
+			n = max(n, 1)
+			b := make([]byte, n)
+			mu.Lock()
+			defer mu.Unlock()
+			rng.Read(b)
+			return b
+		}
+		if err := WithFunc("random", random, false)(c); err != nil {
+			return err
+		}
+		return WithFunc("randomblob", randomblob, false)(c)
+	}
+}
+
+// managedPragmas have dedicated options (or defaults) and cannot be set with WithPragma.
+var managedPragmas = map[string]string{
+	"busy_timeout":        "WithBusyTimeoutSeconds",
+	"cache_size":          "WithCacheSizeMiB",
+	"case_sensitive_like": "WithCaseSensitiveLike",
+	"foreign_keys":        "WithForeignKeys",
+	"journal_mode":        "WithJournalMode",
+	"mmap_size":           "WithMMapSize",
+	"page_size":           "WithPageSize",
+	"recursive_triggers":  "WithRecursiveTriggers",
+	"secure_delete":       "WithSecureDelete",
+	"synchronous":         "WithSynchronous",
+	"temp_store":          "WithTempStore",
+}
+
+var (
+	pragmaNamePattern  = regexp.MustCompile(`^[a-z_]+$`)
+	pragmaValuePattern = regexp.MustCompile(`^(-?[0-9]+|[A-Za-z_][A-Za-z0-9_]*|'([^']|'')*')$`)
+)
+
+// WithPragma applies PRAGMA name=value on each new connection, for pragmas without a
+// dedicated option (e.g. key for SQLCipher builds, encoding, wal_autocheckpoint).
+// value must be an integer, a bare keyword or a single-quoted string literal.
+// Pragmas that must precede the first write (key, encoding, page_size) are applied first.
+func WithPragma(name, value string) Option {
+	return func(c *openConfig) error {
+		n := strings.ToLower(name)
+		if !pragmaNamePattern.MatchString(n) {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid pragma name %q", name))
+		}
+		if option, ok := managedPragmas[n]; ok {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("pragma %s must be set with %s", n, option))
+		}
+		if !pragmaValuePattern.MatchString(value) {
+			if c.sensitive(n) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid value for pragma %s", n))
+			}
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid value %q for pragma %s", value, n))
+		}
+		if _, exists := c.pragma(n); exists {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("%s already specified", n))
+		}
+		c.setPragma(n, value)
+		return nil
+	}
+}
+
+// WithRedactedParams adds DSN parameters or pragmas (e.g. set with WithPragma) whose
+// values must not appear in errors returned by the open or by later connections, in
+// addition to the built-in list of encryption keys (key, rekey, hexkey, hexrekey, textkey,
+// textrekey) and credentials (_auth_pass, _auth_salt). Occurrences of the values in error
+// messages are replaced with [REDACTED]. Names are case-insensitive; may be given more
+// than once.
+func WithRedactedParams(names ...string) Option {
+	return func(c *openConfig) error {
+		for _, name := range names {
+			if name == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("redacted param name cannot be empty"))
+			}
+			c.redactedParams = append(c.redactedParams, strings.ToLower(name))
+		}
+		return nil
+	}
+}
+
+// WithOpenRetry makes up to attempts tries to connect when the open's initial connection
+// fails with an error that may be transient, waiting backoff before the second try and
+// doubling the wait each time. This covers a volume that is not mounted yet during a
+// deploy (SQLITE_CANTOPEN from ENOENT), a lock held briefly by another process
+// (SQLITE_BUSY, SQLITE_LOCKED) and I/O errors such as EBUSY (SQLITE_IOERR). Other failures,
+// e.g. a file that is not a database or an invalid option, are returned immediately, as
+// is the last error once the open's context is done.
+func WithOpenRetry(attempts int, backoff time.Duration) Option {
+	return func(c *openConfig) error {
+		if attempts < 1 || backoff <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("open retry requires attempts >= 1 and backoff > 0"))
+		}
+		if c.openAttempts != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("open retry already specified"))
+		}
+		c.openAttempts = attempts
+		c.openBackoff = backoff
+		return nil
+	}
+}
+
+// WithConnMaxLifetime closes pooled connections once they are older than d (default 0, never).
+func WithConnMaxLifetime(d time.Duration) Option {
+	return WithConnMaxLifetimeJitter(d, 0)
+}
+
+// WithConnMaxLifetimeJitter closes pooled connections once they are older than base plus a
+// random duration in [0, jitter), drawn per connection. database/sql applies a single
+// lifetime to the whole pool, so connections opened together (e.g. at startup) all expire
+// together and reconnect in a burst; the jitter spreads those reconnects out.
+func WithConnMaxLifetimeJitter(base, jitter time.Duration) Option {
+	return func(c *openConfig) error {
+		if base <= 0 || jitter < 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("conn max lifetime must be > 0 and jitter >= 0"))
+		}
+		if c.connMaxLifetime != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("conn max lifetime already specified"))
+		}
+		c.connMaxLifetime = base
+		c.connMaxLifetimeJitter = jitter
+		return nil
+	}
+}
+
+// WithProgressHandler calls fn every ops SQLite virtual machine instructions while a statement
+// runs on any connection. Returning true aborts the statement with SQLITE_INTERRUPT, which
+// allows cooperative cancellation of runaway queries independent of a context (e.g. a
+// shared deadline or a global kill switch). fn runs on the executing goroutine's thread
+// and must be cheap and must not use the connection.
+func WithProgressHandler(ops int, fn func() bool) Option {
+	return func(c *openConfig) error {
+		if ops <= 0 || fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("progress handler requires ops > 0 and a non-nil func"))
+		}
+		if c.progressFn != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("progress handler already specified"))
+		}
+		c.progressOps = ops
+		c.progressFn = fn
+		return nil
+	}
+}
+
+// WithInterruptOnCancel makes context cancellation reliably stop running statements.
+// go-sqlite3 calls sqlite3_interrupt once when a statement's context is done, which is a
+// no-op if it lands between SQLite API calls, so a long statement can keep running. With
+// this option each connection's progress handler also checks the contexts of its running
+// statements and aborts as soon as one is done; the statement then returns ctx.Err().
+func WithInterruptOnCancel() Option {
+	return func(c *openConfig) error {
+		c.interruptOnCancel = true
+		return nil
+	}
+}
+
+// WithImmutable opens the database with the immutable URI parameter, telling SQLite the
+// file cannot change while it is open. SQLite then takes no locks and never touches the
+// -wal or -shm files, so a database on read-only media (a CD-ROM image, a read-only bind
+// mount) can be opened even if it was left in WAL mode. Only valid with ModeReadOnly.
+//
+// Heap-memory WAL indexes via EXCLUSIVE locking mode are not an alternative here: SQLite
+// still needs to create the -wal file, which fails on read-only media.
+//
+// If the file is modified anyway, queries may return incorrect results or report corruption.
+func WithImmutable() Option {
+	return func(c *openConfig) error {
+		c.immutable = true
+		return nil
+	}
+}
+
+// WithoutWALReplay opens a database that another process may be writing, e.g. for forensic
+// inspection, without any side effects on its files: like WithImmutable, SQLite takes no
+// locks and neither reads, replays nor creates the -wal and -shm files, so no checkpoint
+// can run and the writer is never blocked. Only valid with ModeReadOnly.
+//
+// The handle sees the main file as of the last checkpoint; transactions committed to the
+// WAL since then are invisible. Because nothing stops a checkpoint from rewriting pages
+// while a query reads them, a query may still see a mix of old and new pages, or fail with
+// SQLITE_CORRUPT; retry it, or copy the files for a consistent image. Connections are not
+// kept in the pool, so that each query starts without a cache of pages read earlier.
+func WithoutWALReplay() Option {
+	return func(c *openConfig) error {
+		c.noWALReplay = true
+		c.immutable = true
+		return nil
+	}
+}
+
+// WithVFSImplementation stores the database with vfs, a storage backend written in Go,
+// registering it with SQLite under name on first use. Opening with the same name and
+// implementation again reuses the registration; a different implementation, or a name
+// SQLite already knows such as "unix", fails the open with ErrOpenFailed.
+//
+// The bridge provides no shared memory, so WAL is unavailable: the default journal mode
+// becomes DELETE and WithJournalMode("WAL") is rejected. Attached databases use the VFS
+// too unless attached by URI with another. Not valid with OpenMemory.
+func WithVFSImplementation(name string, vfs VFS) Option {
+	return func(c *openConfig) error {
+		if name == "" || strings.ContainsAny(name, "&#=?% ") {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid VFS name %q", name))
+		}
+		if vfs == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("VFS implementation must not be nil"))
+		}
+		if _, exists := c.params["vfs"]; exists {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("vfs already specified"))
+		}
+		c.params["vfs"] = name
+		c.vfs = vfs
+		return nil
+	}
+}
+
+// WithReadOnlySchemaRefresh replaces a pooled connection of a read-only handle when
+// another connection or process has changed the schema (PRAGMA schema_version) since the
+// connection was opened, checked each time the pool hands the connection out. SQLite
+// re-reads the schema by itself, but statements prepared before the change keep their
+// old shape until they are stepped, e.g. a cached "SELECT *" reports the old columns, and
+// a long-lived handle may hold many such statements. Replacing the connection drops them;
+// database/sql prepares *sql.Stmt again on the new one.
+//
+// Connections that cannot see changes at all (WithImmutable) are replaced by age instead:
+// unless WithConnMaxLifetime is given, connections live for at most 5 minutes.
+func WithReadOnlySchemaRefresh() Option {
+	return func(c *openConfig) error {
+		c.schemaRefresh = true
+		return nil
+	}
+}
+
+// WithRestoreOverwrite lets RestoreFromReader replace an existing database. It has no
+// effect on other opens.
+func WithRestoreOverwrite() Option {
+	return func(c *openConfig) error {
+		c.restoreOverwrite = true
+		return nil
+	}
+}
+
+// WithShmMode selects where the WAL index lives: "mmap" (the default) uses the shared
+// -shm file, "heap" keeps it in process memory so WAL works on filesystems that cannot
+// mmap a shared file (some container overlay and network filesystems).
+//
+// Heap mode sets locking_mode=EXCLUSIVE, which is how SQLite avoids the -shm file: the
+// single connection keeps the database locked from its first access until the pool is
+// closed, so no other process (or other *sql.DB) can read or write it meanwhile. The pool
+// is limited to one connection, and heap mode is not available with ModeReadOnly.
+func WithShmMode(mode string) Option {
+	return func(c *openConfig) error {
+		m := strings.ToLower(mode)
+		if m != "mmap" && m != "heap" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid shm mode %q (want mmap or heap)", mode))
+		}
+		if c.shmMode != "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("shm mode already specified"))
+		}
+		c.shmMode = m
+		return nil
+	}
+}
+
+// WithAdvisoryLock creates the _sqlitebp_locks table behind AcquireLock, and a free entry
+// for the lock name, when a write-capable handle is opened. Otherwise the first AcquireLock
+// of a name creates them, changing the schema (which invalidates the prepared statements
+// of other connections) while the database may be in use. May be given more than once.
+func WithAdvisoryLock(name string) Option {
+	return func(c *openConfig) error {
+		if name == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("lock name cannot be empty"))
+		}
+		if slices.Contains(c.advisoryLocks, name) {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("advisory lock %q already specified", name))
+		}
+		c.advisoryLocks = append(c.advisoryLocks, name)
+		return nil
+	}
+}
+
+// WithName tags the handle with a logical name, such as "billing" or a tenant ID, that
+// prefixes the errors of the open and of connections opened later (ErrOpenFailed,
+// ErrPingFailed, ErrPragmaExec and the like), so that logs of an application with many
+// databases tell which one failed.
+func WithName(name string) Option {
+	return func(c *openConfig) error {
+		if name == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("name cannot be empty"))
+		}
+		if c.name != "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("name already specified"))
+		}
+		c.name = name
+		return nil
+	}
+}
+
+// WithAuditHook calls fn once at the end of each Open, successful or not, with the file,
+// mode and settings of the open, e.g. to keep an audit trail of database access. fn runs
+// on the opening goroutine. Opens rejected because of an invalid option are not reported.
+func WithAuditHook(fn func(event OpenEvent)) Option {
+	return func(c *openConfig) error {
+		if fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("audit hook cannot be nil"))
+		}
+		if c.auditHook != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("audit hook already specified"))
+		}
+		c.auditHook = fn
+		return nil
+	}
+}
+
+// WithAuditIdentity sets the identity (e.g. a user or service name) that WithAuditHook
+// reports as OpenEvent.Identity.
+func WithAuditIdentity(identity string) Option {
+	return func(c *openConfig) error {
+		if identity == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("audit identity cannot be empty"))
+		}
+		if c.auditIdentity != "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("audit identity already specified"))
+		}
+		c.auditIdentity = identity
+		return nil
+	}
+}
+
+// WithMetadata stamps human-readable provenance (app name, version, creation time...)
+// into the database: write-capable opens store the pairs in a _sqlitebp_meta table,
+// created STRICT if needed, in a single transaction. Keys that are already stored keep
+// their original value, so the metadata describes how the database was created. Read-only
+// opens skip it. Read the pairs back with Metadata.
+func WithMetadata(kv map[string]string) Option {
+	return func(c *openConfig) error {
+		if len(kv) == 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("metadata cannot be empty"))
+		}
+		if c.metadata != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("metadata already specified"))
+		}
+		c.metadata = make(map[string]string, len(kv))
+		for k, v := range kv {
+			if k == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("metadata key cannot be empty"))
+			}
+			c.metadata[k] = v
+		}
+		return nil
+	}
+}
+
+// WithSchemaAssertion verifies at open time that table has exactly the expected columns,
+// comparing name, declared type (case-insensitively), NOT NULL and primary key position as
+// reported by PRAGMA table_info. Column order is not compared. Open fails with an error
+// wrapping ErrSchemaMismatch that lists every difference, so a binary deployed against a
+// database with a drifted schema fails fast instead of at the first affected query.
+func WithSchemaAssertion(table string, expected []ColumnSpec) Option {
+	return func(c *openConfig) error {
+		if table == "" || len(expected) == 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("schema assertion requires a table and at least one column"))
+		}
+		for _, col := range expected {
+			if col.Name == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("schema assertion for table %q has a column without a name", table))
+			}
+		}
+		for _, a := range c.schemaAssertions {
+			if strings.EqualFold(a.table, table) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("schema assertion for table %q already specified", table))
+			}
+		}
+		c.schemaAssertions = append(c.schemaAssertions, schemaAssertion{table: table, columns: slices.Clone(expected)})
+		return nil
+	}
+}
+
+// WithMigrations brings the schema up to date at open time. migrations[i] is the SQL that
+// moves the schema from version i to i+1, where the version is kept in PRAGMA
+// user_version (0 for a new database). The pending ones run in order in a single
+// transaction together with the user_version update, so a failure leaves the database
+// at its previous version and the open fails with an error wrapping ErrInitSQL.
+//
+// A database whose user_version is greater than len(migrations) was migrated by a newer
+// binary; the open then fails with ErrSchemaNewerThanBinary rather than letting old code
+// write to a schema it does not know. Read-only opens only perform this check. Migrations
+// must only ever be appended: editing or removing one already applied is not detected.
+// Migrations are not subject to a timeout of their own, so a long one (an index build on
+// a large table) completes unless the context of OpenContext ends first.
+func WithMigrations(migrations ...string) Option {
+	return func(c *openConfig) error {
+		if len(migrations) == 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("migrations cannot be empty"))
+		}
+		if c.migrations != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("migrations already specified"))
+		}
+		for i, m := range migrations {
+			if strings.TrimSpace(m) == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("migration %d is empty", i+1))
+			}
+		}
+		c.migrations = slices.Clone(migrations)
+		return nil
+	}
+}
+
+// WithSession records changes to the given tables (all tables if none are given) with
+// SQLite's session extension and calls sink with the changeset of each transaction
+// committed on any connection, e.g. to ship it to a replica or an audit log. Apply a
+// changeset elsewhere with ApplyChangeset. Only tables with a PRIMARY KEY are recorded.
+//
+// The session extension is not part of the default go-sqlite3 build. Build with
+// CGO_CFLAGS="-DSQLITE_ENABLE_SESSION -DSQLITE_ENABLE_PREUPDATE_HOOK" and the
+// sqlite_session build tag; otherwise opens fail with ErrFeatureUnavailable.
+// The session uses the connection's commit and rollback hooks, so those must not be
+// replaced (e.g. from WithConnectHook). sink runs on the committing goroutine after the
+// commit completes and must not use the database.
+func WithSession(tables []string, sink func(changeset []byte)) Option {
+	return func(c *openConfig) error {
+		if sink == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("session sink cannot be nil"))
+		}
+		if c.sessionSink != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("session already specified"))
+		}
+		for _, table := range tables {
+			if table == "" {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("session table name cannot be empty"))
+			}
+		}
+		c.requireFeature(FeatureSession)
+		c.sessionTables = slices.Clone(tables)
+		c.sessionSink = sink
+		return nil
+	}
+}
+
+// WithChangeNotifier sends the rows changed by each transaction committed on the handle
+// to ch, e.g. to invalidate cached query results. Update hook events are coalesced per
+// transaction into one ChangeBatch with a TableChange per table and kind of change, sent
+// after the commit; rolled back changes are dropped. ch must be buffered: a batch that does
+// not fit is discarded rather than stalling the writer, and counted in the Dropped field
+// of the next batch that is delivered.
+//
+// The notifier uses SQLite's update hook, which does not see changes to WITHOUT ROWID
+// tables, rows deleted by REPLACE conflict resolution, or a DELETE without a WHERE clause
+// that SQLite runs as a truncation (use "WHERE true" to report each row). It also uses the
+// commit, rollback and update hooks of each connection, so those must not be replaced
+// (e.g. from WithConnectHook).
+func WithChangeNotifier(ch chan<- ChangeBatch) Option {
+	return func(c *openConfig) error {
+		if ch == nil || cap(ch) == 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("change notifier channel must be buffered"))
+		}
+		if c.changeNotifier != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("change notifier already specified"))
+		}
+		c.changeNotifier = &changeNotifier{ch: ch}
+		return nil
+	}
+}
+
+// WithBusyDiagnostics calls sink whenever a statement fails with SQLITE_BUSY or
+// SQLITE_LOCKED, with the pool statistics and whether the lock appears to be held by
+// another connection of this handle or from outside it, to tell whether intermittent
+// busy errors call for serializing writes in-process or for retrying. The cause is a
+// heuristic: a connection of the handle that is merely reading is counted as a holder
+// although in WAL mode readers never block writers.
+//
+// sink runs on the failing statement's goroutine before the error is returned and must
+// not use the handle.
+func WithBusyDiagnostics(sink func(event BusyEvent)) Option {
+	return func(c *openConfig) error {
+		if sink == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("busy diagnostics sink must not be nil"))
+		}
+		if c.busyDiag != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("busy diagnostics already specified"))
+		}
+		c.busyDiag = &busyDiagnostics{sink: sink}
+		return nil
+	}
+}
+
+// WithAutoReconnect discards a pooled connection after it returns an I/O error
+// (SQLITE_IOERR, "disk I/O error"). On flaky volumes such an error can leave the
+// connection's file handle permanently broken; database/sql then opens a fresh
+// connection on next use instead of failing every query routed to the poisoned one.
+// The failing call still returns its error; statements are never retried.
+func WithAutoReconnect() Option {
+	return func(c *openConfig) error {
+		c.autoReconnect = true
+		return nil
+	}
+}
+
+// WithDefaultStatementTimeout bounds every statement run through the handle, including
+// Exec, Query and QueryRow calls without a context, to d, so that no single query can
+// hang forever. A caller's context with an earlier deadline still takes precedence. For
+// queries the timeout covers iterating the rows until they are closed. Statements that
+// exceed it fail with context.DeadlineExceeded; combine with WithInterruptOnCancel to
+// stop them promptly. Transactions as a whole are not bounded, only their statements.
+func WithDefaultStatementTimeout(d time.Duration) Option {
+	return func(c *openConfig) error {
+		if d <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement timeout must be > 0"))
+		}
+		if c.statementTimeout != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement timeout already specified"))
+		}
+		c.statementTimeout = d
+		return nil
+	}
+}
+
+// WithQueryPlanOnSlow reports the EXPLAIN QUERY PLAN output of statements that take at
+// least threshold, to help find missing indexes. For queries the time includes iterating
+// the rows until they are closed. sink receives the statement text and the plan as an
+// indented tree, one step per line (e.g. "SCAN events").
+//
+// The plan is produced with the statement's arguments on the connection that ran it, as
+// soon as the statement finishes, so temporary tables and attached databases resolve as
+// they did for the statement; sink is called synchronously before the connection is
+// released and should be quick. Only SELECT, INSERT, UPDATE, DELETE, REPLACE, VALUES and
+// WITH statements are explained; others (DDL, PRAGMA, transaction control) and
+// statements whose plan cannot be computed are skipped silently.
+func WithQueryPlanOnSlow(threshold time.Duration, sink func(query, plan string)) Option {
+	return func(c *openConfig) error {
+		if threshold <= 0 || sink == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("query plan logging requires threshold > 0 and a non-nil sink"))
+		}
+		if c.slowPlanSink != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("query plan logging already specified"))
+		}
+		c.slowPlanThreshold = threshold
+		c.slowPlanSink = sink
+		return nil
+	}
+}
+
+// WithStatementFilter calls fn with the SQL of every statement run through the handle
+// once it is open, before the statement is prepared, and fails the statement with
+// ErrStatementRejected if fn returns an error. It is meant for a handle that runs
+// untrusted queries, such as an ad-hoc query endpoint; see SelectOnlyFilter. The filter
+// also applies to this package's helpers that run SQL on the handle, so give untrusted
+// queries a handle of their own.
+func WithStatementFilter(fn func(query string) error) Option {
+	return func(c *openConfig) error {
+		if fn == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement filter cannot be nil"))
+		}
+		if c.statementFilter != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement filter already specified"))
+		}
+		c.statementFilter = fn
+		return nil
+	}
+}
+
+// WithStatementTimeout aborts any statement that runs longer than d with SQLITE_INTERRUPT
+// ("interrupted"), enforced inside SQLite by a per-connection progress handler that
+// compares the elapsed wall-clock time every 1000 virtual machine instructions (or every
+// ops of WithProgressHandler). For queries the time includes iterating the rows until
+// they are closed.
+//
+// Unlike WithDefaultStatementTimeout, which bounds the statement's context and reports
+// context.DeadlineExceeded, the limit cannot be extended by a caller's context and stops
+// the statement promptly without WithInterruptOnCancel. When statements are nested on one
+// connection, the one stepping is interrupted once any of them is over the limit.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(c *openConfig) error {
+		if d <= 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement timeout must be > 0"))
+		}
+		if c.stepTimeout != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement timeout already specified"))
+		}
+		c.stepTimeout = d
+		return nil
+	}
+}
+
+// WithConnectionLabels numbers each new connection of the handle 1, 2, 3, ... to help
+// correlate activity (e.g. "database is locked") across pooled connections. The label is
+// available from ConnectionLabel, from SQL as sqlitebp_conn_id() (e.g. to include it in
+// application logs or triggers), and prefixes the message of every SQLite error returned
+// by the connection ("sqlitebp conn 3: database is locked"). The original error remains
+// available to errors.As.
+func WithConnectionLabels() Option {
+	return func(c *openConfig) error {
+		if c.connLabels == nil {
+			c.connLabels = new(atomic.Uint64)
+		}
+		return nil
+	}
+}
+
+// poolEvents holds the WithPoolEvents callbacks.
+type poolEvents struct {
+	onConnect, onReset, onClose func(connID int)
+	lastID                      atomic.Uint64 // last assigned id without WithConnectionLabels
+}
+
+// WithPoolEvents calls onConnect when the pool opens a new connection, onReset when
+// database/sql resets a pooled connection before handing it out again, and onClose when a
+// connection is closed, each with the connection's id (its label with
+// WithConnectionLabels, otherwise numbered 1, 2, 3, ... per handle). Any callback may be
+// nil. Callbacks run synchronously on the goroutine using the pool and must be quick.
+func WithPoolEvents(onConnect, onReset, onClose func(connID int)) Option {
+	return func(c *openConfig) error {
+		if onConnect == nil && onReset == nil && onClose == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("pool events require at least one callback"))
+		}
+		if c.poolEvents != nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("pool events already specified"))
+		}
+		c.poolEvents = &poolEvents{onConnect: onConnect, onReset: onReset, onClose: onClose}
+		return nil
+	}
+}
+
+// WithValidationQuery runs query after the initial ping and discards its results, failing
+// the open with ErrPingFailed if it errors. Ping alone succeeds on any file SQLite can
+// open, including an empty database created by a typo in the path (with
+// ModeReadWriteCreate) or the wrong file; a query such as
+// "SELECT COUNT(*) FROM expected_table" catches that at startup.
+func WithValidationQuery(query string) Option {
+	return func(c *openConfig) error {
+		if strings.TrimSpace(query) == "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("validation query cannot be empty"))
+		}
+		if c.validationQuery != "" {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("validation query already specified"))
+		}
+		c.validationQuery = query
+		return nil
+	}
+}
+
+// WithMinimumSQLiteVersion fails the open with ErrFeatureUnavailable if the linked SQLite
+// library is older than major.minor.patch, so code relying on newer SQL features fails at
+// startup rather than at the first query that uses them.
+func WithMinimumSQLiteVersion(major, minor, patch int) Option {
+	return func(c *openConfig) error {
+		if major < 3 || minor < 0 || minor > 999 || patch < 0 || patch > 999 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("invalid SQLite version %d.%d.%d", major, minor, patch))
+		}
+		if c.minVersion != 0 {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("minimum SQLite version already specified"))
+		}
+		c.minVersion = major*1000000 + minor*1000 + patch
+		return nil
+	}
+}
+
+// WithWarmup opens the full pool (the WithMaxOpenConns or default size) during the open
+// instead of lazily, so the first queries after a cold start do not each pay for opening
+// a connection and running its per-connection setup. Connections may still be closed
+// later by WithConnMaxIdleTime or WithConnMaxLifetime.
+func WithWarmup() Option {
+	return func(c *openConfig) error {
+		c.warmup = true
+		return nil
+	}
+}
+
+// unionCommonTables adds a union view for each table present in every attached database,
+// named after the table, see OpenDateRange.
+func unionCommonTables() Option {
+	return func(c *openConfig) error {
+		c.unionCommon = true
+		return nil
+	}
+}
+
+// inMemory stores the database with the memdb VFS, see OpenSharedMemory.
+func inMemory() Option {
+	return func(c *openConfig) error {
+		c.memory = true
+		return nil
+	}
+}
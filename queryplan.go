@@ -0,0 +1,71 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// explainableKeywords are the statement types EXPLAIN QUERY PLAN describes.
+var explainableKeywords = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "VALUES", "WITH"}
+
+// observeSlow delivers the query plan of a statement started at start to the
+// WithQueryPlanOnSlow sink if it ran for at least the threshold.
+func (c *sqliteConn) observeSlow(query string, args []driver.NamedValue, start time.Time) {
+	if c.cfg.slowPlanSink == nil || time.Since(start) < c.cfg.slowPlanThreshold || !explainable(query) {
+		return
+	}
+	plan, err := c.queryPlan(query, args)
+	if err != nil || plan == "" {
+		return
+	}
+	c.cfg.slowPlanSink(query, plan)
+}
+
+// explainable reports whether query starts with a keyword EXPLAIN QUERY PLAN applies to.
+func explainable(query string) bool {
+	q := strings.ToUpper(strings.TrimLeft(query, " \t\r\n("))
+	for _, kw := range explainableKeywords {
+		if strings.HasPrefix(q, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPlan runs EXPLAIN QUERY PLAN for query and renders the steps as a tree, indenting
+// each step two spaces per level below the top.
+func (c *sqliteConn) queryPlan(query string, args []driver.NamedValue) (string, error) {
+	rows, err := c.SQLiteConn.QueryContext(context.Background(), "EXPLAIN QUERY PLAN "+query, args)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	// Columns are id, parent, notused and detail; parents precede their children.
+	depth := map[int64]int{0: -1}
+	var b strings.Builder
+	row := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(row); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		id, _ := row[0].(int64)
+		parent, _ := row[1].(int64)
+		d := depth[parent] + 1
+		depth[id] = d
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", d), planDetail(row[3]))
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func planDetail(v driver.Value) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,67 @@
+package sqlitebp
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithQueryPlanOnSlow(t *testing.T) {
+	type report struct{ query, plan string }
+	var (
+		mu      sync.Mutex
+		reports []report
+	)
+	sink := func(query, plan string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, report{query, plan})
+	}
+	// pause sleeps when it sees id 1, making any statement that evaluates it slow.
+	pause := func(id int64) bool {
+		if id == 1 {
+			time.Sleep(30 * time.Millisecond)
+		}
+		return true
+	}
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "test.db"),
+		WithQueryPlanOnSlow(20*time.Millisecond, sink), WithFunc("pause", pause, false))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE events (id INTEGER PRIMARY KEY, kind TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+		INSERT INTO events SELECT i, 'k' || (i % 10) FROM n`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	// Fast statements are not reported.
+	var kind string
+	if err := db.QueryRow("SELECT kind FROM events WHERE id = ?", 5).Scan(&kind); err != nil {
+		t.Fatalf("fast query: %v", err)
+	}
+	// A slow full table scan is.
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM events WHERE kind = ? AND pause(id)", "k1").Scan(&n); err != nil {
+		t.Fatalf("slow query: %v", err)
+	}
+	// Statements EXPLAIN does not describe are skipped even when slow.
+	if _, err := db.Exec("CREATE TABLE other AS SELECT * FROM events WHERE pause(id)"); err != nil {
+		t.Fatalf("slow ddl: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d: %+v", len(reports), reports)
+	}
+	if !strings.HasPrefix(reports[0].query, "SELECT COUNT(*) FROM events") {
+		t.Fatalf("unexpected query %q", reports[0].query)
+	}
+	if !strings.Contains(reports[0].plan, "SCAN events") {
+		t.Fatalf("expected a full scan in the plan, got %q", reports[0].plan)
+	}
+}
@@ -0,0 +1,73 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ReaderPool spreads read-only queries over several independent read-only handles on the
+// same file, in turn. Each connection of a handle already has its own file descriptor and
+// reads in parallel with the others, so a single handle with WithMaxOpenConns suffices
+// for most analytics workloads; with many short queries from many goroutines, though,
+// the lock that database/sql holds on each handle while checking connections in and out
+// becomes contended, and several handles split it.
+type ReaderPool struct {
+	handles []*sql.DB
+	next    atomic.Uint64
+}
+
+// OpenReaderPool opens n read-only handles on filename, each with opts; WithMaxOpenConns
+// sets the size of each handle's pool, not the total.
+func OpenReaderPool(filename string, n int, opts ...Option) (*ReaderPool, error) {
+	if n < 1 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("reader pool needs at least 1 handle"))
+	}
+	p := &ReaderPool{handles: make([]*sql.DB, 0, n)}
+	for i := 0; i < n; i++ {
+		db, err := OpenReadOnly(filename, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.handles = append(p.handles, db)
+	}
+	return p, nil
+}
+
+// Reader returns the handle the next query should use. Use it for Conn or BeginTx, which
+// must stay on one handle.
+func (p *ReaderPool) Reader() *sql.DB {
+	return p.handles[(p.next.Add(1)-1)%uint64(len(p.handles))]
+}
+
+// Query runs a query on the next handle.
+func (p *ReaderPool) Query(query string, args ...any) (*sql.Rows, error) {
+	return p.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext runs a query on the next handle.
+func (p *ReaderPool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.Reader().QueryContext(ctx, query, args...)
+}
+
+// QueryRow is Query for a single row.
+func (p *ReaderPool) QueryRow(query string, args ...any) *sql.Row {
+	return p.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext is QueryContext for a single row.
+func (p *ReaderPool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return p.Reader().QueryRowContext(ctx, query, args...)
+}
+
+// Close closes all handles.
+func (p *ReaderPool) Close() error {
+	var err error
+	for _, db := range p.handles {
+		err = errors.Join(err, db.Close())
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package sqlitebp
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReaderPool(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "analytics.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE facts (v INTEGER); WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000) INSERT INTO facts SELECT i FROM n"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	db.Close()
+
+	// Each scan waits in barrier until all of them are running at once, which takes more
+	// connections than a single handle of the pool has.
+	const handles, perHandle = 4, 2
+	const scans = handles * perHandle
+	var running, peak atomic.Int32
+	barrier := func() int64 {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			if m := peak.Load(); n <= m || peak.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		for deadline := time.Now().Add(5 * time.Second); peak.Load() < scans && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		return 1
+	}
+	p, err := OpenReaderPool(fn, handles, WithMaxOpenConns(perHandle), WithFunc("barrier", barrier, false))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, scans)
+	for i := 0; i < scans; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sum int64
+			if err := p.QueryRow("SELECT sum(v) * barrier() FROM facts").Scan(&sum); err != nil {
+				errs <- err
+			} else if sum != 500500 {
+				errs <- errors.New("wrong sum")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("scan: %v", err)
+	}
+	if n := peak.Load(); n != scans {
+		t.Fatalf("at most %d scans ran at once, want %d", n, scans)
+	}
+
+	if _, err := OpenReaderPool(fn, 0); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
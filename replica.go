@@ -0,0 +1,216 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPool pairs a read/write handle on a primary database with a read-only handle on
+// a snapshot of it that is refreshed periodically. Readers never contend with writers on
+// the live file, at the cost of seeing data up to one refresh interval old.
+//
+// Snapshots are produced with VacuumInto next to the primary (primary + ".snapshot-N")
+// and swapped in atomically. The previous snapshot stays open for at least one refresh
+// interval, so that queries on a handle just returned by Reader still succeed, and is
+// then closed and removed.
+type ReplicaPool struct {
+	primary string
+	opts    []Option
+	writer  *sql.DB
+	reader  atomic.Pointer[sql.DB]
+	grace   time.Duration // how long replaced snapshots stay open
+
+	mu       sync.Mutex // serializes refreshes
+	snapshot string     // current snapshot file
+	seq      int
+	retired  []retiredSnapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// retiredSnapshot is a replaced snapshot, closed once the grace period has passed.
+type retiredSnapshot struct {
+	db   *sql.DB
+	file string
+	at   time.Time
+}
+
+// OpenReplicated opens primary read/write (creating it if needed) plus a read-only snapshot,
+// refreshed every snapshotInterval. opts apply to both handles.
+func OpenReplicated(primary string, snapshotInterval time.Duration, opts ...Option) (*ReplicaPool, error) {
+	if snapshotInterval <= 0 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("snapshot interval must be > 0"))
+	}
+	writer, err := OpenReadWriteCreate(primary, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p := &ReplicaPool{
+		primary: primary,
+		opts:    opts,
+		writer:  writer,
+		grace:   snapshotInterval,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := p.Refresh(context.Background()); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	go p.refreshLoop(snapshotInterval)
+	return p, nil
+}
+
+// Writer returns the read/write handle on the primary.
+func (p *ReplicaPool) Writer() *sql.DB {
+	return p.writer
+}
+
+// Reader returns the read-only handle on the current snapshot. Call it for each query
+// rather than holding on to the result: the handle is closed one to two refresh intervals
+// after a refresh replaces it.
+func (p *ReplicaPool) Reader() *sql.DB {
+	return p.reader.Load()
+}
+
+// Refresh takes a new snapshot of the primary and swaps it in immediately. Snapshots
+// replaced at least one refresh interval ago are closed and removed.
+func (p *ReplicaPool) Refresh(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	next := fmt.Sprintf("%s.snapshot-%d", p.primary, p.seq)
+	removeDatabaseFiles(next)
+	if err := VacuumInto(ctx, p.writer, next); err != nil {
+		removeDatabaseFiles(next)
+		return err
+	}
+	reader, err := OpenReadOnly(next, p.opts...)
+	if err != nil {
+		removeDatabaseFiles(next)
+		return err
+	}
+	old := p.reader.Swap(reader)
+	previous := p.snapshot
+	p.snapshot = next
+	kept := p.retired[:0]
+	for _, r := range p.retired {
+		if time.Since(r.at) < p.grace {
+			kept = append(kept, r)
+			continue
+		}
+		r.db.Close()
+		removeDatabaseFiles(r.file)
+	}
+	p.retired = kept
+	if old != nil {
+		p.retired = append(p.retired, retiredSnapshot{db: old, file: previous, at: time.Now()})
+	}
+	return nil
+}
+
+func (p *ReplicaPool) refreshLoop(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			// A failed refresh keeps serving the previous snapshot; the next tick retries.
+			p.Refresh(context.Background())
+		}
+	}
+}
+
+// Close stops refreshing, closes both handles and any replaced snapshots still open, and
+// removes the snapshot files.
+func (p *ReplicaPool) Close() error {
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.writer.Close()
+	for _, r := range p.retired {
+		err = errors.Join(err, r.db.Close())
+		removeDatabaseFiles(r.file)
+	}
+	p.retired = nil
+	if reader := p.reader.Load(); reader != nil {
+		err = errors.Join(err, reader.Close())
+		removeDatabaseFiles(p.snapshot)
+	}
+	return err
+}
+
+// removeDatabaseFiles removes a database file and its WAL companions, ignoring missing files.
+func removeDatabaseFiles(filename string) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		os.Remove(filename + suffix)
+	}
+}
+
+// staleReadsKey is the context key set by WithStaleReads.
+type staleReadsKey struct{}
+
+// WithStaleReads returns a copy of ctx that allows RoutingDB to serve queries made with
+// it from the snapshot, which may be up to one refresh interval behind the primary.
+func WithStaleReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleReadsKey{}, true)
+}
+
+// staleReadsAllowed reports whether ctx was marked with WithStaleReads.
+func staleReadsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(staleReadsKey{}).(bool)
+	return allowed
+}
+
+// RoutingDB dispatches the queries of a ReplicaPool: reads whose context was marked with
+// WithStaleReads go to the snapshot, everything else, including all writes and
+// transactions, goes to the primary so it sees the latest committed data.
+// Each query looks up the current snapshot, so it is unaffected by concurrent refreshes.
+type RoutingDB struct {
+	pool *ReplicaPool
+}
+
+// NewRoutingDB returns a RoutingDB over p.
+func NewRoutingDB(p *ReplicaPool) *RoutingDB {
+	return &RoutingDB{pool: p}
+}
+
+// db returns the handle a query with ctx should use.
+func (r *RoutingDB) db(ctx context.Context) *sql.DB {
+	if staleReadsAllowed(ctx) {
+		return r.pool.Reader()
+	}
+	return r.pool.Writer()
+}
+
+// QueryContext runs a query on the snapshot if ctx allows stale reads, else on the primary.
+func (r *RoutingDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.db(ctx).QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext is QueryContext for a single row.
+func (r *RoutingDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.db(ctx).QueryRowContext(ctx, query, args...)
+}
+
+// ExecContext always runs on the primary.
+func (r *RoutingDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.pool.Writer().ExecContext(ctx, query, args...)
+}
+
+// BeginTx always starts the transaction on the primary.
+func (r *RoutingDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.pool.Writer().BeginTx(ctx, opts)
+}
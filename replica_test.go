@@ -0,0 +1,154 @@
+package sqlitebp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaPool_ReaderSeesWritesAfterRefresh(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "primary.db")
+	pool, err := OpenReplicated(fn, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.Writer().Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Writer().Exec("INSERT INTO test (value) VALUES (?)", "v"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		err := pool.Reader().QueryRow("SELECT COUNT(*) FROM test").Scan(&n)
+		// The first snapshot predates the table.
+		if err != nil && !strings.Contains(err.Error(), "no such table") {
+			t.Fatalf("query: %v", err)
+		}
+		if err == nil && n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reader never saw rows: n=%d err=%v", n, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := pool.Reader().Exec("INSERT INTO test (value) VALUES ('x')"); err == nil {
+		t.Errorf("expected reader to be read-only")
+	}
+}
+
+func TestReplicaPool_RefreshKeepsReplacedReaderOpen(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "primary.db")
+	pool, err := OpenReplicated(fn, time.Hour)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	ctx := context.Background()
+	reader := pool.Reader()
+	for i := 0; i < 2; i++ {
+		if err := pool.Refresh(ctx); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	}
+	// Obtained before both refreshes, but within the grace period.
+	var n int
+	if err := reader.QueryRow("SELECT count(*) FROM sqlite_schema").Scan(&n); err != nil {
+		t.Fatalf("query on replaced reader: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if matches, _ := filepath.Glob(fn + ".snapshot-*"); len(matches) != 0 {
+		t.Errorf("snapshot files left behind: %v", matches)
+	}
+}
+
+func TestReplicaPool_CloseRemovesSnapshots(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "primary.db")
+	pool, err := OpenReplicated(fn, time.Hour)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	matches, _ := filepath.Glob(fn + ".snapshot-*")
+	if len(matches) != 0 {
+		t.Errorf("snapshot files left behind: %v", matches)
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Errorf("primary missing: %v", err)
+	}
+}
+
+func TestRoutingDB_StaleReads(t *testing.T) {
+	pool, err := OpenReplicated(filepath.Join(t.TempDir(), "primary.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer pool.Close()
+	db := NewRoutingDB(pool)
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT; INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err := pool.Refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	count := func(ctx context.Context) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM test").Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	if n := count(ctx); n != 2 {
+		t.Errorf("default read saw %d rows, want the latest 2", n)
+	}
+	if n := count(WithStaleReads(ctx)); n != 1 {
+		t.Errorf("stale read saw %d rows, want the snapshot's 1", n)
+	}
+	rows, err := db.QueryContext(WithStaleReads(ctx), "SELECT id FROM test")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	rows.Close()
+}
+
+func TestRoutingDB_StaleReadsDuringRefresh(t *testing.T) {
+	pool, err := OpenReplicated(filepath.Join(t.TempDir(), "primary.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer pool.Close()
+	db := NewRoutingDB(pool)
+	ctx := WithStaleReads(context.Background())
+
+	// A query that looked up the snapshot just before a refresh replaced it.
+	handle := db.db(ctx)
+	if err := pool.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	var n int
+	if err := handle.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_schema").Scan(&n); err != nil {
+		t.Fatalf("stale read during refresh: %v", err)
+	}
+}
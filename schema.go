@@ -0,0 +1,362 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ColumnSpec describes a column as reported by PRAGMA table_info, see WithSchemaAssertion.
+type ColumnSpec struct {
+	Name    string
+	Type    string // declared type, e.g. "INTEGER" or "TEXT"; compared case-insensitively
+	NotNull bool
+	PK      int // 1-based position in the primary key, 0 if not part of it
+}
+
+// schemaAssertion is a table and the columns it is expected to have.
+type schemaAssertion struct {
+	table   string
+	columns []ColumnSpec
+}
+
+// check compares the live schema of a.table with the expected columns.
+func (a schemaAssertion) check(ctx context.Context, db *sql.DB) error {
+	actual, err := tableColumns(ctx, db, a.table)
+	if err != nil {
+		return errors.Join(ErrSchemaMismatch, fmt.Errorf("failed to read schema of table %q: %w", a.table, err))
+	}
+	if len(actual) == 0 {
+		return errors.Join(ErrSchemaMismatch, fmt.Errorf("table %q does not exist", a.table))
+	}
+	var problems []string
+	for _, want := range a.columns {
+		got, ok := actual[strings.ToLower(want.Name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing column %q", want.Name))
+			continue
+		}
+		delete(actual, strings.ToLower(want.Name))
+		if !strings.EqualFold(got.Type, want.Type) {
+			problems = append(problems, fmt.Sprintf("column %q has type %q, want %q", want.Name, got.Type, want.Type))
+		}
+		if got.NotNull != want.NotNull {
+			problems = append(problems, fmt.Sprintf("column %q has notnull=%t, want %t", want.Name, got.NotNull, want.NotNull))
+		}
+		if got.PK != want.PK {
+			problems = append(problems, fmt.Sprintf("column %q has pk=%d, want %d", want.Name, got.PK, want.PK))
+		}
+	}
+	for _, got := range actual {
+		problems = append(problems, fmt.Sprintf("unexpected column %q", got.Name))
+	}
+	if len(problems) > 0 {
+		return errors.Join(ErrSchemaMismatch, fmt.Errorf("table %q: %s", a.table, strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table keyed by lower-cased name.
+// A missing table yields an empty map.
+func tableColumns(ctx context.Context, db *sql.DB, table string) (map[string]ColumnSpec, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]ColumnSpec)
+	for rows.Next() {
+		var col ColumnSpec
+		if err := rows.Scan(&col.Name, &col.Type, &col.NotNull, &col.PK); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(col.Name)] = col
+	}
+	return columns, rows.Err()
+}
+
+// SchemaObjects returns the names of the tables, indexes, triggers and views in db's main
+// schema, each sorted by name. SQLite's internal objects (sqlite_schema, sqlite_sequence,
+// sqlite_stat1, automatic indexes and the like, all named sqlite_*) are excluded.
+func SchemaObjects(ctx context.Context, db *sql.DB) (tables, indexes, triggers, views []string, err error) {
+	rows, err := db.QueryContext(ctx, "SELECT type, name FROM sqlite_schema WHERE substr(name, 1, 7) != 'sqlite_' ORDER BY name")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ, name string
+		if err := rows.Scan(&typ, &name); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		switch typ {
+		case "table":
+			tables = append(tables, name)
+		case "index":
+			indexes = append(indexes, name)
+		case "trigger":
+			triggers = append(triggers, name)
+		case "view":
+			views = append(views, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return tables, indexes, triggers, views, nil
+}
+
+// TableHasRowID reports whether table in db's main schema has a rowid, i.e. is neither a
+// WITHOUT ROWID table nor a view. Rowid-based APIs such as OpenBlob cannot be used on
+// tables without one.
+func TableHasRowID(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	return hasRowID(ctx, db, "main", table)
+}
+
+// hasRowID is TableHasRowID for table in schema, on db or a single connection.
+func hasRowID(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, schema, table string) (bool, error) {
+	var typ string
+	var withoutRowID bool
+	err := db.QueryRowContext(ctx, "SELECT type, wr FROM pragma_table_list WHERE schema = ? AND name = ?", schema, table).Scan(&typ, &withoutRowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("sqlitebp: no such table: %s.%s", schema, table)
+	}
+	if err != nil {
+		return false, err
+	}
+	return typ != "view" && !withoutRowID, nil
+}
+
+// DescribeFKError explains a "FOREIGN KEY constraint failed" error, which SQLite reports
+// without naming the constraint. It runs PRAGMA foreign_key_check on db and describes each
+// violating row (up to ten) by child table, rowid, parent table and columns. It returns ""
+// and a nil error when err is not a foreign key violation.
+//
+// Only violations that still exist can be found. Pass the transaction or connection the
+// error came from: deferred constraints are checked at COMMIT, so run it before committing,
+// and an immediate constraint rolls back the failing statement, leaving only rows written
+// while enforcement was off. When nothing is found the description says so.
+func DescribeFKError(ctx context.Context, db interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}, err error) (string, error) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintForeignKey {
+		return "", nil
+	}
+	rows, qerr := db.QueryContext(ctx, `
+		SELECT c."table", c.rowid, c.parent, group_concat(f."from", ', '), group_concat(coalesce(f."to", 'rowid'), ', ')
+		FROM pragma_foreign_key_check AS c
+		JOIN pragma_foreign_key_list(c."table") AS f ON f.id = c.fkid
+		GROUP BY c."table", c.rowid, c.parent, c.fkid
+		LIMIT 10`)
+	if qerr != nil {
+		return "", qerr
+	}
+	defer rows.Close()
+	var violations []string
+	for rows.Next() {
+		var table, parent, from, to string
+		var rowid sql.NullInt64
+		if err := rows.Scan(&table, &rowid, &parent, &from, &to); err != nil {
+			return "", err
+		}
+		row := "row"
+		if rowid.Valid {
+			row = fmt.Sprintf("rowid %d", rowid.Int64)
+		}
+		violations = append(violations, fmt.Sprintf("%q %s (%s) references a missing row in %q (%s)", table, row, from, parent, to))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(violations) == 0 {
+		return fmt.Sprintf("%v: no violating rows found (the failing statement was rolled back)", err), nil
+	}
+	return fmt.Sprintf("%v: %s", err, strings.Join(violations, "; ")), nil
+}
+
+// ColumnInfo describes a column of a table or view as declared in the schema, see
+// ColumnMetadata.
+type ColumnInfo struct {
+	Name      string
+	Type      string // declared type as written, "" if none
+	NotNull   bool
+	Default   string // default value expression as written, e.g. "'x'" or "CURRENT_TIMESTAMP"; "" if none
+	PK        int    // 1-based position in the primary key, 0 if not part of it
+	Collation string // declared collating sequence, e.g. "NOCASE"; "BINARY" if none, "" for views
+}
+
+// ColumnMetadata returns the declared columns of table in db's main schema, in order.
+// Unlike sql.ColumnType, which reports the type of each value SQLite returns, it reports
+// what the schema says: type, NOT NULL, default, primary key position and collation.
+//
+// PRAGMA table_info does not report collations, so they are read from the COLLATE clause
+// of the column's definition in the stored CREATE TABLE statement. Collations an index
+// declares for its own columns are not column collations and are not reported.
+func ColumnMetadata(ctx context.Context, db *sql.DB, table string) ([]ColumnInfo, error) {
+	var typ, createSQL string
+	err := db.QueryRowContext(ctx, "SELECT type, coalesce(sql, '') FROM sqlite_schema WHERE name = ? AND type IN ('table', 'view')", table).Scan(&typ, &createSQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("sqlitebp: no such table: main.%s", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var collations map[string]string
+	if typ == "table" {
+		collations = declaredCollations(createSQL)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT name, type, "notnull", coalesce(dflt_value, ''), pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []ColumnInfo
+	for rows.Next() {
+		var col ColumnInfo
+		if err := rows.Scan(&col.Name, &col.Type, &col.NotNull, &col.Default, &col.PK); err != nil {
+			return nil, err
+		}
+		if typ == "table" {
+			col.Collation = "BINARY"
+			if coll, ok := collations[strings.ToLower(col.Name)]; ok {
+				col.Collation = coll
+			}
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// declaredCollations returns the COLLATE clause of each column definition in the CREATE
+// TABLE statement createSQL, keyed by lower-cased column name. Collation names are
+// upper-cased as SQLite matches them case-insensitively.
+func declaredCollations(createSQL string) map[string]string {
+	tokens := sqlTokens(createSQL)
+	start := 0
+	for start < len(tokens) && (tokens[start].quoted || tokens[start].text != "(") {
+		start++
+	}
+	collations := make(map[string]string)
+	depth := 0
+	var def []sqlToken
+	for _, tok := range tokens[start:] {
+		switch {
+		case tok.text == "(" && !tok.quoted:
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case tok.text == ")" && !tok.quoted:
+			depth--
+		}
+		if depth == 0 || (depth == 1 && tok.text == "," && !tok.quoted) {
+			columnCollation(def, collations)
+			def = def[:0]
+			if depth == 0 {
+				break
+			}
+			continue
+		}
+		def = append(def, tok)
+	}
+	return collations
+}
+
+// columnCollation records the COLLATE clause of the column definition def, if any. Table
+// constraints are skipped.
+func columnCollation(def []sqlToken, collations map[string]string) {
+	if len(def) == 0 {
+		return
+	}
+	if !def[0].quoted {
+		switch strings.ToUpper(def[0].text) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			return
+		}
+	}
+	for i := 1; i+1 < len(def); i++ {
+		if !def[i].quoted && strings.EqualFold(def[i].text, "COLLATE") {
+			collations[strings.ToLower(def[0].text)] = strings.ToUpper(def[i+1].text)
+			return
+		}
+	}
+}
+
+// sqlToken is a word, quoted identifier or string, or punctuation character of an SQL
+// statement. Quoted tokens hold their unquoted text.
+type sqlToken struct {
+	text   string
+	quoted bool
+}
+
+// sqlTokens splits s into tokens, dropping whitespace and comments.
+func sqlTokens(s string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '"' || c == '\'' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			var b strings.Builder
+			j := i + 1
+			for j < len(s) {
+				if s[j] == closing {
+					// A doubled quote is an escaped quote, except in [brackets].
+					if c != '[' && j+1 < len(s) && s[j+1] == closing {
+						b.WriteByte(closing)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: b.String(), quoted: true})
+			i = j + 1
+		case c == '_' || c == '$' || c >= 0x80 || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+			j := i + 1
+			for j < len(s) {
+				d := s[j]
+				if !(d == '_' || d == '$' || d >= 0x80 || ('0' <= d && d <= '9') || ('a' <= d && d <= 'z') || ('A' <= d && d <= 'Z')) {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: s[i:j]})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{text: s[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
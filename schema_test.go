@@ -0,0 +1,230 @@
+package sqlitebp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithSchemaAssertion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "schema.db")
+	db, err := OpenReadWriteCreate(filename)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, name TEXT)"); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	db.Close()
+
+	users := []ColumnSpec{
+		{Name: "id", Type: "INTEGER", PK: 1},
+		{Name: "email", Type: "text", NotNull: true},
+		{Name: "name", Type: "TEXT"},
+	}
+	db, err = OpenReadWrite(filename, WithSchemaAssertion("users", users))
+	if err != nil {
+		t.Fatalf("matching schema: %v", err)
+	}
+	db.Close()
+
+	drifted := []ColumnSpec{
+		{Name: "id", Type: "INTEGER", PK: 1},
+		{Name: "email", Type: "TEXT"},
+		{Name: "created_at", Type: "INTEGER"},
+	}
+	_, err = OpenReadWrite(filename, WithSchemaAssertion("users", drifted))
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected ErrSchemaMismatch, got %v", err)
+	}
+	for _, want := range []string{`"email" has notnull=true`, `missing column "created_at"`, `unexpected column "name"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	if _, err := OpenReadWrite(filename, WithSchemaAssertion("accounts", users)); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected ErrSchemaMismatch for missing table, got %v", err)
+	}
+}
+
+func TestSchemaObjects(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "objects.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT UNIQUE);
+		CREATE TABLE audit (user_id INTEGER, at TEXT);
+		CREATE INDEX audit_user ON audit (user_id);
+		CREATE TRIGGER users_audit AFTER INSERT ON users BEGIN
+			INSERT INTO audit VALUES (new.id, datetime());
+		END;
+		CREATE VIEW active_users AS SELECT * FROM users;
+		INSERT INTO users (email) VALUES ('a@example.com');
+		ANALYZE`); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	tables, indexes, triggers, views, err := SchemaObjects(context.Background(), db)
+	if err != nil {
+		t.Fatalf("schema objects: %v", err)
+	}
+	// sqlite_sequence, sqlite_stat1 and the automatic index for UNIQUE are internal.
+	for _, c := range []struct {
+		kind      string
+		got, want []string
+	}{
+		{"tables", tables, []string{"audit", "users"}},
+		{"indexes", indexes, []string{"audit_user"}},
+		{"triggers", triggers, []string{"users_audit"}},
+		{"views", views, []string{"active_users"}},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %v, want %v", c.kind, c.got, c.want)
+		}
+	}
+}
+
+func TestTableHasRowID(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "rowid.db"), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE files (id INTEGER PRIMARY KEY, data BLOB);
+		CREATE TABLE kv (k TEXT PRIMARY KEY, v BLOB) WITHOUT ROWID;
+		CREATE VIEW file_ids AS SELECT id FROM files;
+		INSERT INTO files VALUES (1, zeroblob(10));
+		INSERT INTO kv VALUES ('a', zeroblob(10))`); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	for table, want := range map[string]bool{"files": true, "kv": false, "file_ids": false} {
+		got, err := TableHasRowID(ctx, db, table)
+		if err != nil || got != want {
+			t.Errorf("TableHasRowID(%q) = %v, %v; want %v", table, got, err, want)
+		}
+	}
+	if _, err := TableHasRowID(ctx, db, "missing"); err == nil {
+		t.Errorf("expected an error for a missing table")
+	}
+
+	blob, err := OpenBlob(ctx, db, "main", "files", "data", 1, false)
+	if err != nil {
+		t.Fatalf("blob on rowid table: %v", err)
+	}
+	blob.Close()
+	if _, err := OpenBlob(ctx, db, "main", "kv", "v", 1, false); !errors.Is(err, ErrWithoutRowID) {
+		t.Fatalf("expected ErrWithoutRowID, got %v", err)
+	}
+	// The connection was returned to the single-connection pool.
+	if _, err := TableHasRowID(ctx, db, "files"); err != nil {
+		t.Fatalf("pool exhausted: %v", err)
+	}
+}
+
+func TestDescribeFKError(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "fk.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.Exec(`
+		CREATE TABLE authors (id INTEGER PRIMARY KEY) STRICT;
+		CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER REFERENCES authors (id)) STRICT`); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+
+	// An immediate violation rolls back the statement, leaving nothing to find.
+	_, fkErr := db.Exec("INSERT INTO books (author_id) VALUES (1)")
+	if fkErr == nil {
+		t.Fatalf("expected a foreign key violation")
+	}
+	desc, err := DescribeFKError(ctx, db, fkErr)
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	if !strings.Contains(desc, "no violating rows found") {
+		t.Errorf("description %q, want a note that no rows were found", desc)
+	}
+
+	// An orphan written while enforcement was off is named.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF; INSERT INTO books (id, author_id) VALUES (7, 42); PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("orphan: %v", err)
+	}
+	if _, fkErr = conn.ExecContext(ctx, "INSERT INTO books (author_id) VALUES (43)"); fkErr == nil {
+		t.Fatalf("expected a foreign key violation")
+	}
+	desc, err = DescribeFKError(ctx, conn, fkErr)
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	if want := `"books" rowid 7 (author_id) references a missing row in "authors" (id)`; !strings.Contains(desc, want) {
+		t.Errorf("description %q does not contain %q", desc, want)
+	}
+
+	_, otherErr := db.Exec("INSERT INTO missing VALUES (1)")
+	if desc, err := DescribeFKError(ctx, db, otherErr); desc != "" || err != nil {
+		t.Errorf("non-FK error described as (%q, %v), want empty", desc, err)
+	}
+}
+
+func TestColumnMetadata(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "columns.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE "order items" (
+			order_id INTEGER NOT NULL REFERENCES orders (id), -- first key column
+			"line, no" INTEGER NOT NULL,
+			sku TEXT COLLATE nocase NOT NULL CHECK (sku != ''),
+			note TEXT /* free text, COLLATE ignored here */ DEFAULT 'none',
+			[created at] TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP COLLATE "RTRIM",
+			qty,
+			PRIMARY KEY (order_id, "line, no")
+		);
+		CREATE INDEX order_items_note ON "order items" (note COLLATE NOCASE)`); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+
+	got, err := ColumnMetadata(ctx, db, "order items")
+	if err != nil {
+		t.Fatalf("ColumnMetadata: %v", err)
+	}
+	want := []ColumnInfo{
+		{Name: "order_id", Type: "INTEGER", NotNull: true, PK: 1, Collation: "BINARY"},
+		{Name: "line, no", Type: "INTEGER", NotNull: true, PK: 2, Collation: "BINARY"},
+		{Name: "sku", Type: "TEXT", NotNull: true, Collation: "NOCASE"},
+		{Name: "note", Type: "TEXT", Default: "'none'", Collation: "BINARY"},
+		{Name: "created at", Type: "TEXT", NotNull: true, Default: "CURRENT_TIMESTAMP", Collation: "RTRIM"},
+		{Name: "qty", Collation: "BINARY"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ColumnMetadata =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if _, err := db.Exec(`CREATE VIEW skus AS SELECT sku FROM "order items"`); err != nil {
+		t.Fatalf("view: %v", err)
+	}
+	got, err = ColumnMetadata(ctx, db, "skus")
+	if err != nil || len(got) != 1 || got[0].Name != "sku" || got[0].Collation != "" {
+		t.Fatalf("ColumnMetadata(view) = %+v, %v", got, err)
+	}
+	if _, err := ColumnMetadata(ctx, db, "missing"); err == nil {
+		t.Fatal("expected an error for a missing table")
+	}
+}
@@ -0,0 +1,133 @@
+//go:build sqlite_session
+
+package sqlitebp
+
+/*
+#include <stdlib.h>
+
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_session sqlite3_session;
+
+extern int sqlite3session_create(sqlite3*, const char*, sqlite3_session**);
+extern void sqlite3session_delete(sqlite3_session*);
+extern int sqlite3session_attach(sqlite3_session*, const char*);
+extern int sqlite3session_isempty(sqlite3_session*);
+extern int sqlite3session_changeset(sqlite3_session*, int*, void**);
+extern int sqlite3changeset_apply(sqlite3*, int, void*, int(*)(void*, const char*), int(*)(void*, int, void*), void*);
+extern void sqlite3_free(void*);
+extern const char *sqlite3_errstr(int);
+
+// Any conflict aborts the whole apply (SQLITE_CHANGESET_ABORT is 2).
+static int bp_conflict_abort(void *arg, int conflict, void *iter) {
+	return 2;
+}
+
+static int bp_changeset_apply(sqlite3 *db, int n, void *changeset) {
+	return sqlite3changeset_apply(db, n, changeset, 0, bp_conflict_abort, 0);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// connSession records the changes made through one connection for WithSession.
+type connSession struct {
+	db      *C.sqlite3
+	session *C.sqlite3_session
+	tables  []string
+	sink    func(changeset []byte)
+
+	// err is set if the session could not be recreated; the connection is then discarded.
+	err error
+}
+
+// newConnSession attaches a session for tables (all if empty) to conn.
+func newConnSession(conn *sqlite3.SQLiteConn, tables []string, sink func(changeset []byte)) (*connSession, error) {
+	s := &connSession{db: rawConn(conn), tables: tables, sink: sink}
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reset replaces the session with an empty one. SQLite has no API to clear a session.
+func (s *connSession) reset() error {
+	s.close()
+	name := C.CString("main")
+	defer C.free(unsafe.Pointer(name))
+	if rc := C.sqlite3session_create(s.db, name, &s.session); rc != 0 {
+		s.session = nil
+		return fmt.Errorf("sqlitebp: sqlite3session_create returned %d", int(rc))
+	}
+	if len(s.tables) == 0 {
+		if rc := C.sqlite3session_attach(s.session, nil); rc != 0 {
+			s.close()
+			return fmt.Errorf("sqlitebp: sqlite3session_attach returned %d", int(rc))
+		}
+	}
+	for _, table := range s.tables {
+		cTable := C.CString(table)
+		rc := C.sqlite3session_attach(s.session, cTable)
+		C.free(unsafe.Pointer(cTable))
+		if rc != 0 {
+			s.close()
+			return fmt.Errorf("sqlitebp: sqlite3session_attach(%q) returned %d", table, int(rc))
+		}
+	}
+	return nil
+}
+
+// flush emits the changeset of a transaction that ended, if it committed, and starts a
+// new session.
+func (s *connSession) flush(committed bool) {
+	if s.session == nil {
+		return
+	}
+	if committed && C.sqlite3session_isempty(s.session) == 0 {
+		var n C.int
+		var p unsafe.Pointer
+		if rc := C.sqlite3session_changeset(s.session, &n, &p); rc != 0 {
+			s.err = fmt.Errorf("sqlitebp: sqlite3session_changeset returned %d", int(rc))
+		} else {
+			changeset := C.GoBytes(p, n)
+			C.sqlite3_free(p)
+			s.sink(changeset)
+		}
+	}
+	if err := s.reset(); err != nil {
+		s.err = err
+	}
+}
+
+// close deletes the session.
+func (s *connSession) close() {
+	if s.session != nil {
+		C.sqlite3session_delete(s.session)
+		s.session = nil
+	}
+}
+
+// ApplyChangeset applies a changeset produced by WithSession to db in a single
+// transaction. Any conflict (e.g. a row that already exists or was changed since)
+// aborts the apply and leaves db unchanged.
+func ApplyChangeset(ctx context.Context, db *sql.DB, changeset []byte) error {
+	if len(changeset) == 0 {
+		return nil
+	}
+	return withRawConn(ctx, db, func(c *sqlite3.SQLiteConn) error {
+		p := C.CBytes(changeset)
+		defer C.free(p)
+		if rc := C.bp_changeset_apply(rawConn(c), C.int(len(changeset)), p); rc != 0 {
+			return errors.New("sqlitebp: apply changeset: " + C.GoString(C.sqlite3_errstr(rc)))
+		}
+		return nil
+	})
+}
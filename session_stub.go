@@ -0,0 +1,35 @@
+//go:build !sqlite_session
+
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// errSessionTag reports a build whose SQLite has the session extension but which lacks
+// the sqlite_session tag that compiles the bindings for it.
+var errSessionTag = errors.Join(ErrFeatureUnavailable, fmt.Errorf("session support requires the sqlite_session build tag"))
+
+// connSession is a placeholder; WithSession requires the sqlite_session build tag.
+type connSession struct {
+	err error
+}
+
+func newConnSession(*sqlite3.SQLiteConn, []string, func([]byte)) (*connSession, error) {
+	return nil, errSessionTag
+}
+
+func (*connSession) flush(bool) {}
+
+func (*connSession) close() {}
+
+// ApplyChangeset applies a changeset produced by WithSession. Without the sqlite_session
+// build tag it always fails with ErrFeatureUnavailable.
+func ApplyChangeset(ctx context.Context, db *sql.DB, changeset []byte) error {
+	return errSessionTag
+}
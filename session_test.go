@@ -0,0 +1,88 @@
+//go:build sqlite_session
+
+package sqlitebp
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Run with:
+//
+//	CGO_CFLAGS="-DSQLITE_ENABLE_SESSION -DSQLITE_ENABLE_PREUPDATE_HOOK" go test -tags sqlite_session ./...
+func TestWithSession_ReplaysIntoSecondDatabase(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const schema = "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL); CREATE TABLE ignored (id INTEGER PRIMARY KEY)"
+
+	var mu sync.Mutex
+	var changesets [][]byte
+	src, err := OpenReadWriteCreate(filepath.Join(dir, "src.db"), WithSession([]string{"items"}, func(changeset []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		changesets = append(changesets, changeset)
+	}))
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	defer src.Close()
+	if _, err := src.Exec(schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	if _, err := src.Exec("INSERT INTO items (name) VALUES ('autocommit'); INSERT INTO ignored DEFAULT VALUES"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	tx, err := src.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := tx.Exec("INSERT INTO items (name) VALUES (?)", name); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	tx, err = src.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO items (name) VALUES ('rolled back')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	tx.Rollback()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changesets) != 2 {
+		t.Fatalf("got %d changesets, want 2", len(changesets))
+	}
+
+	dst, err := OpenReadWriteCreate(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("open destination: %v", err)
+	}
+	defer dst.Close()
+	if _, err := dst.Exec(schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	for _, changeset := range changesets {
+		if err := ApplyChangeset(ctx, dst, changeset); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	var items, ignored int
+	if err := dst.QueryRow("SELECT (SELECT COUNT(*) FROM items), (SELECT COUNT(*) FROM ignored)").Scan(&items, &ignored); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if items != 4 || ignored != 0 {
+		t.Fatalf("items=%d ignored=%d, want 4 and 0", items, ignored)
+	}
+	// Replaying again conflicts on the existing rows.
+	if err := ApplyChangeset(ctx, dst, changesets[0]); err == nil {
+		t.Fatalf("expected conflict applying a changeset twice")
+	}
+}
@@ -4,11 +4,20 @@ package sqlitebp
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/cgo"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +27,7 @@ import (
 var (
 	// ErrEmptyFilename indicates an empty filename was supplied.
 	ErrEmptyFilename = errors.New("sqlitebp: filename cannot be empty")
-	// ErrInvalidMode indicates an internal invalid mode value.
+	// ErrInvalidMode indicates an invalid Mode value.
 	ErrInvalidMode = errors.New("sqlitebp: invalid mode")
 	// ErrOpenFailed indicates the database could not be opened.
 	ErrOpenFailed = errors.New("sqlitebp: open failed")
@@ -28,8 +37,82 @@ var (
 	ErrPingFailed = errors.New("sqlitebp: ping failed")
 	// ErrInvalidConfigOption indicates an invalid configuration option was supplied.
 	ErrInvalidConfigOption = errors.New("sqlitebp: invalid config option")
+	// ErrFeatureUnavailable indicates a required feature is not compiled into the linked SQLite.
+	ErrFeatureUnavailable = errors.New("sqlitebp: feature unavailable")
+	// ErrInitSQL indicates a WithInitSQL statement failed during connection initialization.
+	ErrInitSQL = errors.New("sqlitebp: init sql execution failed")
+	// ErrSchemaMismatch indicates a WithSchemaAssertion check failed at open time.
+	ErrSchemaMismatch = errors.New("sqlitebp: schema mismatch")
+	// ErrLocked indicates the database could not be opened because another connection,
+	// typically in another process, held a conflicting lock for longer than the busy
+	// timeout (for example one using locking_mode=EXCLUSIVE). Unlike other open failures
+	// it is usually transient, so retrying later may succeed.
+	ErrLocked = errors.New("sqlitebp: database is locked")
+	// ErrWithoutRowID indicates a rowid-based API was used on a WITHOUT ROWID table or a view.
+	ErrWithoutRowID = errors.New("sqlitebp: table has no rowid")
+	// ErrGlobalConfig indicates Configure could not apply process-global settings.
+	ErrGlobalConfig = errors.New("sqlitebp: global configuration failed")
+	// ErrSchemaNewerThanBinary indicates the database's user_version is beyond the last
+	// migration passed to WithMigrations, i.e. it was migrated by a newer binary.
+	ErrSchemaNewerThanBinary = errors.New("sqlitebp: schema is newer than this binary")
+	// ErrConnectHook indicates a WithConnectHook function failed while a connection was
+	// initialized.
+	ErrConnectHook = errors.New("sqlitebp: connect hook failed")
 )
 
+// PragmaError is returned when a PRAGMA fails while a connection is initialized, whether
+// set by an option or run as part of the setup (such as optimize). Name is the pragma,
+// Value the value it was being set to (empty if it was only run or read) and Err the
+// cause. It matches ErrPragmaExec with errors.Is, so callers can branch on the setting the
+// environment rejected with errors.As.
+type PragmaError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+// Error implements error.
+func (e *PragmaError) Error() string {
+	statement := "PRAGMA " + e.Name
+	if e.Value != "" {
+		statement += "=" + e.Value
+	}
+	return fmt.Sprintf("%v: %s: %v", ErrPragmaExec, statement, e.Err)
+}
+
+// Unwrap returns ErrPragmaExec and the cause.
+func (e *PragmaError) Unwrap() []error {
+	return []error{ErrPragmaExec, e.Err}
+}
+
+// defaultReadOnlyMMapSize is the mmap_size used by read-only opens unless overridden with
+// WithMMapSize. Memory-mapped reads are served from the page cache of the OS without a
+// read() syscall and copy per page, which pays off for read-heavy workloads. Writers keep
+// SQLite's default of 0: an I/O error on a mapped page kills the process with SIGBUS
+// rather than returning an error, and writes still go through write() anyway.
+// SQLite silently clamps the value to SQLITE_MAX_MMAP_SIZE, 0x7fff0000 (about 2 GiB) on
+// Linux, macOS and the BSDs and 0 (mmap unsupported) on OpenBSD.
+const defaultReadOnlyMMapSize = 256 << 20
+
+// maxMMapSize is SQLITE_MAX_MMAP_SIZE on the platforms that support mmap, the largest
+// mmap_size SQLite accepts.
+const maxMMapSize = 0x7fff0000
+
+// defaultSchemaRefreshLifetime is the connection lifetime WithReadOnlySchemaRefresh uses
+// unless one is set with WithConnMaxLifetime, so that connections which cannot notice a
+// schema change (such as immutable ones) are still replaced eventually.
+const defaultSchemaRefreshLifetime = 5 * time.Minute
+
+// openStepTimeout bounds the quick steps of an open after the ping (warmup, metadata,
+// validation, ...). A variable so tests can shorten it.
+var openStepTimeout = 10 * time.Second
+
+// defaultReadOnlyCacheSize is the cache_size (negative KiB form) used by read-only opens
+// unless overridden with WithCacheSizeMiB: 8 MiB instead of 32 MiB. Read-only pools have
+// up to twice as many connections, each with its own cache, and with mmap most reads are
+// served from the OS page cache, which all connections and handles on the file share.
+const defaultReadOnlyCacheSize = "-8192"
+
 var defaultOptions = map[string]string{
 	// Use a private cache to avoid issues with multiple connections.
 	// Shared cache is an obsolete feature that SQLite discourages using.
@@ -67,33 +150,530 @@ var defaultOptions = map[string]string{
 	// When the cache is full, SQLite will evict pages using an LRU algorithm.
 	// See: https://www.sqlite.org/pragma.html#pragma_cache_size
 	"_cache_size": "-32768", // -32768 means 32 MiB of cache.
+
+	// Begin transactions with BEGIN IMMEDIATE so the write lock is taken up front.
+	// A deferred transaction that reads and then writes must upgrade its lock, and if
+	// another connection committed in the meantime SQLite returns SQLITE_BUSY right away
+	// without consulting the busy timeout. Taking the lock eagerly makes writers queue
+	// on the busy timeout instead, at the cost of serializing read-only transactions
+	// opened on write-capable handles. Not applied in read-only mode.
+	// See: https://www.sqlite.org/lang_transaction.html
+	"_txlock": "immediate",
 }
 
-// Internal symbolic modes.
-type internalMode string
+// Mode is the access mode a database is opened with.
+type Mode string
 
 const (
-	modeReadOnly        internalMode = "ro"
-	modeReadWrite       internalMode = "rw"
-	modeReadWriteCreate internalMode = "rwc"
+	// ModeReadOnly opens an existing database without write access.
+	ModeReadOnly Mode = "ro"
+	// ModeReadWrite opens an existing database with read/write access.
+	ModeReadWrite Mode = "rw"
+	// ModeReadWriteCreate opens or creates a database with read/write access.
+	ModeReadWriteCreate Mode = "rwc"
 )
 
+// Open opens filename in the given mode. OpenReadOnly, OpenReadWrite and OpenReadWriteCreate
+// are shorthands for the three modes.
+func Open(filename string, mode Mode, opts ...Option) (*sql.DB, error) {
+	return openWithMode(filename, mode, opts...)
+}
+
 // OpenReadOnly opens an existing database in read-only mode (journal mode not forced; no writes).
 func OpenReadOnly(filename string, opts ...Option) (*sql.DB, error) {
-	return openWithMode(filename, modeReadOnly, opts...)
+	return openWithMode(filename, ModeReadOnly, opts...)
 }
 
 // OpenReadWrite opens an existing database with read/write access (must exist).
 func OpenReadWrite(filename string, opts ...Option) (*sql.DB, error) {
-	return openWithMode(filename, modeReadWrite, opts...)
+	return openWithMode(filename, ModeReadWrite, opts...)
 }
 
 // OpenReadWriteCreate opens or creates a database with full read/write access.
 func OpenReadWriteCreate(filename string, opts ...Option) (*sql.DB, error) {
-	return openWithMode(filename, modeReadWriteCreate, opts...)
+	return openWithMode(filename, ModeReadWriteCreate, opts...)
+}
+
+// OpenOrCreate opens filename read/write, creating it if needed, and runs initIfNew in a
+// transaction if the database is new, i.e. has no schema yet. initIfNew should create the
+// schema; a database it leaves without any table, index, view or trigger counts as new
+// again on the next open.
+//
+// The check runs inside the write transaction (IMMEDIATE by default), so when several
+// processes create the file at the same time exactly one of them initializes it and the
+// others wait for its commit and then skip initIfNew. If initIfNew fails its changes are
+// rolled back and the error is returned.
+func OpenOrCreate(filename string, initIfNew func(tx *sql.Tx) error, opts ...Option) (*sql.DB, error) {
+	if initIfNew == nil {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("init function cannot be nil"))
+	}
+	db, err := openWithMode(filename, ModeReadWriteCreate, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = Transaction(context.Background(), db, func(tx *sql.Tx) error {
+		var objects int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_schema").Scan(&objects); err != nil {
+			return err
+		}
+		if objects > 0 {
+			return nil
+		}
+		return initIfNew(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Join(ErrInitSQL, fmt.Errorf("failed to initialize new database %q: %w", filename, err))
+	}
+	return db, nil
+}
+
+// OpenMemory opens a new, empty in-memory database. Each call gets a randomly named
+// database of its own, so handles opened concurrently (such as by parallel tests) never
+// share state. Use OpenSharedMemory to open the same in-memory database more than once.
+func OpenMemory(opts ...Option) (*sql.DB, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to generate in-memory database name: %w", err))
+	}
+	return OpenSharedMemory("sqlitebp-"+hex.EncodeToString(b[:]), opts...)
+}
+
+// OpenSharedMemory opens the in-memory database called name, creating it if needed. Every
+// handle and connection in the process that opens the same name sees the same database.
+// It is stored with SQLite's memdb VFS and lives until the last connection to it closes;
+// the pool keeps idle connections open, so WithConnMaxIdleTime and WithConnMaxLifetime are
+// rejected. WAL is not available in memory: the journal mode is left at MEMORY, and
+// WithJournalMode("WAL") fails with ErrInvalidConfigOption.
+func OpenSharedMemory(name string, opts ...Option) (*sql.DB, error) {
+	if name == "" {
+		return nil, ErrEmptyFilename
+	}
+	// memdb VFS names must start with a slash.
+	return openWithMode("/"+name, ModeReadWriteCreate, append(opts[:len(opts):len(opts)], inMemory())...)
+}
+
+// OpenSharded opens a dataset split across several database files as one handle. files
+// maps schema names to filenames; each file is created if needed, switched to WAL, and
+// attached read/write under its schema name to an empty in-memory main database (see
+// OpenMemory), so its tables are queried as schema.table. WithUnionView combines a table
+// present in every shard into one view. Shards are attached in schema name order.
+//
+// SQLite attaches at most 10 databases to a connection by default, and foreign keys,
+// triggers and views in one shard cannot refer to another. A transaction that writes to
+// several shards is atomic only across a crash if the main database is a file; with the
+// in-memory main database each shard commits on its own.
+func OpenSharded(files map[string]string, opts ...Option) (*sql.DB, error) {
+	if len(files) == 0 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("no shard files"))
+	}
+	schemas := make([]string, 0, len(files))
+	for schema := range files {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	all := opts[:len(opts):len(opts)]
+	for _, schema := range schemas {
+		all = append(all, attach(schema, files[schema], true))
+	}
+	return OpenMemory(all...)
+}
+
+// OpenDateRange opens the files in dir whose names match pattern, a time layout such as
+// "events-2006-01-02.db", for dates from from to to inclusive, as one read-only dataset.
+// Only the calendar days of from and to in the location of from count, not their time of
+// day, and names are parsed in that location. Like OpenSharded, each file is attached to an
+// in-memory main database, read-only and in date order, under the schema "d" followed by
+// its date (e.g. d20240131); at most one file may match per day. Every table present in all
+// of the files is combined into a TEMP view of the same name with UNION ALL, so it can be
+// queried across the range unqualified; WithUnionView adds views under other names.
+//
+// SQLite attaches at most 10 databases to a connection, or fewer if lowered with
+// WithLimit(sqlite3.SQLITE_LIMIT_ATTACHED, n); longer ranges fail with
+// ErrInvalidConfigOption.
+func OpenDateRange(dir, pattern string, from, to time.Time, opts ...Option) (*sql.DB, error) {
+	if pattern == "" {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("file name pattern cannot be empty"))
+	}
+	loc := from.Location()
+	from, to = startOfDay(from, loc), startOfDay(to, loc)
+	if to.Before(from) {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("date range ends before it starts"))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to list %q: %w", dir, err))
+	}
+	type dayFile struct {
+		day  time.Time
+		name string
+	}
+	var files []dayFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		day, err := time.ParseInLocation(pattern, e.Name(), loc)
+		if err != nil {
+			continue
+		}
+		if day = startOfDay(day, loc); day.Before(from) || day.After(to) {
+			continue
+		}
+		files = append(files, dayFile{day: day, name: e.Name()})
+	}
+	if len(files) == 0 {
+		return nil, errors.Join(ErrOpenFailed, fmt.Errorf("no files in %q match %q from %s to %s",
+			dir, pattern, from.Format(time.DateOnly), to.Format(time.DateOnly)))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].day.Before(files[j].day) })
+	all := opts[:len(opts):len(opts)]
+	for i, f := range files {
+		if i > 0 && f.day.Format(time.DateOnly) == files[i-1].day.Format(time.DateOnly) {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("%q and %q are for the same day", files[i-1].name, f.name))
+		}
+		all = append(all, attach("d"+f.day.Format("20060102"), filepath.Join(dir, f.name), false))
+	}
+	return OpenMemory(append(all, unionCommonTables())...)
+}
+
+// startOfDay returns midnight at the start of t's calendar day in loc.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// OpenContext is Open with a context bounding the initial connection and validation.
+// The open fails with ctx's error, without touching the file, if ctx is already done.
+// ctx only applies to the open; it is not retained by the returned handle.
+func OpenContext(ctx context.Context, filename string, mode Mode, opts ...Option) (*sql.DB, error) {
+	return openContext(ctx, filename, mode, opts...)
+}
+
+func openWithMode(filename string, mode Mode, opts ...Option) (*sql.DB, error) {
+	return openContext(context.Background(), filename, mode, opts...)
+}
+
+func openContext(ctx context.Context, filename string, mode Mode, opts ...Option) (*sql.DB, error) {
+	db, _, err := openPools(ctx, filename, mode, false, opts...)
+	return db, err
+}
+
+// openPools opens filename like openContext. If withWriter is set it also returns a pool
+// of one connection for DB.WriteConn, made from the same configuration and connector; its
+// connection is opened on first use, and the once-per-open steps (audit event, ping,
+// migrations, ...) only run on the main pool. With WithShmMode("heap") the main pool's only
+// connection holds the file exclusively, so the main pool is returned as the writer too.
+func openPools(ctx context.Context, filename string, mode Mode, withWriter bool, opts ...Option) (_, writer *sql.DB, err error) {
+	cfg, err := prepareConfig(filename, mode, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Deferred first so that it sees the redacted error.
+	if cfg.auditHook != nil {
+		defer func() { cfg.audit(mode, err) }()
+	}
+	defer func() {
+		if err != nil {
+			err = cfg.nameError(cfg.redactError(err))
+		}
+	}()
+	if cfg.minVersion != 0 {
+		if err := checkVersion(cfg.minVersion); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Fail before registering a driver or starting the pool's goroutines.
+	if err := ctx.Err(); err != nil {
+		return nil, nil, errors.Join(ErrPingFailed, fmt.Errorf("failed to open database %q: %w", filename, err))
+	}
+	if cfg.noFollow {
+		if err := checkNotSymlink(filename); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.vfs != nil {
+		if err := registerVFS(cfg.params["vfs"], cfg.vfs); err != nil {
+			return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to register VFS: %w", err))
+		}
+	}
+	if cfg.noFollow {
+		if err := registerNoFollowVFS(); err != nil {
+			return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to register VFS: %w", err))
+		}
+	}
+	if cfg.errorLog != nil {
+		if err := registerErrorLog(cfg.errorLog); err != nil {
+			return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to register error log callback: %w", err))
+		}
+	}
+
+	// The hook handles live for the remainder of the process.
+	if cfg.walHook != nil || cfg.walSizeLimit > 0 {
+		cfg.walHookState = &walHook{fn: cfg.walHook, limit: cfg.walSizeLimit}
+		cfg.walHookHandle = cgo.NewHandle(cfg.walHookState)
+	}
+	if cfg.progressFn != nil {
+		cfg.progressHandle = cgo.NewHandle(cfg.progressFn)
+	}
+	connector, err := cfg.driver().(driver.DriverContext).OpenConnector(cfg.dsn())
+	if err != nil {
+		return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to open database %q: %w", filename, err))
+	}
+
+	// Open the database.
+	db := sql.OpenDB(connector)
+	if cfg.busyDiag != nil {
+		cfg.busyDiag.db.Store(db)
+	}
+
+	// Configure the connection pool with a sensible number of connections.
+	parallelism := cfg.maxOpenConns
+	if parallelism == 0 {
+		procs := runtime.GOMAXPROCS(0)
+		if cfg.cgroupPoolSizing {
+			procs = effectiveProcs(procs)
+		}
+		parallelism = defaultPoolSize(mode, procs)
+	}
+	db.SetMaxOpenConns(parallelism)
+	db.SetMaxIdleConns(parallelism)
+	if cfg.noWALReplay {
+		db.SetMaxIdleConns(0)
+	}
+	// Lifetimes are enforced per connection by the driver wrapper (see WithConnMaxLifetime).
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(cfg.connMaxIdleTime)
+	if withWriter {
+		writer = db
+		if cfg.shmMode != "heap" {
+			w := sql.OpenDB(connector)
+			w.SetMaxOpenConns(1)
+			w.SetMaxIdleConns(1)
+			if cfg.noWALReplay {
+				w.SetMaxIdleConns(0)
+			}
+			w.SetConnMaxLifetime(0)
+			w.SetConnMaxIdleTime(cfg.connMaxIdleTime)
+			defer func() {
+				if err != nil {
+					w.Close()
+				}
+			}()
+			writer = w
+		}
+	}
+
+	// Validate connectivity and force driver initialization.
+	if err := cfg.ping(ctx, db); err != nil {
+		db.Close()
+		if errors.Is(err, ErrConnectHook) {
+			return nil, nil, fmt.Errorf("failed to open database %q: %w", filename, err)
+		}
+		err = fmt.Errorf("failed to ping database %q: %w", filename, err)
+		if isLockedError(err) {
+			return nil, nil, errors.Join(ErrPingFailed, ErrLocked, err)
+		}
+		return nil, nil, errors.Join(ErrPingFailed, err)
+	}
+	if cfg.reindex != nil {
+		err := Transaction(ctx, db, func(tx *sql.Tx) error {
+			for _, collation := range cfg.reindex {
+				if _, err := tx.ExecContext(ctx, "REINDEX "+quoteIdent(collation)); err != nil {
+					return fmt.Errorf("failed to reindex collation %q: %w", collation, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, nil, errors.Join(ErrOpenFailed, err)
+		}
+	}
+	// The quick setup steps below are bounded; REINDEX above and migrations run under the
+	// caller's ctx alone, since they may rebuild large tables. The timer is stopped by
+	// cancel as soon as the open returns.
+	bounded, cancel := context.WithTimeout(ctx, openStepTimeout)
+	defer cancel()
+	if cfg.warmup {
+		if err := warmup(bounded, db, parallelism); err != nil {
+			db.Close()
+			return nil, nil, errors.Join(ErrPingFailed, fmt.Errorf("failed to warm up connections to %q: %w", filename, err))
+		}
+	}
+	if cfg.metadata != nil && mode != ModeReadOnly {
+		if err := stampMetadata(bounded, db, cfg.metadata); err != nil {
+			db.Close()
+			return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to write metadata to %q: %w", filename, err))
+		}
+	}
+	if cfg.advisoryLocks != nil && mode != ModeReadOnly {
+		err := Transaction(bounded, db, func(tx *sql.Tx) error {
+			return createLocks(bounded, tx, cfg.advisoryLocks...)
+		})
+		if err != nil {
+			db.Close()
+			return nil, nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to create advisory locks in %q: %w", filename, err))
+		}
+	}
+	if cfg.migrations != nil {
+		if err := migrate(ctx, db, cfg.migrations, mode != ModeReadOnly); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		// The steps after a long migration get a timeout of their own.
+		cancel()
+		bounded, cancel = context.WithTimeout(ctx, openStepTimeout)
+		defer cancel()
+	}
+	if cfg.validationQuery != "" {
+		if err := runValidationQuery(bounded, db, cfg.validationQuery); err != nil {
+			db.Close()
+			return nil, nil, errors.Join(ErrPingFailed, fmt.Errorf("validation query on %q failed: %w", filename, err))
+		}
+	}
+	for _, a := range cfg.schemaAssertions {
+		if err := a.check(bounded, db); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+	cfg.opened.Store(true)
+	return db, writer, nil
+}
+
+// ping checks that db can be connected to, bounding each attempt by 10 seconds. With
+// WithOpenRetry, failures that may be transient are retried with doubling backoff.
+func (cfg *openConfig) ping(ctx context.Context, db *sql.DB) error {
+	backoff := cfg.openBackoff
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := db.PingContext(pingCtx)
+		cancel()
+		// A failing connect hook is the caller's code, not the file being unavailable.
+		if err == nil || attempt >= cfg.openAttempts || !isTransientOpenError(err) || errors.Is(err, ErrConnectHook) {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientOpenError reports whether a failed connection attempt may succeed when
+// retried shortly: the file could not be opened (SQLITE_CANTOPEN, e.g. ENOENT while a
+// volume is still being mounted), was locked (SQLITE_BUSY, SQLITE_LOCKED) or an I/O error
+// occurred (SQLITE_IOERR, e.g. EBUSY). Anything else, such as a file that is not a
+// database, a rejected pragma or a cancelled context, fails the same way every time.
+func isTransientOpenError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrCantOpen, sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrIoErr:
+		return true
+	}
+	return false
+}
+
+// isLockedError reports whether err is an SQLITE_BUSY or SQLITE_LOCKED error.
+func isLockedError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// sensitiveParams are the DSN parameters and pragmas whose values are kept out of errors:
+// encryption keys (SQLCipher, SEE) and go-sqlite3's user authentication credentials.
+// WithRedactedParams adds to the list.
+var sensitiveParams = map[string]bool{
+	"key":        true,
+	"rekey":      true,
+	"hexkey":     true,
+	"hexrekey":   true,
+	"textkey":    true,
+	"textrekey":  true,
+	"_auth_pass": true,
+	"_auth_salt": true,
+}
+
+// sensitive reports whether the value of the DSN parameter or pragma name must be redacted.
+func (cfg *openConfig) sensitive(name string) bool {
+	name = strings.ToLower(name)
+	return sensitiveParams[name] || slices.Contains(cfg.redactedParams, name)
+}
+
+// nameError prefixes err with the handle's WithName name, if any.
+func (cfg *openConfig) nameError(err error) error {
+	if cfg.name == "" {
+		return err
+	}
+	return fmt.Errorf("database %q: %w", cfg.name, err)
 }
 
-func openWithMode(filename string, mode internalMode, opts ...Option) (*sql.DB, error) {
+// redactError replaces the values of sensitive parameters and pragmas in err's message
+// with [REDACTED], e.g. in a failed "PRAGMA key='...'" statement. The result still
+// matches the same errors with errors.Is and errors.As.
+func (cfg *openConfig) redactError(err error) error {
+	var secrets []string
+	for k, v := range cfg.params {
+		if cfg.sensitive(k) {
+			secrets = append(secrets, v)
+		}
+	}
+	for _, p := range cfg.pragmas {
+		if cfg.sensitive(p.name) {
+			secrets = append(secrets, p.value)
+			if unquoted, ok := strings.CutPrefix(p.value, "'"); ok {
+				secrets = append(secrets, strings.ReplaceAll(strings.TrimSuffix(unquoted, "'"), "''", "'"))
+			}
+		}
+	}
+	// Replace longer values first so a quoted value is not left with its quotes.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	msg := err.Error()
+	redacted := msg
+	for _, secret := range secrets {
+		if secret != "" {
+			redacted = strings.ReplaceAll(redacted, secret, "[REDACTED]")
+		}
+	}
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{err: err, msg: redacted}
+}
+
+// redactedError is err with a redacted message.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// DSN returns the data source name an open of filename in mode with opts would pass to
+// go-sqlite3, including merged defaults, without opening anything. Identical arguments
+// always produce an identical string, so it can be logged or compared across opens.
+// Settings applied per connection by the ConnectHook (such as journal_mode, temp_store and
+// WithPragma values) are not part of the DSN.
+func DSN(filename string, mode Mode, opts ...Option) (string, error) {
+	cfg, err := prepareConfig(filename, mode, opts...)
+	if err != nil {
+		return "", err
+	}
+	return cfg.dsn(), nil
+}
+
+// prepareConfig validates filename and mode, applies opts and merges the defaults.
+// It has no side effects so DSN can share it with openWithMode.
+func prepareConfig(filename string, mode Mode, opts ...Option) (*openConfig, error) {
 	if filename == "" {
 		return nil, ErrEmptyFilename
 	}
@@ -104,101 +684,546 @@ func openWithMode(filename string, mode internalMode, opts ...Option) (*sql.DB,
 	}
 
 	// Create config with user options applied.
-	cfg := &openConfig{
-		params:  make(map[string]string),
-		pragmas: make(map[string]string),
-	}
-	for _, opt := range opts {
-		if opt == nil {
-			continue
-		}
-		if err := opt(cfg); err != nil {
-			return nil, err
-		}
+	cfg, err := newOpenConfig(opts...)
+	if err != nil {
+		return nil, err
 	}
+	cfg.filename = filename
+	cfg.applyProfile()
 
+	_, customCacheSize := cfg.params["_cache_size"]
+	_, customJournalMode := cfg.params["_journal_mode"]
 	// Merge defaults where not already set by user options.
 	for k, v := range defaultOptions {
 		if _, ok := cfg.params[k]; !ok {
 			cfg.params[k] = v
 		}
 	}
-	if _, ok := cfg.pragmas["temp_store"]; !ok {
-		cfg.pragmas["temp_store"] = "MEMORY"
+	if _, ok := cfg.pragma("temp_store"); !ok {
+		cfg.setPragma("temp_store", "MEMORY")
 	}
 
 	// Set the open mode.
 	switch mode {
-	case modeReadOnly:
-		cfg.params["mode"] = string(modeReadOnly)
+	case ModeReadOnly:
+		cfg.params["mode"] = string(ModeReadOnly)
 		// Never set journal mode in read-only mode, just use the default.
 		delete(cfg.params, "_journal_mode")
-	case modeReadWrite:
-		cfg.params["mode"] = string(modeReadWrite)
-	case modeReadWriteCreate:
-		cfg.params["mode"] = string(modeReadWriteCreate)
+		// Read-only connections cannot take the write lock BEGIN IMMEDIATE requires.
+		delete(cfg.params, "_txlock")
+		if cfg.immutable {
+			cfg.params["immutable"] = "1"
+		}
+		if cfg.mmapWholeFile {
+			info, err := os.Stat(filename)
+			if err != nil {
+				return nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to size memory map: %w", err))
+			}
+			cfg.setPragma("mmap_size", fmt.Sprintf("%d", min(info.Size(), maxMMapSize)))
+		} else if _, ok := cfg.pragma("mmap_size"); !ok {
+			cfg.setPragma("mmap_size", fmt.Sprintf("%d", defaultReadOnlyMMapSize))
+		}
+		if !customCacheSize {
+			cfg.params["_cache_size"] = defaultReadOnlyCacheSize
+		}
+	case ModeReadWrite:
+		cfg.params["mode"] = string(ModeReadWrite)
+	case ModeReadWriteCreate:
+		cfg.params["mode"] = string(ModeReadWriteCreate)
 	default:
 		return nil, errors.Join(ErrInvalidMode, fmt.Errorf("invalid mode %s", mode))
 	}
-
-	// Generate a unique driver name for this open.
-	// This could be improved but should be sufficient in practice and it's very simple.
-	driverName := fmt.Sprintf("sqlite3_bp_%d_%p", time.Now().UnixNano(), cfg)
-	sql.Register(driverName, &sqlite3.SQLiteDriver{
-		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-			// Apply PRAGMA optimize if enabled.
-			if !cfg.disableOptimize { // run optimize unless disabled
-				if _, err := conn.Exec("PRAGMA optimize", nil); err != nil {
-					return errors.Join(ErrPragmaExec, fmt.Errorf("failed to execute %q: %w", "PRAGMA optimize", err))
-				}
-			}
-			// Apply pragma.s
-			for name, value := range cfg.pragmas {
-				statement := fmt.Sprintf("PRAGMA %s=%s", name, value)
-				if _, err := conn.Exec(statement, nil); err != nil {
-					return errors.Join(ErrPragmaExec, fmt.Errorf("failed to execute %q: %w", statement, err))
-				}
+	if cfg.noFollow {
+		if cfg.memory || cfg.vfs != nil {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithNoFollow requires the default VFS"))
+		}
+		cfg.params["vfs"] = noFollowVFS
+	}
+	if cfg.vfs != nil {
+		if cfg.memory {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithVFSImplementation cannot be used with in-memory databases"))
+		}
+		if m := cfg.params["_journal_mode"]; m == "WAL" || m == "WAL2" {
+			if customJournalMode {
+				return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("journal mode %s requires shared memory, which WithVFSImplementation does not provide", m))
 			}
-			return nil
-		},
-	})
+			delete(cfg.params, "_journal_mode")
+		}
+	}
+	if cfg.memory {
+		if cfg.connMaxIdleTime > 0 || cfg.connMaxLifetime > 0 {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("in-memory databases do not support connection expiry"))
+		}
+		if cfg.shmMode != "" {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithShmMode cannot be used with in-memory databases"))
+		}
+		cfg.params["vfs"] = "memdb"
+		if m := cfg.params["_journal_mode"]; !customJournalMode {
+			delete(cfg.params, "_journal_mode")
+		} else if m == "WAL" || m == "WAL2" {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("journal mode %s is not available for in-memory databases", m))
+		}
+	}
+	if cfg.schemaRefresh {
+		if mode != ModeReadOnly {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithReadOnlySchemaRefresh requires ModeReadOnly"))
+		}
+		if cfg.connMaxLifetime == 0 {
+			cfg.connMaxLifetime = defaultSchemaRefreshLifetime
+		}
+	}
+	if cfg.walSizeLimit > 0 {
+		if mode == ModeReadOnly {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithWALSizeLimit requires a writable mode"))
+		}
+		if _, ok := cfg.pragma("journal_size_limit"); !ok {
+			cfg.setPragma("journal_size_limit", strconv.FormatInt(cfg.walSizeLimit, 10))
+		}
+	}
+	if limit := cfg.attachLimit(); len(cfg.attachments) > limit {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("%d databases to attach exceed SQLite's limit of %d", len(cfg.attachments), limit))
+	}
+	if cfg.unionViews != nil && len(cfg.attachments) == 0 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithUnionView requires attached databases"))
+	}
+	if mode == ModeReadOnly && cfg.reindex != nil {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithReindexOnOpen requires a writable mode"))
+	}
+	if mode != ModeReadOnly && cfg.mmapWholeFile {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithMMapWholeFile requires ModeReadOnly"))
+	}
+	if mode != ModeReadOnly && cfg.noWALReplay {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithoutWALReplay requires ModeReadOnly"))
+	}
+	if mode != ModeReadOnly && cfg.immutable {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithImmutable requires ModeReadOnly"))
+	}
+	if cfg.cgroupPoolSizing && cfg.maxOpenConns != 0 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithCgroupAwarePoolSizing cannot be combined with WithMaxOpenConns"))
+	}
+	if cfg.shmMode == "heap" {
+		if mode == ModeReadOnly {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithShmMode(\"heap\") requires a writable mode"))
+		}
+		if cfg.maxOpenConns > 1 {
+			return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("WithShmMode(\"heap\") allows a single connection"))
+		}
+		// EXCLUSIVE locking must be in effect before the first WAL access for SQLite to
+		// keep the WAL index on the heap; go-sqlite3 applies it before our ConnectHook
+		// sets journal_mode.
+		cfg.params["_locking_mode"] = "EXCLUSIVE"
+		cfg.maxOpenConns = 1
+	}
+	// go-sqlite3 applies DSN pragmas before the ConnectHook runs, and switching a new
+	// database to WAL writes its header, fixing page_size and encoding. Apply journal_mode
+	// from the ConnectHook instead so the pragmas that must come first can.
+	if journalMode, ok := cfg.params["_journal_mode"]; ok {
+		delete(cfg.params, "_journal_mode")
+		cfg.setPragma("journal_mode", journalMode)
+	}
+	return cfg, nil
+}
 
-	// Build the DSN string.
-	// See https://www.sqlite.org/draft/uri.html for details.
+// dsn builds the DSN string from the filename and params, sorted for determinism.
+// See https://www.sqlite.org/draft/uri.html for details.
+func (cfg *openConfig) dsn() string {
 	var finalOpts []string
 	for k, v := range cfg.params {
 		finalOpts = append(finalOpts, k+"="+v)
 	}
 	sort.Strings(finalOpts)
-	dsn := "file:" + filename
+	dsn := "file:" + cfg.filename
 	if len(finalOpts) > 0 {
 		dsn += "?" + strings.Join(finalOpts, "&")
 	}
+	return dsn
+}
 
-	// Open the database.
-	db, err := sql.Open(driverName, dsn)
+// defaultPoolSize returns the default number of pooled connections for mode.
+//
+// Write-capable opens use between 2 and 8 connections based on GOMAXPROCS.
+// Rarely does SQLite benefit from more than 8 connections due to its
+// locking and concurrency model. Most applications will see diminishing
+// returns beyond 2-4 connections, but we allow up to 8 for highly concurrent
+// workloads on machines with many cores.
+//
+// Read-only opens never contend for the write lock, and in WAL mode readers do not
+// block each other, so they use between 4 and 16 connections (2x GOMAXPROCS).
+func defaultPoolSize(mode Mode, procs int) int {
+	if mode == ModeReadOnly {
+		return min(16, max(4, 2*procs))
+	}
+	return min(8, max(2, procs))
+}
+
+// connect initializes each new connection. It runs as the driver ConnectHook, after
+// go-sqlite3 has applied the DSN parameters; hookCtx is the context the connection was
+// requested with.
+func (cfg *openConfig) connect(hookCtx context.Context, conn *sqlite3.SQLiteConn) (err error) {
+	// Connections are also opened after Open returns, so redact here as well. Errors during
+	// the open are named by openContext.
+	defer func() {
+		if err != nil {
+			err = cfg.redactError(err)
+			if cfg.opened.Load() {
+				err = cfg.nameError(err)
+			}
+		}
+	}()
+	ctx := context.Background()
+	if cfg.connInitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.connInitTimeout)
+		defer cancel()
+	}
+	exec := func(statement string) error {
+		if _, err := conn.ExecContext(ctx, statement, nil); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("connection initialization exceeded %s running %q: %w", cfg.connInitTimeout, statement, ctx.Err())
+			}
+			return err
+		}
+		return nil
+	}
+
+	// Check required features first so a missing one gets a descriptive error
+	// instead of failing the pragma or extension that depends on it.
+	if len(cfg.features) > 0 {
+		opts, err := compileOptions(conn)
+		if err != nil {
+			return errors.Join(ErrPragmaExec, fmt.Errorf("failed to read compile options: %w", err))
+		}
+		if err := checkFeatures(opts, cfg.features); err != nil {
+			return err
+		}
+	}
+	if cfg.noFollow {
+		if err := checkNotSymlink(cfg.filename); err != nil {
+			return err
+		}
+	}
+	for id, value := range cfg.limits {
+		conn.SetLimit(id, value)
+	}
+	if cfg.chunkSize > 0 {
+		if err := conn.SetFileControlInt("main", sqlite3.SQLITE_FCNTL_CHUNK_SIZE, cfg.chunkSize); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to set chunk size: %w", err))
+		}
+	}
+	if cfg.persistWAL {
+		if err := conn.SetFileControlInt("main", sqlite3.SQLITE_FCNTL_PERSIST_WAL, 1); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to enable persistent WAL: %w", err))
+		}
+	}
+	// Register functions, collations and modules before anything that might use them.
+	for _, f := range cfg.funcs {
+		if err := conn.RegisterFunc(f.name, f.impl, f.pure); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to register function %q: %w", f.name, err))
+		}
+	}
+	for _, coll := range cfg.collations {
+		if err := conn.RegisterCollation(coll.name, coll.cmp); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to register collation %q: %w", coll.name, err))
+		}
+	}
+	for _, tf := range cfg.tableFuncs {
+		if err := tf.register(conn); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to register module %q: %w", tf.name, err))
+		}
+	}
+	// Apply pragmas.
+	for _, p := range cfg.orderedPragmas() {
+		if err := exec(fmt.Sprintf("PRAGMA %s=%s", p.name, p.value)); err != nil {
+			return cfg.pragmaError(p.name, p.value, err)
+		}
+		// Builds without wal2 ignore the unknown mode and keep the current one.
+		if p.name == "journal_mode" && p.value == "WAL2" {
+			if err := checkJournalMode(conn, "wal2"); err != nil {
+				return cfg.pragmaError(p.name, p.value, err)
+			}
+		}
+	}
+	// go-sqlite3 enables foreign keys from the DSN before this hook runs. Confirm it took
+	// effect (builds with SQLITE_OMIT_FOREIGN_KEY silently ignore the pragma) before any
+	// init statement or connect hook can write rows that would escape enforcement.
+	if cfg.params["_foreign_keys"] == "true" {
+		enabled, err := foreignKeysEnabled(conn)
+		if err != nil {
+			return cfg.pragmaError("foreign_keys", "", err)
+		}
+		if !enabled {
+			return cfg.pragmaError("foreign_keys", "ON", errors.New("foreign key enforcement could not be enabled"))
+		}
+	}
+	// Apply PRAGMA optimize if enabled. It reads the schema, so it runs after the pragmas
+	// (such as key) that must precede any access to the file. optimize may run ANALYZE,
+	// upgrading its read transaction to a write one; SQLite does not wait for the busy
+	// timeout on such an upgrade, so a concurrent writer makes it fail with SQLITE_BUSY.
+	// optimize is advisory, and skipping it then is better than failing the connection.
+	if cfg.optimizeOnConnect() {
+		var sqliteErr sqlite3.Error
+		if err := exec("PRAGMA optimize"); err != nil && !(errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrBusy) {
+			return cfg.pragmaError("optimize", "", err)
+		}
+	}
+	for _, ext := range cfg.extensions {
+		if err := conn.LoadExtension(ext.path, ext.entry); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to load extension %q: %w", ext.path, err))
+		}
+	}
+	// Files attached to an in-memory database would otherwise be memdb databases too.
+	var vfs string
+	if cfg.memory && len(cfg.attachments) > 0 {
+		vfs = defaultVFS()
+	}
+	for _, a := range cfg.attachments {
+		if err := exec("ATTACH DATABASE " + quoteString(a.uri(vfs)) + " AS " + quoteIdent(a.schema)); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to attach %q as %q: %w", a.filename, a.schema, err))
+		}
+		// The pragmas above only applied to main.
+		if a.writable {
+			if err := exec("PRAGMA " + quoteIdent(a.schema) + ".journal_mode=WAL"); err != nil {
+				return cfg.pragmaError(a.schema+".journal_mode", "WAL", err)
+			}
+		}
+	}
+	views := cfg.unionViews
+	if cfg.unionCommon {
+		tables, err := commonTables(conn, cfg.attachments)
+		if err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to list attached tables: %w", err))
+		}
+		for _, table := range tables {
+			if !slices.ContainsFunc(views, func(v unionView) bool { return strings.EqualFold(v.view, table) }) {
+				views = append(views[:len(views):len(views)], unionView{view: table, table: table})
+			}
+		}
+	}
+	for _, v := range views {
+		if err := exec(v.sql(cfg.attachments)); err != nil {
+			return errors.Join(ErrOpenFailed, fmt.Errorf("failed to create union view %q: %w", v.view, err))
+		}
+	}
+	// User init statements run last so they see the fully configured connection.
+	for _, statement := range cfg.initSQL {
+		if err := exec(statement); err != nil {
+			return errors.Join(ErrInitSQL, fmt.Errorf("failed to execute %q: %w", statement, err))
+		}
+	}
+	for i, hook := range cfg.connectHooks {
+		if err := cfg.runConnectHook(hookCtx, hook, conn); err != nil {
+			return errors.Join(ErrOpenFailed, ErrConnectHook, fmt.Errorf("connect hook %d failed: %w", i+1, err))
+		}
+	}
+	if cfg.walHookHandle != 0 {
+		if cfg.walSizeLimit > 0 {
+			pageSize, err := intPragma(conn, "page_size")
+			if err != nil {
+				return cfg.pragmaError("page_size", "", err)
+			}
+			cfg.walHookState.pageSize.Store(pageSize)
+		}
+		setWALHook(conn, cfg.walHookHandle)
+	}
+	// With WithInterruptOnCancel or WithStatementTimeout the driver wrapper installs a
+	// per-connection handler instead.
+	if cfg.progressHandle != 0 && !cfg.interruptOnCancel && cfg.stepTimeout <= 0 {
+		setProgressHandler(conn, cfg.progressOps, cfg.progressHandle)
+	}
+	// Read-only connections also refuse writes to TEMP tables and the in-memory journal,
+	// which mode=ro allows, and fail a write before it touches the file. This comes last so
+	// that WithInitSQL statements and connect hooks can still create TEMP objects.
+	if cfg.readOnly() {
+		if err := exec("PRAGMA query_only=ON"); err != nil {
+			return cfg.pragmaError("query_only", "ON", err)
+		}
+	}
+	return nil
+}
+
+// maxConnectHookBackoff caps the doubling wait between connect hook attempts.
+const maxConnectHookBackoff = time.Minute
+
+// runConnectHook calls hook on conn. Once the handle is open, failures are reported to and
+// retried per WithConnectHookErrorRecovery; before then the first failure is returned.
+// Waiting for the next attempt stops when ctx is done, and no wait is longer than
+// maxConnectHookBackoff.
+func (cfg *openConfig) runConnectHook(ctx context.Context, hook func(conn *sqlite3.SQLiteConn) error, conn *sqlite3.SQLiteConn) error {
+	backoff := min(cfg.hookBackoff, maxConnectHookBackoff)
+	for attempt := 1; ; attempt++ {
+		err := hook(conn)
+		if err == nil || !cfg.opened.Load() {
+			return err
+		}
+		if cfg.hookOnError != nil {
+			cfg.hookOnError(err, attempt)
+		}
+		if attempt >= cfg.hookAttempts {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxConnectHookBackoff)
+	}
+}
+
+// pragmaError reports the failure of PRAGMA name (set to value, if not empty) while
+// initializing a connection. Values of sensitive pragmas are not included.
+func (cfg *openConfig) pragmaError(name, value string, err error) error {
+	if value != "" && cfg.sensitive(name) {
+		value = "[REDACTED]"
+	}
+	return &PragmaError{Name: name, Value: value, Err: err}
+}
+
+// checkJournalMode fails if conn is not in the journal mode want (lower case).
+func checkJournalMode(conn *sqlite3.SQLiteConn, want string) error {
+	rows, err := conn.Query("PRAGMA journal_mode", nil)
 	if err != nil {
-		return nil, errors.Join(ErrOpenFailed, fmt.Errorf("failed to open database %q: %w", filename, err))
+		return fmt.Errorf("failed to read journal_mode: %w", err)
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return fmt.Errorf("failed to read journal_mode: %w", err)
+	}
+	var got string
+	switch v := dest[0].(type) {
+	case string:
+		got = v
+	case []byte:
+		got = string(v)
+	}
+	if got != want {
+		return fmt.Errorf("journal mode %s is not supported by this SQLite build (journal_mode is %s)", strings.ToUpper(want), got)
 	}
+	return nil
+}
 
-	// Configure the connection pool with a sensible number of connections.
-	// Use between 2 and 8 connections based on GOMAXPROCS.
-	// Rarely does SQLite benefit from more than 8 connections due to its
-	// locking and concurrency model. Most applications will see diminishing
-	// returns beyond 2-4 connections, but we allow up to 8 for highly concurrent
-	// workloads on machines with many cores.
-	parallelism := min(8, max(2, runtime.GOMAXPROCS(0)))
-	db.SetMaxOpenConns(parallelism)
-	db.SetMaxIdleConns(parallelism)
-	db.SetConnMaxLifetime(0)
-	db.SetConnMaxIdleTime(0)
+// checkVersion fails if the linked SQLite library is older than minVersion
+// (in SQLITE_VERSION_NUMBER form, e.g. 3045000 for 3.45.0).
+func checkVersion(minVersion int) error {
+	version, number, _ := sqlite3.Version()
+	if number < minVersion {
+		return errors.Join(ErrFeatureUnavailable, fmt.Errorf("SQLite %s is older than the required %d.%d.%d",
+			version, minVersion/1000000, minVersion/1000%1000, minVersion%1000))
+	}
+	return nil
+}
 
-	// Validate connectivity and force driver initialization.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		db.Close()
-		return nil, errors.Join(ErrPingFailed, fmt.Errorf("failed to ping database %q: %w", filename, err))
+// warmup opens n connections at once, running the ConnectHook for each, and returns them
+// to the pool as idle connections.
+func warmup(ctx context.Context, db *sql.DB, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, c)
 	}
-	return db, nil
+	return nil
+}
+
+// runValidationQuery runs query and reads all of its rows, so errors raised while stepping
+// (not just while preparing) are reported.
+func runValidationQuery(ctx context.Context, db *sql.DB, query string) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// commonTables returns the names of the tables, other than SQLite's own, present in every
+// attached database, sorted.
+func commonTables(conn *sqlite3.SQLiteConn, attachments []attachment) ([]string, error) {
+	seen := make(map[string]int)
+	for _, a := range attachments {
+		rows, err := conn.Query("SELECT lower(name) FROM "+quoteIdent(a.schema)+".sqlite_schema WHERE type = 'table' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\'", nil)
+		if err != nil {
+			return nil, err
+		}
+		dest := make([]driver.Value, 1)
+		for {
+			if err := rows.Next(dest); err == io.EOF {
+				break
+			} else if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			seen[valueString(dest[0])]++
+		}
+		rows.Close()
+	}
+	var tables []string
+	for name, n := range seen {
+		if n == len(attachments) {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// intPragma returns the integer value of PRAGMA name for conn.
+func intPragma(conn *sqlite3.SQLiteConn, name string) (int64, error) {
+	rows, err := conn.Query("PRAGMA "+name, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return 0, err
+	}
+	v, _ := dest[0].(int64)
+	return v, nil
+}
+
+// foreignKeysEnabled reports whether foreign key enforcement is on for conn.
+func foreignKeysEnabled(conn *sqlite3.SQLiteConn) (bool, error) {
+	rows, err := conn.Query("PRAGMA foreign_keys", nil)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			// No row at all: foreign key support is compiled out.
+			return false, nil
+		}
+		return false, err
+	}
+	enabled, _ := dest[0].(int64)
+	return enabled == 1, nil
+}
+
+// checkNotSymlink fails if filename exists and is a symbolic link.
+func checkNotSymlink(filename string) error {
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.Join(ErrOpenFailed, fmt.Errorf("failed to stat %q: %w", filename, err))
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return errors.Join(ErrOpenFailed, fmt.Errorf("database %q is a symbolic link", filename))
+	}
+	return nil
 }
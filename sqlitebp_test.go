@@ -1,13 +1,21 @@
 package sqlitebp
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 func TestOpen_ValidModes(t *testing.T) {
@@ -135,6 +143,55 @@ func TestOpen_DefaultPragmasApplied(t *testing.T) {
 	}
 }
 
+func TestWithProfile(t *testing.T) {
+	pragmas := []string{"synchronous", "cache_size", "wal_autocheckpoint", "fullfsync", "mmap_size", "temp_store"}
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want map[string]int64
+	}{
+		{"high throughput", []Option{WithProfile(HighThroughput)},
+			map[string]int64{"synchronous": 1, "cache_size": -131072, "wal_autocheckpoint": 10000, "fullfsync": 0, "temp_store": 2}},
+		{"durable", []Option{WithProfile(Durable)},
+			map[string]int64{"synchronous": 2, "cache_size": -32768, "wal_autocheckpoint": 1000, "fullfsync": 1, "temp_store": 2}},
+		{"low memory", []Option{WithProfile(LowMemory)},
+			map[string]int64{"synchronous": 1, "cache_size": -2048, "wal_autocheckpoint": 1000, "mmap_size": 0, "temp_store": 1}},
+		// Explicit options win over the profile, before or after it.
+		{"overridden", []Option{WithSynchronous("EXTRA"), WithProfile(HighThroughput), WithCacheSizeMiB(4), WithPragma("wal_autocheckpoint", "500")},
+			map[string]int64{"synchronous": 3, "cache_size": -4096, "wal_autocheckpoint": 500}},
+		{"overridden low memory", []Option{WithProfile(LowMemory), WithTempStore("MEMORY"), WithMMapSize(1 << 20)},
+			map[string]int64{"cache_size": -2048, "mmap_size": 1 << 20, "temp_store": 2}},
+	} {
+		db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "profile.db"), tc.opts...)
+		if err != nil {
+			t.Fatalf("%s: open: %v", tc.name, err)
+		}
+		for _, name := range pragmas {
+			want, ok := tc.want[name]
+			if !ok {
+				continue
+			}
+			var got int64
+			if err := db.QueryRow("PRAGMA " + name).Scan(&got); err != nil {
+				t.Fatalf("%s: %s: %v", tc.name, name, err)
+			}
+			if got != want {
+				t.Errorf("%s: %s=%d, want %d", tc.name, name, got, want)
+			}
+		}
+		db.Close()
+	}
+
+	for _, opts := range [][]Option{
+		{WithProfile("fast")},
+		{WithProfile(Durable), WithProfile(LowMemory)},
+	} {
+		if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "profile.db"), opts...); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+		}
+	}
+}
+
 func TestConnectHook_PragmasAppliedToEachConnection(t *testing.T) {
 	tempDir := t.TempDir()
 	fn := filepath.Join(tempDir, "hook.db")
@@ -259,6 +316,306 @@ func TestOpen_ReadOnlyMode(t *testing.T) {
 	}
 }
 
+func TestMMapSize_ModeAwareDefault(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "mmap.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer rw.Close()
+	ro, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("ro open: %v", err)
+	}
+	defer ro.Close()
+
+	var rwSize, roSize int64
+	if err := rw.QueryRow("PRAGMA mmap_size").Scan(&rwSize); err != nil {
+		t.Fatalf("rw mmap_size: %v", err)
+	}
+	if err := ro.QueryRow("PRAGMA mmap_size").Scan(&roSize); err != nil {
+		t.Fatalf("ro mmap_size: %v", err)
+	}
+	if roSize != defaultReadOnlyMMapSize || roSize <= rwSize {
+		t.Fatalf("read-only mmap_size=%d read-write=%d, want %d and larger", roSize, rwSize, defaultReadOnlyMMapSize)
+	}
+
+	override, err := OpenReadOnly(fn, WithMMapSize(1<<20))
+	if err != nil {
+		t.Fatalf("override open: %v", err)
+	}
+	defer override.Close()
+	var size int64
+	if err := override.QueryRow("PRAGMA mmap_size").Scan(&size); err != nil || size != 1<<20 {
+		t.Fatalf("overridden mmap_size=%d err=%v want %d", size, err, 1<<20)
+	}
+}
+
+func TestWithMMapWholeFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "mmap.db")
+	rw, err := OpenReadWriteCreate(fn, WithJournalMode("DELETE"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := rw.Exec("CREATE TABLE blobs (data BLOB); WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 512) INSERT INTO blobs SELECT randomblob(4096) FROM n"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	rw.Close()
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	// The option takes precedence over a profile's mmap_size.
+	ro, err := OpenReadOnly(fn, WithProfile(LowMemory), WithMMapWholeFile())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer ro.Close()
+	var size int64
+	if err := ro.QueryRow("PRAGMA mmap_size").Scan(&size); err != nil || size < info.Size() {
+		t.Fatalf("mmap_size=%d err=%v, want at least the file size %d", size, err, info.Size())
+	}
+
+	for _, open := range []func() (*sql.DB, error){
+		func() (*sql.DB, error) { return OpenReadWrite(fn, WithMMapWholeFile()) },
+		func() (*sql.DB, error) { return OpenReadOnly(fn, WithMMapWholeFile(), WithMMapSize(1<<20)) },
+		func() (*sql.DB, error) { return OpenReadOnly(fn, WithMMapSize(1<<20), WithMMapWholeFile()) },
+	} {
+		if _, err := open(); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+		}
+	}
+}
+
+func TestCacheSize_ModeAwareDefault(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cache.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer rw.Close()
+	ro, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("ro open: %v", err)
+	}
+	defer ro.Close()
+	override, err := OpenReadOnly(fn, WithCacheSizeMiB(64))
+	if err != nil {
+		t.Fatalf("override open: %v", err)
+	}
+	defer override.Close()
+
+	for _, c := range []struct {
+		name string
+		db   *sql.DB
+		want string
+	}{
+		{"read-write", rw, "-32768"},
+		{"read-only", ro, defaultReadOnlyCacheSize},
+		{"read-only override", override, "-65536"},
+	} {
+		var size string
+		if err := c.db.QueryRow("PRAGMA cache_size").Scan(&size); err != nil || size != c.want {
+			t.Errorf("%s cache_size=%s err=%v, want %s", c.name, size, err, c.want)
+		}
+	}
+}
+
+func TestWithOpenRetry(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "late.db")
+	staged := filepath.Join(dir, "staged.db")
+	db, err := OpenReadWriteCreate(staged, WithJournalMode("DELETE"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+
+	// The file appears after the first attempt has failed.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Rename(staged, fn)
+	}()
+	db, err = OpenReadWrite(fn, WithOpenRetry(10, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("open with retry: %v", err)
+	}
+	db.Close()
+
+	// Errors that cannot go away are not retried.
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, bytes.Repeat([]byte("not a database"), 512), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	start := time.Now()
+	if _, err := OpenReadWrite(garbage, WithOpenRetry(5, time.Second)); err == nil {
+		t.Fatalf("expected an error opening a file that is not a database")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("permanent error took %v, want no retries", elapsed)
+	}
+
+	if _, err := OpenReadWrite(fn, WithOpenRetry(0, time.Second)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
+
+func TestWithConnectHookErrorRecovery(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "hook.db")
+	ref := filepath.Join(dir, "ref.db")
+	for _, name := range []string{fn, ref} {
+		db, err := OpenReadWriteCreate(name)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		db.Close()
+	}
+	attach := func(conn *sqlite3.SQLiteConn) error {
+		_, err := conn.Exec("ATTACH DATABASE 'file:"+ref+"?mode=ro' AS ref", nil)
+		return err
+	}
+
+	// A failure on the open's first connection fails the open, without WithOpenRetry
+	// retrying the SQLITE_CANTOPEN of the missing file.
+	if err := os.Rename(ref, ref+".moved"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	var calls atomic.Int32
+	start := time.Now()
+	db, err := openWithMode(fn, ModeReadWrite, WithOpenRetry(5, time.Second), WithConnectHook(func(conn *sqlite3.SQLiteConn) error {
+		calls.Add(1)
+		return attach(conn)
+	}))
+	var sqliteErr sqlite3.Error
+	if db != nil || !errors.Is(err, ErrConnectHook) || !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrCantOpen {
+		t.Fatalf("expected the wrapped hook error, got db=%v err=%v", db, err)
+	}
+	if n := calls.Load(); n != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hook called %d times in %v, want once without retries", n, time.Since(start))
+	}
+	if err := os.Rename(ref+".moved", ref); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	// Later failures are reported and retried.
+	var failures atomic.Int32
+	var mu sync.Mutex
+	var attempts []int
+	db, err = openWithMode(fn, ModeReadWrite, WithMaxOpenConns(3),
+		WithConnectHook(func(conn *sqlite3.SQLiteConn) error {
+			if failures.Load() > 0 {
+				failures.Add(-1)
+				return errors.New("transient")
+			}
+			return attach(conn)
+		}),
+		WithConnectHookErrorRecovery(3, time.Millisecond, func(err error, attempt int) {
+			mu.Lock()
+			attempts = append(attempts, attempt)
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conns := holdConns(t, db, 1) // the open's connection
+	defer conns[0].Close()
+	failures.Store(2)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("expected the connection to recover, got %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "SELECT count(*) FROM ref.sqlite_schema"); err != nil {
+		t.Fatalf("query attachment: %v", err)
+	}
+	failures.Store(3)
+	if _, err := db.Conn(context.Background()); !errors.Is(err, ErrConnectHook) {
+		t.Fatalf("expected ErrConnectHook once attempts run out, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(attempts) != "[1 2 1 2 3]" {
+		t.Fatalf("reported attempts %v, want [1 2 1 2 3]", attempts)
+	}
+
+	// The wait for the next attempt ends with the context of the caller.
+	var fail atomic.Bool
+	var reported atomic.Int32
+	slow, err := OpenWithConfig(fn, ModeReadWrite, Config{
+		ConnectHooks: []func(conn *sqlite3.SQLiteConn) error{func(conn *sqlite3.SQLiteConn) error {
+			if fail.Load() {
+				return errors.New("transient")
+			}
+			return nil
+		}},
+		ConnectHookAttempts: 3,
+		ConnectHookBackoff:  Duration(time.Hour),
+		ConnectHookOnError:  func(err error, attempt int) { reported.Add(1) },
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer slow.Close()
+	held := holdConns(t, slow, 1)
+	defer held[0].Close()
+	fail.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := slow.Conn(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second || reported.Load() != 1 {
+		t.Fatalf("gave up after %v and %d reported failures, want one within the deadline", elapsed, reported.Load())
+	}
+
+	if _, err := OpenReadWrite(fn, WithConnectHookErrorRecovery(0, time.Second, nil)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
+
+func TestWithName(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenReadWrite(filepath.Join(dir, "missing.db"), WithName("billing"))
+	if !errors.Is(err, ErrPingFailed) || !strings.Contains(err.Error(), `database "billing"`) {
+		t.Fatalf("expected a named ErrPingFailed, got %v", err)
+	}
+	fn := filepath.Join(dir, "pragma.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+	// application_id is stored in the header, so setting it fails on a read-only open.
+	_, err = OpenReadOnly(fn, WithName("billing"), WithPragma("application_id", "7"))
+	var pragmaErr *PragmaError
+	if !errors.As(err, &pragmaErr) || !strings.Contains(err.Error(), `database "billing"`) {
+		t.Fatalf("expected a named PragmaError, got %v", err)
+	}
+
+	// Connections opened after the open are named too.
+	var fail atomic.Bool
+	db, err = OpenReadWriteCreate(filepath.Join(dir, "hook.db"), WithName("tenant-7"), WithConnectHook(func(*sqlite3.SQLiteConn) error {
+		if fail.Load() {
+			return errors.New("boom")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conns := holdConns(t, db, 1)
+	defer conns[0].Close()
+	fail.Store(true)
+	if _, err := db.Conn(context.Background()); !errors.Is(err, ErrConnectHook) || !strings.HasPrefix(err.Error(), `database "tenant-7": `) {
+		t.Fatalf("expected a named ErrConnectHook, got %v", err)
+	}
+}
+
 func TestOpen_ContextTimeout(t *testing.T) {
 	tempDir := t.TempDir()
 	fn := filepath.Join(tempDir, "timeout.db")
@@ -274,128 +631,434 @@ func TestOpen_ContextTimeout(t *testing.T) {
 	}
 }
 
-func TestOpen_ConcurrentAccess(t *testing.T) {
-	tempDir := t.TempDir()
-	fn := filepath.Join(tempDir, "concurrent.db")
+func TestOpenContext_NoLeakedGoroutines(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "leak.db")
 	db, err := OpenReadWriteCreate(fn)
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
-	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT"); err != nil {
-		t.Fatalf("table: %v", err)
-	}
 	db.Close()
 
-	rw, _ := OpenReadWrite(fn)
-	ro, _ := OpenReadOnly(fn)
-	defer rw.Close()
-	defer ro.Close()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OpenContext(cancelled, fn, ModeReadWrite); !errors.Is(err, ErrPingFailed) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled open to fail with context.Canceled, got %v", err)
+	}
 
-	if _, err := rw.Exec("INSERT INTO test (value) VALUES ('v')"); err != nil {
-		t.Fatalf("insert: %v", err)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		if _, err := OpenContext(cancelled, fn, ModeReadWrite); err == nil {
+			t.Fatalf("cancelled open succeeded")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		db, err := OpenContext(ctx, fn, ModeReadWrite)
+		cancel()
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		db.Close()
 	}
-	var c int
-	if err := ro.QueryRow("SELECT COUNT(*) FROM test").Scan(&c); err != nil || c != 1 {
-		t.Fatalf("count=%d err=%v", c, err)
+	// Pool goroutines exit asynchronously after Close.
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines grew from %d to %d across opens", before, after)
 	}
 }
 
-func TestConcurrentAccess_ManyConnections(t *testing.T) {
+func TestWithValidationQuery(t *testing.T) {
 	tempDir := t.TempDir()
-	fn := filepath.Join(tempDir, "stress.db")
-	init, err := OpenReadWriteCreate(fn)
+	fn := filepath.Join(tempDir, "valid.db")
+	db, err := OpenReadWriteCreate(fn)
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
-	if _, err := init.Exec(`CREATE TABLE test_data (id INTEGER PRIMARY KEY AUTOINCREMENT, writer_id INTEGER NOT NULL, value TEXT NOT NULL, timestamp INTEGER NOT NULL) STRICT`); err != nil {
-		t.Fatalf("table: %v", err)
+	if _, err := db.Exec("CREATE TABLE expected_table (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("create table: %v", err)
 	}
-	init.Close()
+	db.Close()
 
-	writerErrs := make(chan error, 100)
-	readerErrs := make(chan error, 100)
-	writerDone := make(chan bool, 100)
-	readerDone := make(chan bool, 100)
+	const query = "SELECT COUNT(*) FROM expected_table"
+	db, err = OpenReadOnly(fn, WithValidationQuery(query))
+	if err != nil {
+		t.Fatalf("valid database: %v", err)
+	}
+	db.Close()
 
-	startTime := time.Now()
-	for i := 0; i < 100; i++ {
-		go func(id int) {
-			defer func() { writerDone <- true }()
-			w, err := OpenReadWrite(fn)
-			if err != nil {
-				writerErrs <- err
-				return
-			}
-			defer w.Close()
-			for j := 0; j < 10; j++ {
-				if _, e := w.Exec("INSERT INTO test_data (writer_id, value, timestamp) VALUES (?, ?, ?)", id, fmt.Sprintf("d-%d-%d", id, j), time.Now().UnixNano()); e != nil {
-					writerErrs <- e
-					return
-				}
-				time.Sleep(time.Millisecond)
-			}
-		}(i)
+	empty := filepath.Join(tempDir, "empty.db")
+	db, err = OpenReadWriteCreate(empty)
+	if err != nil {
+		t.Fatalf("create empty: %v", err)
+	}
+	db.Close()
+	if _, err := OpenReadOnly(empty, WithValidationQuery(query)); !errors.Is(err, ErrPingFailed) || !strings.Contains(err.Error(), "no such table") {
+		t.Fatalf("expected ErrPingFailed for missing table, got %v", err)
 	}
+}
 
-	time.Sleep(10 * time.Millisecond)
+func TestWithRedactedParams(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "redact.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
 
-	for i := 0; i < 100; i++ {
-		go func(id int) {
-			defer func() { readerDone <- true }()
-			r, err := OpenReadOnly(fn)
-			if err != nil {
-				readerErrs <- err
-				return
-			}
-			defer r.Close()
-			for j := 0; j < 5; j++ {
-				var cnt int
-				if e := r.QueryRow("SELECT COUNT(*) FROM test_data").Scan(&cnt); e != nil {
-					readerErrs <- e
-					return
-				}
-				if cnt == 0 && time.Since(startTime) > 50*time.Millisecond {
-					readerErrs <- fmt.Errorf("no data after 50ms")
-					return
-				}
-				time.Sleep(2 * time.Millisecond)
-			}
-		}(i)
+	// Setting application_id needs a write, so it fails on a read-only open and the
+	// error names the statement.
+	const secret = "hunter2"
+	_, err = OpenReadOnly(fn, WithPragma("application_id", "'"+secret+"'"), WithRedactedParams("Application_ID"))
+	if !errors.Is(err, ErrPragmaExec) {
+		t.Fatalf("expected ErrPragmaExec, got %v", err)
+	}
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("error %q contains the secret", err)
+	}
+	if !strings.Contains(err.Error(), "[REDACTED]") {
+		t.Errorf("error %q does not mark the redaction", err)
+	}
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		t.Errorf("redacted error no longer wraps the SQLite error: %v", err)
 	}
 
-	timeout := time.After(30 * time.Second)
-	wc, rc := 0, 0
-	for wc < 100 || rc < 100 {
-		select {
-		case <-writerDone:
-			wc++
-		case <-readerDone:
-			rc++
-		case e := <-writerErrs:
-			t.Errorf("writer: %v", e)
-		case e := <-readerErrs:
-			t.Errorf("reader: %v", e)
-		case <-timeout:
-			t.Fatalf("timeout writers=%d readers=%d", wc, rc)
-		}
+	// Keys are redacted by default, including from option validation.
+	_, err = OpenReadOnly(fn, WithPragma("key", "'"+secret))
+	if !errors.Is(err, ErrInvalidConfigOption) || strings.Contains(err.Error(), secret) {
+		t.Fatalf("expected ErrInvalidConfigOption without the secret, got %v", err)
 	}
+}
 
-	ver, err := OpenReadOnly(fn)
+func TestOpen_ErrLocked(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	// Heap shm mode holds an exclusive lock from the first access until close.
+	holder, err := OpenReadWriteCreate(fn, WithShmMode("heap"))
 	if err != nil {
-		t.Fatalf("verify open: %v", err)
+		t.Fatalf("open holder: %v", err)
 	}
-	defer ver.Close()
-	var rows, writers int
-	if err := ver.QueryRow("SELECT COUNT(*) FROM test_data").Scan(&rows); err != nil || rows != 1000 {
-		t.Fatalf("rows=%d err=%v", rows, err)
+	if _, err := holder.Exec("CREATE TABLE t (x INTEGER); INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("write: %v", err)
 	}
-	if err := ver.QueryRow("SELECT COUNT(DISTINCT writer_id) FROM test_data").Scan(&writers); err != nil || writers != 100 {
-		t.Fatalf("writers=%d err=%v", writers, err)
+
+	_, err = OpenReadOnly(fn, WithBusyTimeoutSeconds(0))
+	if !errors.Is(err, ErrLocked) || !errors.Is(err, ErrPingFailed) {
+		t.Fatalf("expected ErrLocked, got %v", err)
 	}
-}
+	holder.Close()
 
-func BenchmarkOpen(b *testing.B) {
-	tempDir := b.TempDir()
+	// Once the lock is released the same open succeeds, and a missing file is not
+	// reported as locked.
+	db, err := OpenReadOnly(fn, WithBusyTimeoutSeconds(0))
+	if err != nil {
+		t.Fatalf("open after release: %v", err)
+	}
+	db.Close()
+	if _, err := OpenReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil || errors.Is(err, ErrLocked) {
+		t.Fatalf("expected a non-lock error for a missing file, got %v", err)
+	}
+}
+
+func TestWithShmMode_Heap(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenReadWriteCreate(fn, WithShmMode("heap"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if got := db.Stats().MaxOpenConnections; got != 1 {
+		t.Fatalf("MaxOpenConnections = %d, want 1", got)
+	}
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Fatalf("journal_mode=%q err=%v", journalMode, err)
+	}
+	if _, err := db.Exec("CREATE TABLE t (x INTEGER); INSERT INTO t VALUES (1), (2)"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	if _, err := os.Stat(fn + "-wal"); err != nil {
+		t.Fatalf("expected a -wal file: %v", err)
+	}
+	if _, err := os.Stat(fn + "-shm"); !os.IsNotExist(err) {
+		t.Fatalf("expected no -shm file, got %v", err)
+	}
+
+	for _, opts := range [][]Option{
+		{WithShmMode("tmpfs")},
+		{WithShmMode("heap"), WithShmMode("mmap")},
+		{WithShmMode("heap"), WithMaxOpenConns(2)},
+	} {
+		if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "x.db"), opts...); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+		}
+	}
+	if _, err := OpenReadOnly(fn, WithShmMode("heap")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for read-only, got %v", err)
+	}
+}
+
+func TestWithJournalMode_WAL2(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "wal2.db"), WithJournalMode("wal2"))
+	if err != nil {
+		// Mainline SQLite has no wal2 mode; the open must say so rather than fall back.
+		if !errors.Is(err, ErrPragmaExec) || !strings.Contains(err.Error(), "WAL2 is not supported") {
+			t.Fatalf("expected descriptive ErrPragmaExec, got %v", err)
+		}
+		return
+	}
+	defer db.Close()
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal2" {
+		t.Fatalf("journal_mode=%q err=%v want wal2", mode, err)
+	}
+}
+
+func TestOpenMemory_Isolated(t *testing.T) {
+	for _, table := range []string{"left", "right"} {
+		table := table
+		t.Run(table, func(t *testing.T) {
+			t.Parallel()
+			db, err := OpenMemory()
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer db.Close()
+			if _, err := db.Exec("CREATE TABLE " + table + " (id INTEGER PRIMARY KEY) STRICT"); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			tables, _, _, _, err := SchemaObjects(context.Background(), db)
+			if err != nil {
+				t.Fatalf("schema objects: %v", err)
+			}
+			if len(tables) != 1 || tables[0] != table {
+				t.Fatalf("tables = %v, want only %q", tables, table)
+			}
+		})
+	}
+}
+
+func TestOpenSharedMemory(t *testing.T) {
+	name := "test-open-shared-memory"
+	a, err := OpenSharedMemory(name)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer a.Close()
+	b, err := OpenSharedMemory(name)
+	if err != nil {
+		t.Fatalf("open second handle: %v", err)
+	}
+	defer b.Close()
+
+	if _, err := a.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT; INSERT INTO test DEFAULT VALUES"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	var n int
+	if err := b.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("second handle saw n=%d err=%v, want 1 row", n, err)
+	}
+
+	if _, err := OpenSharedMemory(name, WithConnMaxIdleTime(time.Minute)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption with WithConnMaxIdleTime, got %v", err)
+	}
+	if _, err := OpenSharedMemory(name, WithJournalMode("WAL")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption with WAL, got %v", err)
+	}
+	off, err := OpenSharedMemory(name, WithJournalMode("OFF"))
+	if err != nil {
+		t.Fatalf("open with journal_mode=OFF: %v", err)
+	}
+	defer off.Close()
+	var mode string
+	if err := off.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "off" {
+		t.Fatalf("journal_mode = %q (err=%v), want off", mode, err)
+	}
+	if _, err := OpenSharedMemory(""); !errors.Is(err, ErrEmptyFilename) {
+		t.Fatalf("expected ErrEmptyFilename, got %v", err)
+	}
+}
+
+func TestOpenOrCreate(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "app.db")
+	var inits atomic.Int32
+	initSchema := func(tx *sql.Tx) error {
+		inits.Add(1)
+		_, err := tx.Exec("CREATE TABLE settings (k TEXT PRIMARY KEY, v TEXT); INSERT INTO settings VALUES ('version', '1')")
+		return err
+	}
+
+	// Several openers race to create the same new file; exactly one initializes it.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := OpenOrCreate(fn, initSchema)
+			if err == nil {
+				db.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent open: %v", err)
+		}
+	}
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("init ran %d times, want 1", n)
+	}
+
+	// Existing file: init is skipped and the data is there.
+	db, err := OpenOrCreate(fn, initSchema)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	var v string
+	if err := db.QueryRow("SELECT v FROM settings WHERE k = 'version'").Scan(&v); err != nil || v != "1" {
+		t.Fatalf("v=%q err=%v", v, err)
+	}
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("init ran %d times after reopen, want 1", n)
+	}
+
+	failing := filepath.Join(t.TempDir(), "failing.db")
+	if _, err := OpenOrCreate(failing, func(*sql.Tx) error { return errors.New("boom") }); !errors.Is(err, ErrInitSQL) {
+		t.Fatalf("expected ErrInitSQL, got %v", err)
+	}
+}
+
+func TestOpen_ConcurrentAccess(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "concurrent.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	db.Close()
+
+	rw, _ := OpenReadWrite(fn)
+	ro, _ := OpenReadOnly(fn)
+	defer rw.Close()
+	defer ro.Close()
+
+	if _, err := rw.Exec("INSERT INTO test (value) VALUES ('v')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	var c int
+	if err := ro.QueryRow("SELECT COUNT(*) FROM test").Scan(&c); err != nil || c != 1 {
+		t.Fatalf("count=%d err=%v", c, err)
+	}
+}
+
+func TestConcurrentAccess_ManyConnections(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "stress.db")
+	init, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := init.Exec(`CREATE TABLE test_data (id INTEGER PRIMARY KEY AUTOINCREMENT, writer_id INTEGER NOT NULL, value TEXT NOT NULL, timestamp INTEGER NOT NULL) STRICT`); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	init.Close()
+
+	writerErrs := make(chan error, 100)
+	readerErrs := make(chan error, 100)
+	writerDone := make(chan bool, 100)
+	readerDone := make(chan bool, 100)
+
+	startTime := time.Now()
+	for i := 0; i < 100; i++ {
+		go func(id int) {
+			defer func() { writerDone <- true }()
+			w, err := OpenReadWrite(fn)
+			if err != nil {
+				writerErrs <- err
+				return
+			}
+			defer w.Close()
+			for j := 0; j < 10; j++ {
+				if _, e := w.Exec("INSERT INTO test_data (writer_id, value, timestamp) VALUES (?, ?, ?)", id, fmt.Sprintf("d-%d-%d", id, j), time.Now().UnixNano()); e != nil {
+					writerErrs <- e
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(i)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		go func(id int) {
+			defer func() { readerDone <- true }()
+			r, err := OpenReadOnly(fn)
+			if err != nil {
+				readerErrs <- err
+				return
+			}
+			defer r.Close()
+			for j := 0; j < 5; j++ {
+				var cnt int
+				if e := r.QueryRow("SELECT COUNT(*) FROM test_data").Scan(&cnt); e != nil {
+					readerErrs <- e
+					return
+				}
+				if cnt == 0 && time.Since(startTime) > 50*time.Millisecond {
+					readerErrs <- fmt.Errorf("no data after 50ms")
+					return
+				}
+				time.Sleep(2 * time.Millisecond)
+			}
+		}(i)
+	}
+
+	timeout := time.After(30 * time.Second)
+	wc, rc := 0, 0
+	for wc < 100 || rc < 100 {
+		select {
+		case <-writerDone:
+			wc++
+		case <-readerDone:
+			rc++
+		case e := <-writerErrs:
+			t.Errorf("writer: %v", e)
+		case e := <-readerErrs:
+			t.Errorf("reader: %v", e)
+		case <-timeout:
+			t.Fatalf("timeout writers=%d readers=%d", wc, rc)
+		}
+	}
+
+	ver, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("verify open: %v", err)
+	}
+	defer ver.Close()
+	var rows, writers int
+	if err := ver.QueryRow("SELECT COUNT(*) FROM test_data").Scan(&rows); err != nil || rows != 1000 {
+		t.Fatalf("rows=%d err=%v", rows, err)
+	}
+	if err := ver.QueryRow("SELECT COUNT(DISTINCT writer_id) FROM test_data").Scan(&writers); err != nil || writers != 100 {
+		t.Fatalf("writers=%d err=%v", writers, err)
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	tempDir := b.TempDir()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -408,3 +1071,1203 @@ func BenchmarkOpen(b *testing.B) {
 		db.Close()
 	}
 }
+
+func TestOptimizeOnConnect_SkippedReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		mode Mode
+		opts []Option
+		want bool
+	}{
+		{ModeReadWriteCreate, nil, true},
+		{ModeReadWriteCreate, []Option{WithOptimize(false)}, false},
+		{ModeReadOnly, nil, false},
+		{ModeReadOnly, []Option{WithOptimize(true)}, false},
+	} {
+		cfg, err := prepareConfig("test.db", tc.mode, tc.opts...)
+		if err != nil {
+			t.Fatalf("prepareConfig(%s): %v", tc.mode, err)
+		}
+		if got := cfg.optimizeOnConnect(); got != tc.want {
+			t.Errorf("optimizeOnConnect(%s, %d options) = %v, want %v", tc.mode, len(tc.opts), got, tc.want)
+		}
+	}
+}
+
+// BenchmarkOpenReadOnly compares opening a read-only handle (which skips PRAGMA optimize)
+// with running optimize on its connection as read/write opens do.
+func BenchmarkOpenReadOnly(b *testing.B) {
+	fn := filepath.Join(b.TempDir(), "bench.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		b.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := db.Exec(fmt.Sprintf("CREATE TABLE t%d (id INTEGER PRIMARY KEY, v TEXT); CREATE INDEX t%d_v ON t%d (v); INSERT INTO t%d (v) VALUES ('x')", i, i, i, i)); err != nil {
+			b.Fatalf("schema: %v", err)
+		}
+	}
+	// Analyzed, so optimize has nothing to write and can run on a read-only connection.
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		b.Fatalf("analyze: %v", err)
+	}
+	db.Close()
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"optimize", []Option{WithInitSQL("PRAGMA optimize")}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				db, err := OpenReadOnly(fn, bc.opts...)
+				if err != nil {
+					b.Fatalf("open: %v", err)
+				}
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestWithWALHook_ReceivesPageCount(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "walhook.db")
+	var mu sync.Mutex
+	var names []string
+	var pages []int
+	db, err := OpenReadWriteCreate(fn, WithWALHook(func(dbName string, n int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		names = append(names, dbName)
+		pages = append(pages, n)
+		return 0
+	}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO test (id) VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pages) < 2 {
+		t.Fatalf("hook calls=%d want >= 2", len(pages))
+	}
+	last := len(pages) - 1
+	if names[last] != "main" || pages[last] <= 0 {
+		t.Errorf("hook got (%s, %d) want (main, >0)", names[last], pages[last])
+	}
+}
+
+func TestWithWALHook_Nil(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "nilhook.db")
+	if _, err := OpenReadWriteCreate(fn, WithWALHook(nil)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected invalid option, got %v", err)
+	}
+}
+
+func TestWithWALSizeLimit(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "wallimit.db")
+	const limit = 256 << 10
+	db, err := OpenReadWriteCreate(fn, WithWALSizeLimit(limit))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, data BLOB) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	// Overlapping readers keep the WAL in use, so PASSIVE checkpoints never reset it.
+	readers, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("open readers: %v", err)
+	}
+	defer readers.Close()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tx, err := readers.Begin()
+				if err != nil {
+					continue
+				}
+				var n int
+				tx.QueryRow("SELECT COUNT(*) FROM test").Scan(&n)
+				time.Sleep(2 * time.Millisecond)
+				tx.Rollback()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// Each insert adds a few 4 KiB pages; without the limit the WAL would reach about 2 MiB.
+	var largest int64
+	blob := make([]byte, 4000)
+	for i := 0; i < 500; i++ {
+		if _, err := db.Exec("INSERT INTO test (data) VALUES (?)", blob); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		if fi, err := os.Stat(fn + "-wal"); err == nil {
+			largest = max(largest, fi.Size())
+		}
+	}
+	// A commit may take the WAL a few frames past the limit before it is truncated.
+	if largest > limit+64<<10 {
+		t.Errorf("WAL reached %d bytes, want at most about %d", largest, limit)
+	}
+
+	if _, err := OpenReadOnly(fn, WithWALSizeLimit(limit)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for read-only open, got %v", err)
+	}
+}
+
+func TestOpen_ModeAwarePoolSize(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "pool.db")
+	rw, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("open rw: %v", err)
+	}
+	defer rw.Close()
+	ro, err := OpenReadOnly(fn)
+	if err != nil {
+		t.Fatalf("open ro: %v", err)
+	}
+	defer ro.Close()
+
+	rwMax := rw.Stats().MaxOpenConnections
+	roMax := ro.Stats().MaxOpenConnections
+	if roMax <= rwMax {
+		t.Errorf("read-only pool %d should exceed read-write pool %d", roMax, rwMax)
+	}
+
+	for _, tc := range []struct {
+		procs, rw, ro int
+	}{
+		{1, 2, 4},
+		{4, 4, 8},
+		{8, 8, 16},
+		{64, 8, 16},
+	} {
+		if got := defaultPoolSize(ModeReadWrite, tc.procs); got != tc.rw {
+			t.Errorf("procs=%d rw=%d want %d", tc.procs, got, tc.rw)
+		}
+		if got := defaultPoolSize(ModeReadOnly, tc.procs); got != tc.ro {
+			t.Errorf("procs=%d ro=%d want %d", tc.procs, got, tc.ro)
+		}
+	}
+}
+
+func TestWithMaxOpenConns(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "maxconns.db")
+	db, err := OpenReadWriteCreate(fn, WithMaxOpenConns(3))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.Close()
+	ro, err := OpenReadOnly(fn, WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open ro: %v", err)
+	}
+	defer ro.Close()
+	if got := ro.Stats().MaxOpenConnections; got != 1 {
+		t.Errorf("max open=%d want 1", got)
+	}
+	if _, err := OpenReadOnly(fn, WithMaxOpenConns(0)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Errorf("expected invalid option, got %v", err)
+	}
+}
+
+// upgradeConflict runs two read-then-write transactions that overlap. The first commits
+// while the second still holds its read snapshot; the second's write result is returned.
+func upgradeConflict(t *testing.T, db *sql.DB) error {
+	t.Helper()
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS test (id INTEGER PRIMARY KEY, value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	tx1, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin tx1: %v", err)
+	}
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		close(started)
+		tx2, err := db.Begin()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer tx2.Rollback()
+		var n int
+		if err := tx2.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil {
+			result <- err
+			return
+		}
+		// Give tx1 time to write and commit after our read.
+		time.Sleep(50 * time.Millisecond)
+		if _, err := tx2.Exec("INSERT INTO test (value) VALUES ('tx2')"); err != nil {
+			result <- err
+			return
+		}
+		result <- tx2.Commit()
+	}()
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	var n int
+	if err := tx1.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil {
+		t.Fatalf("tx1 read: %v", err)
+	}
+	if _, err := tx1.Exec("INSERT INTO test (value) VALUES ('tx1')"); err != nil {
+		t.Fatalf("tx1 insert: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1 commit: %v", err)
+	}
+	return <-result
+}
+
+func TestTransactionLock_ImmediateAvoidsUpgradeBusy(t *testing.T) {
+	tempDir := t.TempDir()
+
+	deferred, err := OpenReadWriteCreate(filepath.Join(tempDir, "deferred.db"), WithTransactionLock("deferred"), WithBusyTimeoutSeconds(5))
+	if err != nil {
+		t.Fatalf("open deferred: %v", err)
+	}
+	defer deferred.Close()
+	if err := upgradeConflict(t, deferred); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("deferred: expected busy error, got %v", err)
+	}
+
+	immediate, err := OpenReadWriteCreate(filepath.Join(tempDir, "immediate.db"), WithBusyTimeoutSeconds(5))
+	if err != nil {
+		t.Fatalf("open immediate: %v", err)
+	}
+	defer immediate.Close()
+	if err := upgradeConflict(t, immediate); err != nil {
+		t.Errorf("immediate: unexpected error %v", err)
+	}
+	var n int
+	if err := immediate.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("count=%d err=%v", n, err)
+	}
+}
+
+func TestTransactionLock_Invalid(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "txlock.db")
+	if _, err := OpenReadWriteCreate(fn, WithTransactionLock("eventually")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected invalid option, got %v", err)
+	}
+}
+
+func TestWithWarmup_OpensFullPool(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "warm.db"), WithMaxOpenConns(5), WithWarmup())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if stats := db.Stats(); stats.Idle != 5 || stats.OpenConnections != 5 {
+		t.Fatalf("idle=%d open=%d, want 5 warm connections", stats.Idle, stats.OpenConnections)
+	}
+
+	cold, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "cold.db"), WithMaxOpenConns(5))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer cold.Close()
+	if open := cold.Stats().OpenConnections; open != 1 {
+		t.Fatalf("open=%d without warmup, want 1", open)
+	}
+}
+
+func TestWithConnMaxIdleTime_PicksUpReplacedFile(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "live.db")
+	build := func(path, value string) {
+		t.Helper()
+		db, err := OpenReadWriteCreate(path, WithJournalMode("DELETE"))
+		if err != nil {
+			t.Fatalf("create %s: %v", path, err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("CREATE TABLE test (value TEXT) STRICT"); err != nil {
+			t.Fatalf("table: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO test (value) VALUES (?)", value); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	build(fn, "old")
+
+	ro, err := OpenReadOnly(fn, WithConnMaxIdleTime(50*time.Millisecond), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open ro: %v", err)
+	}
+	defer ro.Close()
+	read := func() string {
+		t.Helper()
+		var v string
+		if err := ro.QueryRow("SELECT value FROM test").Scan(&v); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return v
+	}
+	if v := read(); v != "old" {
+		t.Fatalf("got %s want old", v)
+	}
+
+	replacement := filepath.Join(tempDir, "replacement.db")
+	build(replacement, "new")
+	if err := os.Rename(replacement, fn); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	// The pool's idle cleaner runs at most once per second.
+	deadline := time.Now().Add(5 * time.Second)
+	for read() != "new" {
+		if time.Now().After(deadline) {
+			t.Fatalf("replaced file not visible after idle reaping")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestWithErrorLogCallback(t *testing.T) {
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "errlog.db")
+	var mu sync.Mutex
+	var messages []string
+	db, err := OpenReadWriteCreate(fn, WithErrorLogCallback(func(code int, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages = append(messages, fmt.Sprintf("%d: %s", code, msg))
+	}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	// Preparing a statement against a missing table logs SQLITE_ERROR.
+	if _, err := db.Exec("SELECT * FROM sqlitebp_missing_table"); err == nil {
+		t.Fatalf("expected error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, m := range messages {
+		if strings.Contains(m, "sqlitebp_missing_table") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("callback did not receive message, got %v", messages)
+	}
+}
+
+func TestWithInitSQL(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "init.db")
+	db, err := OpenReadWriteCreate(fn,
+		WithFunc("double_it", func(x int64) int64 { return 2 * x }, true),
+		WithInitSQL("CREATE TEMP VIEW doubled AS SELECT double_it(21) AS v"),
+	)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var v int
+	if err := db.QueryRow("SELECT v FROM doubled").Scan(&v); err != nil || v != 42 {
+		t.Fatalf("v=%d err=%v", v, err)
+	}
+
+	if _, err := OpenReadWriteCreate(fn, WithInitSQL("SELECT * FROM missing_table")); !errors.Is(err, ErrInitSQL) {
+		t.Fatalf("expected ErrInitSQL, got %v", err)
+	}
+}
+
+func TestWithAttachReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	ref, err := OpenReadWriteCreate(filepath.Join(dir, "ref.db"), WithJournalMode("DELETE"))
+	if err != nil {
+		t.Fatalf("create reference: %v", err)
+	}
+	if _, err := ref.Exec("CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT) STRICT; INSERT INTO countries VALUES ('NL', 'Netherlands')"); err != nil {
+		t.Fatalf("seed reference: %v", err)
+	}
+	ref.Close()
+	// URI metacharacters in the name must not truncate or alter the path. Open rejects
+	// such names, so the file is renamed.
+	refPath := filepath.Join(dir, "ref #1?%20.db")
+	if err := os.Rename(filepath.Join(dir, "ref.db"), refPath); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	db, err := OpenReadWriteCreate(filepath.Join(dir, "main.db"), WithAttachReadOnly("ref", refPath))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, country TEXT) STRICT; INSERT INTO users VALUES (1, 'NL')"); err != nil {
+		t.Fatalf("main write: %v", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT c.name FROM users u JOIN ref.countries c ON c.code = u.country").Scan(&name); err != nil || name != "Netherlands" {
+		t.Fatalf("join name=%q err=%v", name, err)
+	}
+	if _, err := db.Exec("INSERT INTO ref.countries VALUES ('BE', 'Belgium')"); err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Fatalf("expected a read-only error writing to the attached schema, got %v", err)
+	}
+
+	if _, err := OpenReadWriteCreate(filepath.Join(dir, "other.db"), WithAttachReadOnly("ref", filepath.Join(dir, "missing.db"))); !errors.Is(err, ErrOpenFailed) {
+		t.Fatalf("expected ErrOpenFailed for a missing attachment, got %v", err)
+	}
+	for _, opts := range [][]Option{
+		{WithAttachReadOnly("main", refPath)},
+		{WithAttachReadOnly("", refPath)},
+		{WithAttachReadOnly("ref", refPath), WithAttachReadOnly("REF", refPath)},
+	} {
+		if _, err := OpenReadWriteCreate(filepath.Join(dir, "other.db"), opts...); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+		}
+	}
+}
+
+func TestOpenSharded(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for i, rows := range []int{1, 2, 3} {
+		schema := fmt.Sprintf("s%d", i+1)
+		files[schema] = filepath.Join(dir, schema+".db")
+		db, err := OpenReadWriteCreate(files[schema])
+		if err != nil {
+			t.Fatalf("create %s: %v", schema, err)
+		}
+		if _, err := db.Exec("CREATE TABLE events (id INTEGER PRIMARY KEY, shard TEXT) STRICT"); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+		for j := 0; j < rows; j++ {
+			if _, err := db.Exec("INSERT INTO events (shard) VALUES (?)", schema); err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+		}
+		db.Close()
+	}
+	// A shard that does not exist yet is created.
+	files["s4"] = filepath.Join(dir, "s4.db")
+
+	db, err := OpenSharded(files, WithUnionView("events", "events"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE s4.events (id INTEGER PRIMARY KEY, shard TEXT) STRICT"); err != nil {
+		t.Fatalf("create table in new shard: %v", err)
+	}
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM events").Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	if n := count(); n != 6 {
+		t.Fatalf("union count=%d, want 6", n)
+	}
+	if _, err := db.Exec("INSERT INTO s2.events (shard) VALUES ('s2')"); err != nil {
+		t.Fatalf("insert into shard: %v", err)
+	}
+	if n := count(); n != 7 {
+		t.Fatalf("union count after insert=%d, want 7", n)
+	}
+	var mode string
+	if err := db.QueryRow("PRAGMA s4.journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("s4 journal_mode=%q err=%v", mode, err)
+	}
+
+	if _, err := OpenSharded(nil); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption without shards, got %v", err)
+	}
+	if _, err := OpenMemory(WithUnionView("events", "events")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption without attachments, got %v", err)
+	}
+}
+
+func TestOpenDateRange(t *testing.T) {
+	dir := t.TempDir()
+	create := func(day string, extra bool) {
+		t.Helper()
+		db, err := OpenReadWriteCreate(filepath.Join(dir, "events-"+day+".db"))
+		if err != nil {
+			t.Fatalf("create %s: %v", day, err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("CREATE TABLE events (day TEXT, n INTEGER)"); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+		for n := 1; n <= 2; n++ {
+			if _, err := db.Exec("INSERT INTO events VALUES (?, ?)", day, n); err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+		}
+		if extra {
+			if _, err := db.Exec("CREATE TABLE notes (text TEXT)"); err != nil {
+				t.Fatalf("create notes: %v", err)
+			}
+		}
+	}
+	create("2024-01-01", false)
+	create("2024-01-02", true)
+	create("2024-01-03", false)
+	create("2024-01-04", false) // outside the range
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	db, err := OpenDateRange(dir, "events-2006-01-02.db", day(1), day(3))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var days string
+	var count int
+	if err := db.QueryRow("SELECT group_concat(DISTINCT day), count(*) FROM events").Scan(&days, &count); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if days != "2024-01-01,2024-01-02,2024-01-03" || count != 6 {
+		t.Fatalf("got days %q and %d rows, want 3 days and 6 rows", days, count)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM d20240102.notes").Scan(&count); err != nil {
+		t.Fatalf("query a single day: %v", err)
+	}
+	var views string
+	if err := db.QueryRow("SELECT group_concat(name) FROM temp.sqlite_schema WHERE type = 'view'").Scan(&views); err != nil {
+		t.Fatalf("list views: %v", err)
+	}
+	// notes is not in every file, so it has no view.
+	if views != "events" {
+		t.Fatalf("views = %q, want events", views)
+	}
+	if _, err := db.Exec("INSERT INTO d20240101.events VALUES ('x', 0)"); err == nil {
+		t.Fatal("expected attached files to be read-only")
+	}
+
+	// Times of day are ignored: the range still covers all of its first and last day.
+	afternoon := day(2).Add(15 * time.Hour)
+	db, err = OpenDateRange(dir, "events-2006-01-02.db", afternoon, afternoon.Add(-time.Hour).In(time.FixedZone("UTC+10", 10*3600)))
+	if err != nil {
+		t.Fatalf("open with times of day: %v", err)
+	}
+	defer db.Close()
+	if err := db.QueryRow("SELECT group_concat(DISTINCT day) FROM events").Scan(&days); err != nil || days != "2024-01-02" {
+		t.Fatalf("got days %q (err=%v), want 2024-01-02", days, err)
+	}
+
+	if _, err := OpenDateRange(dir, "events-2006-01-02.db", day(5), day(9)); !errors.Is(err, ErrOpenFailed) {
+		t.Fatalf("expected ErrOpenFailed without matching files, got %v", err)
+	}
+	if _, err := OpenDateRange(dir, "events-2006-01-02.db", day(3), day(1)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for a reversed range, got %v", err)
+	}
+	if _, err := OpenDateRange(dir, "events-2006-01-02.db", day(1), day(3), WithLimit(sqlite3.SQLITE_LIMIT_ATTACHED, 2)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption above the attach limit, got %v", err)
+	}
+	for d := 5; d <= 12; d++ {
+		create(day(d).Format(time.DateOnly), false)
+	}
+	if _, err := OpenDateRange(dir, "events-2006-01-02.db", day(1), day(12)); !errors.Is(err, ErrInvalidConfigOption) || !strings.Contains(err.Error(), "limit of 10") {
+		t.Fatalf("expected ErrInvalidConfigOption for 12 files, got %v", err)
+	}
+}
+
+func TestWithReindexOnOpen(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "reindex.db")
+	collation := func(reverse bool) Option {
+		return WithCollation("custom", func(a, b string) int {
+			if reverse {
+				a, b = b, a
+			}
+			return strings.Compare(a, b)
+		})
+	}
+	ordered := func(db *sql.DB) string {
+		t.Helper()
+		var names string
+		// The index on name supplies the order.
+		if err := db.QueryRow("SELECT group_concat(name, '') FROM (SELECT name FROM test ORDER BY name COLLATE custom)").Scan(&names); err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		return names
+	}
+
+	db, err := OpenReadWriteCreate(fn, collation(true))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE test (name TEXT COLLATE custom); CREATE INDEX test_name ON test (name); INSERT INTO test VALUES ('b'), ('a'), ('c')"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if got := ordered(db); got != "cba" {
+		t.Fatalf("reverse order=%q", got)
+	}
+	db.Close()
+
+	// The comparator changed but the index still holds the old order.
+	db, err = OpenReadWrite(fn, collation(false))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := ordered(db); got != "cba" {
+		t.Fatalf("expected the stale index order, got %q", got)
+	}
+	db.Close()
+
+	db, err = OpenReadWrite(fn, collation(false), WithReindexOnOpen("custom"))
+	if err != nil {
+		t.Fatalf("reopen with reindex: %v", err)
+	}
+	defer db.Close()
+	if got := ordered(db); got != "abc" {
+		t.Fatalf("order after reindex=%q, want abc", got)
+	}
+	var id int
+	if err := db.QueryRow("SELECT rowid FROM test WHERE name = 'a'").Scan(&id); err != nil || id != 2 {
+		t.Fatalf("indexed lookup rowid=%d err=%v", id, err)
+	}
+
+	if _, err := OpenReadWrite(fn, collation(false), WithReindexOnOpen("missing")); !errors.Is(err, ErrOpenFailed) {
+		t.Fatalf("expected ErrOpenFailed for an unknown collation, got %v", err)
+	}
+	if _, err := OpenReadOnly(fn, WithReindexOnOpen("custom")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption on a read-only open, got %v", err)
+	}
+
+	// A slow rebuild is not cut short by the timeout of the quick open steps.
+	orig := openStepTimeout
+	t.Cleanup(func() { openStepTimeout = orig })
+	openStepTimeout = 20 * time.Millisecond
+	slow := WithCollation("custom", func(a, b string) int {
+		time.Sleep(20 * time.Millisecond)
+		return strings.Compare(a, b)
+	})
+	db, err = OpenReadWrite(fn, slow, WithReindexOnOpen("custom"))
+	if err != nil {
+		t.Fatalf("reopen with a slow reindex: %v", err)
+	}
+	db.Close()
+}
+
+func TestForeignKeys_EnforcedFromFirstStatement(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "fk.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE parent (id INTEGER PRIMARY KEY); CREATE TABLE child (parent_id INTEGER REFERENCES parent(id))"); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	db.Close()
+	const orphan = "INSERT INTO child VALUES (42)"
+
+	// Init statements already run with enforcement on.
+	if _, err := OpenReadWrite(fn, WithInitSQL(orphan)); !errors.Is(err, ErrInitSQL) || !strings.Contains(err.Error(), "FOREIGN KEY") {
+		t.Fatalf("expected foreign key failure in init sql, got %v", err)
+	}
+	// So do connect hooks.
+	_, err = OpenReadWrite(fn, WithConnectHook(func(conn *sqlite3.SQLiteConn) error {
+		_, err := conn.Exec(orphan, nil)
+		return err
+	}))
+	if err == nil || !strings.Contains(err.Error(), "FOREIGN KEY") {
+		t.Fatalf("expected foreign key failure in connect hook, got %v", err)
+	}
+
+	// And the very first statement on a brand-new pooled connection.
+	db, err = OpenReadWrite(fn, WithMaxOpenConns(2))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	conns := holdConns(t, db, 2)
+	for _, conn := range conns {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), orphan); err == nil || !strings.Contains(err.Error(), "FOREIGN KEY") {
+			t.Fatalf("expected foreign key failure on fresh connection, got %v", err)
+		}
+	}
+}
+
+func TestWithConnectionInitTimeout(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "inittimeout.db")
+	sleep := func(ms int64) int64 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return ms
+	}
+	slowInit := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200) SELECT sum(sleep_ms(10)) FROM n"
+
+	start := time.Now()
+	_, err := OpenReadWriteCreate(fn,
+		WithFunc("sleep_ms", sleep, false),
+		WithInitSQL(slowInit),
+		WithConnectionInitTimeout(100*time.Millisecond),
+	)
+	elapsed := time.Since(start)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "connection initialization exceeded") {
+		t.Fatalf("expected init timeout, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("open took %v, init was not aborted", elapsed)
+	}
+
+	db, err := OpenReadWriteCreate(fn,
+		WithFunc("sleep_ms", sleep, false),
+		WithInitSQL("SELECT sleep_ms(1)"),
+		WithConnectionInitTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("open within timeout: %v", err)
+	}
+	db.Close()
+}
+
+func TestWithNoFollow_RejectsSymlink(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "real.db")
+	db, err := OpenReadWriteCreate(target)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+	link := filepath.Join(tempDir, "link.db")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	if _, err := OpenReadWrite(link, WithNoFollow()); !errors.Is(err, ErrOpenFailed) || !strings.Contains(err.Error(), "symbolic link") {
+		t.Fatalf("expected symlink rejection, got %v", err)
+	}
+	db, err = OpenReadWrite(target, WithNoFollow())
+	if err != nil {
+		t.Fatalf("regular file: %v", err)
+	}
+	db.Close()
+	// Without the option symlinks are followed as before.
+	db, err = OpenReadWrite(link)
+	if err != nil {
+		t.Fatalf("symlink without nofollow: %v", err)
+	}
+	db.Close()
+
+	// A link earlier in the path is refused by SQLite itself.
+	dirLink := filepath.Join(t.TempDir(), "dir")
+	if err := os.Symlink(tempDir, dirLink); err != nil {
+		t.Fatalf("symlink dir: %v", err)
+	}
+	var sqliteErr sqlite3.Error
+	if _, err := OpenReadWrite(filepath.Join(dirLink, "real.db"), WithOpenFlag(OpenFlagNoFollow)); !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrCantOpen.Extend(6) { // SQLITE_CANTOPEN_SYMLINK
+		t.Fatalf("expected SQLITE_CANTOPEN_SYMLINK for a symlinked directory, got %v", err)
+	}
+	db, err = OpenReadWrite(target, WithOpenFlag(OpenFlagNoFollow))
+	if err != nil {
+		t.Fatalf("regular file with the open flag: %v", err)
+	}
+	db.Close()
+	if _, err := OpenReadWrite(target, WithOpenFlag(0x02000000)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an unsupported flag, got %v", err)
+	}
+	if _, err := OpenMemory(WithNoFollow()); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption in memory, got %v", err)
+	}
+}
+
+func TestWithImmutable_ReadOnlyMedia(t *testing.T) {
+	// Root ignores directory permissions, so the chmod below would not stop SQLite from
+	// creating the -shm file.
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	dir := filepath.Join(t.TempDir(), "media")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	filename := filepath.Join(dir, "test.db")
+	db, err := OpenReadWriteCreate(filename)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY); INSERT INTO test VALUES (1), (2)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	db.Close()
+	// The header still says WAL, but the -wal and -shm files are gone after a clean close.
+	if err := os.Chmod(dir, 0o555); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o755) })
+
+	// Without the option the -shm file cannot be created, so the open fails.
+	if _, err := OpenReadOnly(filename); err == nil {
+		t.Fatal("expected the open to fail without WithImmutable")
+	}
+	db, err = OpenReadOnly(filename, WithImmutable())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("count=%d err=%v want 2", n, err)
+	}
+	if _, err := os.Stat(filename + "-shm"); !os.IsNotExist(err) {
+		t.Fatalf("expected no -shm file, stat err=%v", err)
+	}
+
+	if _, err := OpenReadWrite(filename, WithImmutable()); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for read-write immutable, got %v", err)
+	}
+}
+
+func TestWithoutWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "live.db")
+	writer, err := OpenReadWriteCreate(filename)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer writer.Close()
+	if _, err := writer.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY); INSERT INTO test VALUES (1); PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	// Committed to the WAL only.
+	if _, err := writer.Exec("INSERT INTO test VALUES (2), (3)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	snapshot := func() map[string]string {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("readdir: %v", err)
+		}
+		files := map[string]string{}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				t.Fatalf("stat: %v", err)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			files[e.Name()] = fmt.Sprintf("%d %v %x", info.Size(), info.ModTime(), b)
+		}
+		return files
+	}
+	before := snapshot()
+	if _, ok := before["live.db-shm"]; !ok {
+		t.Fatalf("expected the writer's -shm file, have %v", len(before))
+	}
+
+	db, err := OpenReadOnly(filename, WithoutWALReplay())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("count=%d, want 1 (the checkpointed state)", n)
+	}
+	after := snapshot()
+	if len(after) != len(before) {
+		t.Fatalf("files changed from %d to %d", len(before), len(after))
+	}
+	for name, state := range before {
+		if after[name] != state {
+			t.Fatalf("%s was modified by the read-only open", name)
+		}
+	}
+
+	// Later queries see later checkpoints.
+	if _, err := writer.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("count after checkpoint=%d, want 3", n)
+	}
+
+	if _, err := OpenReadWrite(filename, WithoutWALReplay()); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+}
+
+func TestWithDeterministicRandom(t *testing.T) {
+	tempDir := t.TempDir()
+	sequence := func(name string, seed int64) []string {
+		t.Helper()
+		db, err := OpenReadWriteCreate(filepath.Join(tempDir, name), WithDeterministicRandom(seed))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer db.Close()
+		var out []string
+		for i := 0; i < 5; i++ {
+			var r int64
+			var blob []byte
+			if err := db.QueryRow("SELECT random(), randomblob(8)").Scan(&r, &blob); err != nil {
+				t.Fatalf("random: %v", err)
+			}
+			out = append(out, fmt.Sprintf("%d/%x", r, blob))
+		}
+		return out
+	}
+	a := sequence("a.db", 42)
+	b := sequence("b.db", 42)
+	c := sequence("c.db", 43)
+	if strings.Join(a, ",") != strings.Join(b, ",") {
+		t.Errorf("same seed differs:\n%v\n%v", a, b)
+	}
+	if strings.Join(a, ",") == strings.Join(c, ",") {
+		t.Errorf("different seeds produced identical sequences")
+	}
+	if a[0] == a[1] {
+		t.Errorf("sequence does not advance: %v", a)
+	}
+}
+
+func TestPragmaOrder_PriorityPragmasFirst(t *testing.T) {
+	cfg, err := newOpenConfig(
+		WithTempStore("FILE"),
+		WithPragma("wal_autocheckpoint", "500"),
+		WithPageSize(8192),
+		WithPragma("key", "'secret'"),
+	)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	cfg.setPragma("journal_mode", "WAL")
+	var names []string
+	for _, p := range cfg.orderedPragmas() {
+		names = append(names, p.name)
+	}
+	want := "key,page_size,journal_mode,temp_store,wal_autocheckpoint"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("order=%s want %s", got, want)
+	}
+}
+
+func TestWithChunkSize(t *testing.T) {
+	const chunk = 1 << 20
+	fn := filepath.Join(t.TempDir(), "chunked.db")
+	db, err := OpenReadWriteCreate(fn, WithChunkSize(chunk), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE t (data BLOB);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2500)
+		INSERT INTO t SELECT randomblob(1000) FROM n`); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	// Move the pages into the database file, which grows in whole chunks.
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size() < chunk || info.Size()%chunk != 0 {
+		t.Fatalf("file size %d is not a multiple of the %d byte chunk", info.Size(), chunk)
+	}
+
+	for _, bytes := range []int{0, 4096, 1<<30 + 64<<10} {
+		if _, err := OpenReadWriteCreate(fn, WithChunkSize(bytes)); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Fatalf("chunk size %d: expected ErrInvalidConfigOption, got %v", bytes, err)
+		}
+	}
+}
+
+func TestWithPersistentWAL(t *testing.T) {
+	for _, persist := range []bool{false, true} {
+		fn := filepath.Join(t.TempDir(), "test.db")
+		db, err := OpenReadWriteCreate(fn, WithPersistentWAL(persist))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if _, err := db.Exec("CREATE TABLE t (x INTEGER); INSERT INTO t VALUES (1)"); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		_, err = os.Stat(fn + "-wal")
+		if persist && err != nil {
+			t.Errorf("expected the -wal file to persist: %v", err)
+		}
+		if !persist && !os.IsNotExist(err) {
+			t.Errorf("expected the -wal file to be removed, got %v", err)
+		}
+	}
+}
+
+func TestWithPageSize_AppliedBeforeCreation(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "pagesize.db")
+	db, err := OpenReadWriteCreate(fn, WithPageSize(8192), WithPragma("key", "'secret'"), WithTempStore("FILE"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	var pageSize int
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil || pageSize != 8192 {
+		t.Fatalf("page_size=%d err=%v want 8192", pageSize, err)
+	}
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Fatalf("journal_mode=%s err=%v want wal", journalMode, err)
+	}
+}
+
+func TestPragmaError(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "pragma.db")
+	db, err := OpenReadWriteCreate(fn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+
+	// application_id is stored in the header, so setting it fails on a read-only open.
+	_, err = OpenReadOnly(fn, WithPragma("wal_autocheckpoint", "500"), WithPragma("application_id", "7"))
+	if !errors.Is(err, ErrPragmaExec) {
+		t.Fatalf("expected ErrPragmaExec, got %v", err)
+	}
+	var pragmaErr *PragmaError
+	if !errors.As(err, &pragmaErr) {
+		t.Fatalf("expected a *PragmaError, got %v", err)
+	}
+	if pragmaErr.Name != "application_id" || pragmaErr.Value != "7" {
+		t.Errorf("PragmaError = {%q, %q}, want {application_id, 7}", pragmaErr.Name, pragmaErr.Value)
+	}
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrReadonly {
+		t.Errorf("expected the SQLITE_READONLY cause, got %v", err)
+	}
+}
+
+func TestWithPragma_Validation(t *testing.T) {
+	for _, tc := range []struct{ name, value string }{
+		{"journal_mode", "WAL"},
+		{"wal_autocheckpoint; DROP TABLE x", "1"},
+		{"wal_autocheckpoint", "1; DROP TABLE x"},
+		{"key", "'unterminated"},
+	} {
+		if _, err := newOpenConfig(WithPragma(tc.name, tc.value)); !errors.Is(err, ErrInvalidConfigOption) {
+			t.Errorf("WithPragma(%q, %q): expected invalid option, got %v", tc.name, tc.value, err)
+		}
+	}
+	if _, err := newOpenConfig(WithPragma("key", "'it''s'")); err != nil {
+		t.Errorf("quoted value rejected: %v", err)
+	}
+}
+
+func TestDSN_Deterministic(t *testing.T) {
+	opts := []Option{WithBusyTimeoutSeconds(5), WithCacheSizeMiB(16), WithSynchronous("full"), WithForeignKeys(false)}
+	a, err := DSN("app.db", ModeReadWriteCreate, opts...)
+	if err != nil {
+		t.Fatalf("dsn: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		b, err := DSN("app.db", ModeReadWriteCreate, opts...)
+		if err != nil || a != b {
+			t.Fatalf("dsn differs: %q vs %q (err %v)", a, b, err)
+		}
+	}
+	want := "file:app.db?_busy_timeout=5000&_cache_size=-16384&_foreign_keys=false&_synchronous=FULL&_txlock=immediate&cache=private&mode=rwc"
+	if a != want {
+		t.Errorf("dsn=%q want %q", a, want)
+	}
+
+	ro, err := DSN("app.db", ModeReadOnly)
+	if err != nil {
+		t.Fatalf("dsn ro: %v", err)
+	}
+	if !strings.Contains(ro, "mode=ro") || strings.Contains(ro, "_txlock") || !strings.Contains(ro, "_busy_timeout=10000") {
+		t.Errorf("read-only dsn missing defaults or mode: %q", ro)
+	}
+	if _, err := DSN("", ModeReadOnly); !errors.Is(err, ErrEmptyFilename) {
+		t.Errorf("expected empty filename error, got %v", err)
+	}
+	if _, err := DSN("app.db", Mode("bogus")); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("expected invalid mode error, got %v", err)
+	}
+}
+
+func TestWithProgressHandler_AbortsQuery(t *testing.T) {
+	var abort atomic.Bool
+	var calls atomic.Int64
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "progress.db"), WithProgressHandler(1000, func() bool {
+		calls.Add(1)
+		return abort.Load()
+	}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n"
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		abort.Store(true)
+	}()
+	var n int
+	err = db.QueryRow(endless).Scan(&n)
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrInterrupt {
+		t.Fatalf("expected SQLITE_INTERRUPT, got %v", err)
+	}
+	if calls.Load() == 0 {
+		t.Errorf("progress handler never called")
+	}
+
+	// The handler only aborts when asked to; ordinary queries run normally.
+	abort.Store(false)
+	if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("select: n=%d err=%v", n, err)
+	}
+}
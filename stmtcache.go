@@ -0,0 +1,175 @@
+package sqlitebp
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// PreparedCache memoizes prepared statements by SQL text with an LRU bound.
+//
+// go-sqlite3 has no statement cache of its own, so a hot path that prepares the same
+// query repeatedly pays the SQLite compile cost every time. A *sql.Stmt is safe for
+// concurrent use and database/sql transparently re-prepares it on whichever pooled
+// connection runs it, including connections opened after an earlier one was lost.
+type PreparedCache struct {
+	db   *sql.DB
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cachedStmt, most recently used at the front
+	entries map[string]*list.Element
+	closed  bool
+}
+
+// cachedStmt is a cache entry. refs counts in-progress uses so an evicted statement
+// is only closed once no caller still holds it.
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+// errCacheClosed is returned for uses of a PreparedCache after Close.
+var errCacheClosed = errors.New("sqlitebp: statement cache is closed")
+
+// NewPreparedCache returns a cache holding at most size prepared statements for db.
+func NewPreparedCache(db *sql.DB, size int) (*PreparedCache, error) {
+	if size <= 0 {
+		return nil, errors.Join(ErrInvalidConfigOption, fmt.Errorf("statement cache size must be > 0"))
+	}
+	return &PreparedCache{
+		db:      db,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+// acquire returns the cached entry for query, preparing it on a miss.
+func (c *PreparedCache) acquire(ctx context.Context, query string) (*cachedStmt, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errCacheClosed
+	}
+	if el, ok := c.entries[query]; ok {
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*cachedStmt)
+		entry.refs++
+		c.mu.Unlock()
+		return entry, nil
+	}
+	c.mu.Unlock()
+
+	// Prepare outside the lock; a concurrent miss on the same query may also prepare,
+	// in which case the loser's statement is discarded.
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		stmt.Close()
+		return nil, errCacheClosed
+	}
+	if el, ok := c.entries[query]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(el)
+		entry := el.Value.(*cachedStmt)
+		entry.refs++
+		return entry, nil
+	}
+	entry := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.entries[query] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.evictLocked(oldest)
+	}
+	return entry, nil
+}
+
+// release drops a use of entry, closing it if it was evicted in the meantime.
+func (c *PreparedCache) release(entry *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+func (c *PreparedCache) evictLocked(el *list.Element) {
+	entry := el.Value.(*cachedStmt)
+	c.lru.Remove(el)
+	delete(c.entries, entry.query)
+	entry.evicted = true
+	if entry.refs == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// ExecContext executes query using its cached prepared statement.
+func (c *PreparedCache) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.ExecContext(ctx, args...)
+}
+
+// QueryContext runs query using its cached prepared statement.
+// The returned rows keep the statement alive until they are closed, even if it is evicted.
+func (c *PreparedCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.QueryContext(ctx, args...)
+}
+
+// QueryRowContext runs query using its cached prepared statement and returns at most one row.
+// After Close the row's Scan fails with database/sql's "statement is closed" error.
+func (c *PreparedCache) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		// sql.Row cannot be constructed with an error outside database/sql. For a closed
+		// cache, a statement closed before use makes Scan fail; a failed prepare fails
+		// again in the uncached query, which reports it on Scan.
+		if errors.Is(err, errCacheClosed) {
+			if stmt, err := c.db.PrepareContext(ctx, query); err == nil {
+				stmt.Close()
+				return stmt.QueryRowContext(ctx, args...)
+			}
+		}
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	defer c.release(entry)
+	return entry.stmt.QueryRowContext(ctx, args...)
+}
+
+// Len returns the number of cached statements.
+func (c *PreparedCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close closes all cached statements. It does not close the underlying *sql.DB.
+func (c *PreparedCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.lru.Len() > 0 {
+		c.evictLocked(c.lru.Back())
+	}
+	return nil
+}
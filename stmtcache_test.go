@@ -0,0 +1,116 @@
+package sqlitebp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPreparedCache_ReuseAndBound(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "stmtcache.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY, value TEXT NOT NULL) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	cache, err := NewPreparedCache(db, 2)
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	defer cache.Close()
+
+	const workers, perWorker = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if _, err := cache.ExecContext(ctx, "INSERT INTO test (value) VALUES (?)", fmt.Sprintf("%d-%d", w, i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("insert: %v", err)
+	}
+	if got := cache.Len(); got != 1 {
+		t.Errorf("len=%d want 1", got)
+	}
+
+	var n int
+	if err := cache.QueryRowContext(ctx, "SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != workers*perWorker {
+		t.Fatalf("count=%d err=%v", n, err)
+	}
+	var v string
+	if err := cache.QueryRowContext(ctx, "SELECT value FROM test WHERE id = ?", 1).Scan(&v); err != nil || v == "" {
+		t.Fatalf("value=%q err=%v", v, err)
+	}
+	// Each further distinct query evicts the least recently used one.
+	rows, err := cache.QueryContext(ctx, "SELECT id FROM test ORDER BY id LIMIT 3")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	ids := 0
+	for rows.Next() {
+		ids++
+	}
+	rows.Close()
+	if ids != 3 {
+		t.Errorf("ids=%d want 3", ids)
+	}
+	if got := cache.Len(); got != 2 {
+		t.Errorf("len=%d want 2 (bounded)", got)
+	}
+	if _, err := cache.ExecContext(ctx, "INSERT INTO test (value) VALUES (?)", "after-evict"); err != nil {
+		t.Fatalf("re-prepare after eviction: %v", err)
+	}
+}
+
+func TestPreparedCache_InvalidSize(t *testing.T) {
+	if _, err := NewPreparedCache(nil, 0); err == nil {
+		t.Fatalf("expected error for size 0")
+	}
+}
+
+func TestPreparedCache_UseAfterClose(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "stmtcache.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	cache, err := NewPreparedCache(db, 2)
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	var n int
+	if err := cache.QueryRowContext(ctx, "SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("n=%d err=%v", n, err)
+	}
+	cache.Close()
+
+	if _, err := cache.ExecContext(ctx, "SELECT 1"); err == nil {
+		t.Fatal("expected ExecContext to fail after Close")
+	}
+	if _, err := cache.QueryContext(ctx, "SELECT 1"); err == nil {
+		t.Fatal("expected QueryContext to fail after Close")
+	}
+	if err := cache.QueryRowContext(ctx, "SELECT 1").Scan(&n); err == nil {
+		t.Fatal("expected QueryRowContext to fail after Close")
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Len=%d after Close", cache.Len())
+	}
+}
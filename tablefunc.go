@@ -0,0 +1,52 @@
+//go:build sqlite_vtable || vtable
+
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TableModule is the module type of WithTableFunc and Config.TableFuncs.
+type TableModule = sqlite3.Module
+
+// tableFuncOption is WithTableFunc, for Config.Options.
+func tableFuncOption(name string, module TableModule) Option {
+	return WithTableFunc(name, module)
+}
+
+// WithTableFunc registers module as the virtual table module name on each new connection,
+// before anything that might use it. It requires the sqlite_vtable build tag, which
+// compiles go-sqlite3's virtual table support.
+//
+// A module that implements sqlite3.EponymousOnlyModule is a table-valued function: it is
+// used directly in a FROM clause under its own name, without CREATE VIRTUAL TABLE, and its
+// arguments are matched to the HIDDEN columns declared by Connect:
+//
+//	// Connect: c.DeclareVTab("CREATE TABLE x(value INTEGER, start HIDDEN, stop HIDDEN)")
+//	rows, err := db.Query("SELECT value FROM series(1, 10)")
+//
+// BestIndex must mark the equality constraints on those columns as used for Filter to
+// receive the argument values. Other modules back tables created with CREATE VIRTUAL TABLE
+// name USING module(...), which must then be registered by every handle that opens the
+// database. module is shared by all of the handle's connections, so it must be safe for
+// concurrent use; DestroyModule is called as each connection closes.
+func WithTableFunc(name string, module sqlite3.Module) Option {
+	return func(c *openConfig) error {
+		if name == "" || module == nil {
+			return errors.Join(ErrInvalidConfigOption, fmt.Errorf("table function name and module are required"))
+		}
+		for _, tf := range c.tableFuncs {
+			if strings.EqualFold(tf.name, name) {
+				return errors.Join(ErrInvalidConfigOption, fmt.Errorf("table function %q already specified", name))
+			}
+		}
+		c.tableFuncs = append(c.tableFuncs, tableFunc{name: name, register: func(conn *sqlite3.SQLiteConn) error {
+			return conn.CreateModule(name, module)
+		}})
+		return nil
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !(sqlite_vtable || vtable)
+
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TableModule stands in for sqlite3.Module, which go-sqlite3 only defines with the
+// sqlite_vtable build tag; without it Config.TableFuncs cannot be used.
+type TableModule interface {
+	DestroyModule()
+}
+
+// tableFuncOption fails the open: table functions require the sqlite_vtable build tag.
+func tableFuncOption(name string, module TableModule) Option {
+	return func(*openConfig) error {
+		return errors.Join(ErrFeatureUnavailable, fmt.Errorf("table function %q requires the sqlite_vtable build tag", name))
+	}
+}
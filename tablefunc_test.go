@@ -0,0 +1,135 @@
+//go:build sqlite_vtable || vtable
+
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Run with:
+//
+//	go test -tags sqlite_vtable ./...
+
+// seriesModule is series(start, stop), the integers from start to stop.
+type seriesModule struct{}
+
+func (seriesModule) EponymousOnlyModule() {}
+
+func (m seriesModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(c, args)
+}
+
+func (seriesModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	if err := c.DeclareVTab("CREATE TABLE x(value INTEGER, start HIDDEN, stop HIDDEN)"); err != nil {
+		return nil, err
+	}
+	return seriesTable{}, nil
+}
+
+func (seriesModule) DestroyModule() {}
+
+type seriesTable struct{}
+
+// BestIndex passes the start and stop arguments to Filter, recording in IdxStr which
+// column each value belongs to. Plans without both are made too costly to be chosen.
+func (seriesTable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	used := make([]bool, len(cst))
+	var cols []byte
+	for i, c := range cst {
+		if c.Usable && c.Op == sqlite3.OpEQ && (c.Column == 1 || c.Column == 2) {
+			used[i] = true
+			cols = append(cols, byte('0'+c.Column))
+		}
+	}
+	cost := 1.0
+	if len(cols) != 2 {
+		cost = 1e99
+	}
+	return &sqlite3.IndexResult{Used: used, IdxStr: string(cols), EstimatedCost: cost}, nil
+}
+
+func (seriesTable) Disconnect() error { return nil }
+
+func (seriesTable) Destroy() error { return nil }
+
+func (seriesTable) Open() (sqlite3.VTabCursor, error) { return &seriesCursor{}, nil }
+
+type seriesCursor struct {
+	value, stop int64
+}
+
+func (c *seriesCursor) Filter(idxNum int, idxStr string, vals []any) error {
+	if len(idxStr) != 2 {
+		return errors.New("series requires start and stop")
+	}
+	for i, v := range vals {
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("series argument %v is not an integer", v)
+		}
+		if idxStr[i] == '1' {
+			c.value = n
+		} else {
+			c.stop = n
+		}
+	}
+	return nil
+}
+
+func (c *seriesCursor) Next() error { c.value++; return nil }
+
+func (c *seriesCursor) EOF() bool { return c.value > c.stop }
+
+func (c *seriesCursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	ctx.ResultInt64(c.value)
+	return nil
+}
+
+func (c *seriesCursor) Rowid() (int64, error) { return c.value, nil }
+
+func (c *seriesCursor) Close() error { return nil }
+
+func TestWithTableFunc(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "series.db"), WithTableFunc("series", seriesModule{}))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	var count, sum int64
+	if err := db.QueryRow("SELECT count(*), sum(value) FROM series(1, 100)").Scan(&count, &sum); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if count != 100 || sum != 5050 {
+		t.Fatalf("count=%d sum=%d, want 100 and 5050", count, sum)
+	}
+	// Arguments can come from the outer query.
+	if _, err := db.Exec("CREATE TABLE ranges (lo INTEGER, hi INTEGER) STRICT; INSERT INTO ranges VALUES (1, 3), (10, 11)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	var values string
+	if err := db.QueryRow("SELECT group_concat(value, ',') FROM (SELECT value FROM ranges, series(ranges.lo, ranges.hi) ORDER BY value)").Scan(&values); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if values != "1,2,3,10,11" {
+		t.Fatalf("joined values %q, want 1,2,3,10,11", values)
+	}
+
+	if _, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "dup.db"), WithTableFunc("series", seriesModule{}), WithTableFunc("SERIES", seriesModule{})); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption, got %v", err)
+	}
+
+	fromConfig, err := OpenWithConfig(filepath.Join(t.TempDir(), "config.db"), ModeReadWriteCreate, Config{TableFuncs: map[string]TableModule{"series": seriesModule{}}})
+	if err != nil {
+		t.Fatalf("open from config: %v", err)
+	}
+	defer fromConfig.Close()
+	if err := fromConfig.QueryRow("SELECT count(*) FROM series(1, 5)").Scan(&count); err != nil || count != 5 {
+		t.Fatalf("count=%d err=%v, want 5", count, err)
+	}
+}
@@ -0,0 +1,101 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrInvalidSavepointName indicates a savepoint name that is not a plain identifier.
+var ErrInvalidSavepointName = errors.New("sqlitebp: invalid savepoint name")
+
+// savepointName restricts savepoint names to plain identifiers since they cannot be bound as parameters.
+var savepointName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Transaction runs fn inside a transaction on db, committing if fn returns nil and
+// rolling back if it returns an error or panics.
+func Transaction(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snapshot runs fn inside a read-only transaction on a single connection of db, so that
+// every read in fn sees the database as of one point in time, e.g. for a long analytical
+// scan. The transaction is DEFERRED even on write-capable handles (whose transactions are
+// otherwise IMMEDIATE, see WithTransactionLock), so it never takes the write lock and
+// writes in fn fail. It is always rolled back.
+//
+// In WAL mode a read transaction reads from the snapshot taken at its first read, which
+// Snapshot makes before calling fn, and writers are not blocked while it is open; they
+// only keep checkpoints from moving past it, so the WAL grows until it ends. In rollback
+// journal modes it instead holds a SHARED lock that keeps writers from committing.
+func Snapshot(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) (err error) {
+	// The connection wrapper begins it like a read-only transaction under WithTxOptions:
+	// BEGIN DEFERRED with query_only set until it ends.
+	tx, err := db.BeginTx(context.WithValue(ctx, snapshotKey{}, true), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p := recover()
+		tx.Rollback()
+		if p != nil {
+			panic(p)
+		}
+	}()
+	// BEGIN DEFERRED starts no read transaction until the first read.
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM sqlite_schema LIMIT 1"); err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
+// Savepoint runs fn inside a SAVEPOINT on tx, giving database/sql a nested transaction.
+// If fn returns nil the savepoint is released and its changes become part of tx.
+// If fn returns an error (or panics) only the changes made since the savepoint are
+// rolled back; tx itself stays open and can still commit. Savepoints may be nested.
+// name must be a plain identifier ([A-Za-z_][A-Za-z0-9_]*).
+func Savepoint(ctx context.Context, tx *sql.Tx, name string, fn func(*sql.Tx) error) (err error) {
+	if !savepointName.MatchString(name) {
+		return errors.Join(ErrInvalidSavepointName, fmt.Errorf("savepoint name %q", name))
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	defer func() {
+		p := recover()
+		if p != nil || err != nil {
+			// ROLLBACK TO leaves the savepoint on the stack; RELEASE removes it.
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO "+name); rbErr != nil {
+				err = errors.Join(err, rbErr)
+			} else if _, relErr := tx.ExecContext(ctx, "RELEASE "+name); relErr != nil {
+				err = errors.Join(err, relErr)
+			}
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE "+name)
+	return err
+}
@@ -0,0 +1,239 @@
+package sqlitebp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavepoint_InnerFailureOuterCommits(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "savepoint.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	errInner := errors.New("inner failed")
+	err = Transaction(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO test (value) VALUES ('outer')"); err != nil {
+			return err
+		}
+		if err := Savepoint(ctx, tx, "kept", func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO test (value) VALUES ('kept')")
+			return err
+		}); err != nil {
+			return err
+		}
+		err := Savepoint(ctx, tx, "outer_sp", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("INSERT INTO test (value) VALUES ('discarded')"); err != nil {
+				return err
+			}
+			return Savepoint(ctx, tx, "inner_sp", func(tx *sql.Tx) error {
+				if _, err := tx.Exec("INSERT INTO test (value) VALUES ('discarded-nested')"); err != nil {
+					return err
+				}
+				return errInner
+			})
+		})
+		if !errors.Is(err, errInner) {
+			t.Errorf("savepoint err=%v want %v", err, errInner)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+
+	rows, err := db.Query("SELECT value FROM test ORDER BY rowid")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[0] != "outer" || got[1] != "kept" {
+		t.Errorf("rows=%v want [outer kept]", got)
+	}
+}
+
+func TestTransaction_RollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "rollback.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (value TEXT) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	errFail := errors.New("fail")
+	if err := Transaction(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO test (value) VALUES ('x')"); err != nil {
+			return err
+		}
+		return errFail
+	}); !errors.Is(err, errFail) {
+		t.Fatalf("err=%v want %v", err, errFail)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("count=%d err=%v", n, err)
+	}
+}
+
+func TestSavepoint_InvalidName(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "spname.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	err = Transaction(ctx, db, func(tx *sql.Tx) error {
+		return Savepoint(ctx, tx, "x; DROP TABLE y", func(*sql.Tx) error { return nil })
+	})
+	if !errors.Is(err, ErrInvalidSavepointName) {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+func TestWithTxOptions(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "tx.db"),
+		WithTxOptions(), WithTransactionLock("DEFERRED"), WithBusyTimeoutSeconds(0), WithMaxOpenConns(2))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+
+	// A read-only transaction can read but not write.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("begin read-only: %v", err)
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatalf("read in read-only tx: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit read-only: %v", err)
+	}
+	// query_only is cleared again for later users of the connection.
+	for i := 0; i < 4; i++ {
+		if _, err := db.Exec("INSERT INTO t VALUES (?)", i); err != nil {
+			t.Fatalf("write after read-only tx: %v", err)
+		}
+	}
+
+	// holdsWriteLock reports whether tx keeps other connections from writing.
+	holdsWriteLock := func(tx *sql.Tx) bool {
+		t.Helper()
+		defer tx.Rollback()
+		if _, err := tx.Exec("SELECT 1"); err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("conn: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return true
+		}
+		conn.ExecContext(ctx, "ROLLBACK")
+		return false
+	}
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatalf("begin serializable: %v", err)
+	}
+	if !holdsWriteLock(tx) {
+		t.Errorf("serializable transaction did not take the write lock")
+	}
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin default: %v", err)
+	}
+	if holdsWriteLock(tx) {
+		t.Errorf("default transaction took the write lock despite DEFERRED")
+	}
+
+	if _, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelWriteCommitted}); err == nil {
+		t.Errorf("expected an error for an unsupported isolation level")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	// A short busy timeout: an IMMEDIATE snapshot would make the writer fail quickly.
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "snapshot.db"), WithBusyTimeoutSeconds(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE test (id INTEGER PRIMARY KEY) STRICT; INSERT INTO test VALUES (1), (2), (3)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	count := func(q interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}) int {
+		t.Helper()
+		var n int
+		if err := q.QueryRowContext(ctx, "SELECT count(*) FROM test").Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+
+	err = Snapshot(ctx, db, func(tx *sql.Tx) error {
+		if n := count(tx); n != 3 {
+			t.Errorf("snapshot count=%d before the write, want 3", n)
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO test VALUES (4), (5)"); err != nil {
+			return err
+		}
+		if n := count(tx); n != 3 {
+			t.Errorf("snapshot count=%d after the write, want 3", n)
+		}
+		if n := count(db); n != 5 {
+			t.Errorf("fresh count=%d, want 5", n)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO test VALUES (6)"); err == nil {
+			t.Errorf("expected a write in the snapshot to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	// The connection went back to the pool writable.
+	db.SetMaxOpenConns(1)
+	if err := Transaction(ctx, db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO test VALUES (6)")
+		return err
+	}); err != nil {
+		t.Fatalf("write after snapshot: %v", err)
+	}
+	failed := errors.New("failed")
+	if err := Snapshot(ctx, db, func(*sql.Tx) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("expected fn's error, got %v", err)
+	}
+}
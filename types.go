@@ -0,0 +1,86 @@
+package sqlitebp
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// UnixTime scans an INTEGER column holding Unix epoch seconds into a time.Time, and binds
+// back as epoch seconds. It is meant for STRICT tables, which cannot declare the DATETIME or
+// TIMESTAMP column types go-sqlite3 uses to convert integers to time.Time automatically.
+// NULL scans as the zero time; the zero time binds as NULL.
+//
+//	var created sqlitebp.UnixTime
+//	err := db.QueryRow("SELECT created_at FROM events WHERE id = ?", id).Scan(&created)
+//	fmt.Println(created.Time)
+type UnixTime struct {
+	time.Time
+}
+
+// Scan implements sql.Scanner.
+func (t *UnixTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case int64:
+		t.Time = time.Unix(v, 0).UTC()
+	case float64:
+		sec := int64(v)
+		t.Time = time.Unix(sec, int64((v-float64(sec))*1e9)).UTC()
+	case time.Time:
+		t.Time = v
+	default:
+		return fmt.Errorf("sqlitebp: cannot scan %T into UnixTime", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (t UnixTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.Unix(), nil
+}
+
+// Int64Array binds a list of integers as a single parameter, as a JSON array that the
+// json_each table-valued function expands back into rows. It replaces an IN list with one
+// placeholder per value, which is limited to SQLITE_LIMIT_VARIABLE_NUMBER parameters and
+// makes every list length a different statement:
+//
+//	rows, err := db.Query("SELECT name FROM users WHERE id IN (SELECT value FROM json_each(?))", sqlitebp.Int64Array(ids))
+//
+// SQLite's carray extension, which binds a C array through the pointer-passing interface,
+// is not compiled into go-sqlite3, and the driver cannot bind pointers.
+type Int64Array []int64
+
+// Value implements driver.Valuer.
+func (a Int64Array) Value() (driver.Value, error) {
+	b := make([]byte, 0, 2+len(a)*8)
+	b = append(b, '[')
+	for i, v := range a {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendInt(b, v, 10)
+	}
+	return string(append(b, ']')), nil
+}
+
+// StringArray is Int64Array for a list of strings.
+type StringArray []string
+
+// Value implements driver.Valuer.
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(a))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
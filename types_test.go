@@ -0,0 +1,76 @@
+package sqlitebp
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixTime_ScanEpochSeconds(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "unixtime.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE events (id INTEGER PRIMARY KEY, created_at INTEGER) STRICT"); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	want := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	if _, err := db.Exec("INSERT INTO events (id, created_at) VALUES (1, ?), (2, NULL), (3, ?)", want.Unix(), UnixTime{want.Add(time.Hour)}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	var got UnixTime
+	if err := db.QueryRow("SELECT created_at FROM events WHERE id = 1").Scan(&got); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %v want %v", got.Time, want)
+	}
+	if err := db.QueryRow("SELECT created_at FROM events WHERE id = 2").Scan(&got); err != nil || !got.IsZero() {
+		t.Errorf("null: got %v err %v", got.Time, err)
+	}
+	var raw int64
+	if err := db.QueryRow("SELECT created_at FROM events WHERE id = 3").Scan(&raw); err != nil || raw != want.Add(time.Hour).Unix() {
+		t.Errorf("bound value=%d err=%v", raw, err)
+	}
+	if err := got.Scan("not a time"); err == nil {
+		t.Errorf("expected error scanning a string")
+	}
+}
+
+func TestInt64Array_BindsAsOneParameter(t *testing.T) {
+	db, err := OpenReadWriteCreate(filepath.Join(t.TempDir(), "array.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL) STRICT;
+		INSERT INTO items
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 50000)
+		SELECT i, 'item ' || i FROM n`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	// 10k even ids from 40002, half of them past the last row, in one parameter.
+	ids := make(Int64Array, 0, 10000)
+	for i := int64(1); len(ids) < 10000; i++ {
+		ids = append(ids, 2*i+40000)
+	}
+	var n, sum int64
+	if err := db.QueryRow("SELECT count(*), coalesce(sum(id), 0) FROM items WHERE id IN (SELECT value FROM json_each(?))", ids).Scan(&n, &sum); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if n != 5000 || sum != 5000*(40002+50000)/2 {
+		t.Errorf("count=%d sum=%d, want 5000 rows", n, sum)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM items WHERE id IN (SELECT value FROM json_each(?))", Int64Array(nil)).Scan(&n); err != nil || n != 0 {
+		t.Errorf("empty array: count=%d err=%v", n, err)
+	}
+
+	names := StringArray{"item 1", `it"em`, "item 3"}
+	if err := db.QueryRow("SELECT count(*) FROM items WHERE name IN (SELECT value FROM json_each(?))", names).Scan(&n); err != nil || n != 2 {
+		t.Errorf("strings: count=%d err=%v, want 2", n, err)
+	}
+}
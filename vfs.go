@@ -0,0 +1,521 @@
+package sqlitebp
+
+// A bridge from SQLite's VFS interface to Go, for WithVFSImplementation, and the shim over
+// the default VFS that WithNoFollow opens files with. The structs below mirror sqlite3_vfs
+// (version 2), sqlite3_io_methods (version 1) and sqlite3_file; files get no shared-memory
+// methods, so databases on a Go VFS cannot use WAL. The DlOpen family, randomness,
+// sleeping and the clock are served by the default VFS.
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef struct bp_file bp_file;
+typedef struct bp_vfs bp_vfs;
+
+typedef struct bp_io_methods {
+	int iVersion;
+	int (*xClose)(bp_file*);
+	int (*xRead)(bp_file*, void*, int, long long);
+	int (*xWrite)(bp_file*, const void*, int, long long);
+	int (*xTruncate)(bp_file*, long long);
+	int (*xSync)(bp_file*, int);
+	int (*xFileSize)(bp_file*, long long*);
+	int (*xLock)(bp_file*, int);
+	int (*xUnlock)(bp_file*, int);
+	int (*xCheckReservedLock)(bp_file*, int*);
+	int (*xFileControl)(bp_file*, int, void*);
+	int (*xSectorSize)(bp_file*);
+	int (*xDeviceCharacteristics)(bp_file*);
+} bp_io_methods;
+
+// sqlite3_file followed by the handle of the Go VFSFile.
+struct bp_file {
+	const bp_io_methods *pMethods;
+	uintptr_t handle;
+};
+
+struct bp_vfs {
+	int iVersion;
+	int szOsFile;
+	int mxPathname;
+	bp_vfs *pNext;
+	const char *zName;
+	void *pAppData;
+	int (*xOpen)(bp_vfs*, const char*, bp_file*, int, int*);
+	int (*xDelete)(bp_vfs*, const char*, int);
+	int (*xAccess)(bp_vfs*, const char*, int, int*);
+	int (*xFullPathname)(bp_vfs*, const char*, int, char*);
+	void *(*xDlOpen)(bp_vfs*, const char*);
+	void (*xDlError)(bp_vfs*, int, char*);
+	void (*(*xDlSym)(bp_vfs*, void*, const char*))(void);
+	void (*xDlClose)(bp_vfs*, void*);
+	int (*xRandomness)(bp_vfs*, int, char*);
+	int (*xSleep)(bp_vfs*, int);
+	int (*xCurrentTime)(bp_vfs*, double*);
+	int (*xGetLastError)(bp_vfs*, int, char*);
+	int (*xCurrentTimeInt64)(bp_vfs*, long long*);
+};
+
+extern bp_vfs *sqlite3_vfs_find(const char*);
+extern int sqlite3_vfs_register(bp_vfs*, int);
+
+extern int goVFSOpen(uintptr_t, char*, int, int*, uintptr_t*);
+extern int goVFSDelete(uintptr_t, char*, int);
+extern int goVFSAccess(uintptr_t, char*, int, int*);
+extern int goVFSFullPathname(uintptr_t, char*, int, char*);
+extern int goFileClose(uintptr_t);
+extern int goFileRead(uintptr_t, void*, int, long long);
+extern int goFileWrite(uintptr_t, void*, int, long long);
+extern int goFileTruncate(uintptr_t, long long);
+extern int goFileSync(uintptr_t, int);
+extern int goFileSize(uintptr_t, long long*);
+extern int goFileLock(uintptr_t, int);
+extern int goFileUnlock(uintptr_t, int);
+extern int goFileCheckReservedLock(uintptr_t, int*);
+extern int goFileSectorSize(uintptr_t);
+extern int goFileDeviceCharacteristics(uintptr_t);
+
+static bp_vfs *bp_base;
+
+static int bp_file_close(bp_file *f) { return goFileClose(f->handle); }
+static int bp_file_read(bp_file *f, void *p, int n, long long off) { return goFileRead(f->handle, p, n, off); }
+static int bp_file_write(bp_file *f, const void *p, int n, long long off) { return goFileWrite(f->handle, (void*)p, n, off); }
+static int bp_file_truncate(bp_file *f, long long size) { return goFileTruncate(f->handle, size); }
+static int bp_file_sync(bp_file *f, int flags) { return goFileSync(f->handle, flags); }
+static int bp_file_size(bp_file *f, long long *size) { return goFileSize(f->handle, size); }
+static int bp_file_lock(bp_file *f, int level) { return goFileLock(f->handle, level); }
+static int bp_file_unlock(bp_file *f, int level) { return goFileUnlock(f->handle, level); }
+static int bp_file_check_reserved_lock(bp_file *f, int *out) { return goFileCheckReservedLock(f->handle, out); }
+// SQLITE_NOTFOUND: no file controls are implemented.
+static int bp_file_control(bp_file *f, int op, void *arg) { return 12; }
+static int bp_file_sector_size(bp_file *f) { return goFileSectorSize(f->handle); }
+static int bp_file_device_characteristics(bp_file *f) { return goFileDeviceCharacteristics(f->handle); }
+
+static const bp_io_methods bp_io = {
+	1,
+	bp_file_close,
+	bp_file_read,
+	bp_file_write,
+	bp_file_truncate,
+	bp_file_sync,
+	bp_file_size,
+	bp_file_lock,
+	bp_file_unlock,
+	bp_file_check_reserved_lock,
+	bp_file_control,
+	bp_file_sector_size,
+	bp_file_device_characteristics,
+};
+
+// SQLite only calls xClose on files whose pMethods is set, so it stays NULL on failure.
+static int bp_open(bp_vfs *vfs, const char *name, bp_file *f, int flags, int *outFlags) {
+	uintptr_t handle = 0;
+	int out = flags;
+	f->pMethods = 0;
+	int rc = goVFSOpen((uintptr_t)vfs->pAppData, (char*)name, flags, &out, &handle);
+	if (rc != 0) {
+		return rc;
+	}
+	f->pMethods = &bp_io;
+	f->handle = handle;
+	if (outFlags) {
+		*outFlags = out;
+	}
+	return 0;
+}
+
+static int bp_delete(bp_vfs *vfs, const char *name, int syncDir) {
+	return goVFSDelete((uintptr_t)vfs->pAppData, (char*)name, syncDir);
+}
+
+static int bp_access(bp_vfs *vfs, const char *name, int flags, int *out) {
+	return goVFSAccess((uintptr_t)vfs->pAppData, (char*)name, flags, out);
+}
+
+static int bp_full_pathname(bp_vfs *vfs, const char *name, int n, char *out) {
+	return goVFSFullPathname((uintptr_t)vfs->pAppData, (char*)name, n, out);
+}
+
+static void *bp_dlopen(bp_vfs *vfs, const char *name) { return bp_base->xDlOpen(bp_base, name); }
+static void bp_dlerror(bp_vfs *vfs, int n, char *out) { bp_base->xDlError(bp_base, n, out); }
+static void (*bp_dlsym(bp_vfs *vfs, void *lib, const char *sym))(void) { return bp_base->xDlSym(bp_base, lib, sym); }
+static void bp_dlclose(bp_vfs *vfs, void *lib) { bp_base->xDlClose(bp_base, lib); }
+static int bp_randomness(bp_vfs *vfs, int n, char *out) { return bp_base->xRandomness(bp_base, n, out); }
+static int bp_sleep(bp_vfs *vfs, int micros) { return bp_base->xSleep(bp_base, micros); }
+static int bp_current_time(bp_vfs *vfs, double *out) { return bp_base->xCurrentTime(bp_base, out); }
+static int bp_get_last_error(bp_vfs *vfs, int n, char *out) { return bp_base->xGetLastError(bp_base, n, out); }
+static int bp_current_time_int64(bp_vfs *vfs, long long *out) { return bp_base->xCurrentTimeInt64(bp_base, out); }
+
+// bp_register_vfs registers a VFS named name (which must outlive it) backed by the Go
+// VFS behind handle. It never becomes the default.
+static int bp_register_vfs(const char *name, uintptr_t handle) {
+	if (bp_base == 0) {
+		bp_base = sqlite3_vfs_find(0);
+	}
+	bp_vfs *vfs = calloc(1, sizeof(bp_vfs));
+	if (vfs == 0) {
+		return 7;
+	}
+	vfs->iVersion = 2;
+	vfs->szOsFile = sizeof(bp_file);
+	vfs->mxPathname = bp_base->mxPathname;
+	vfs->zName = name;
+	vfs->pAppData = (void*)handle;
+	vfs->xOpen = bp_open;
+	vfs->xDelete = bp_delete;
+	vfs->xAccess = bp_access;
+	vfs->xFullPathname = bp_full_pathname;
+	vfs->xDlOpen = bp_dlopen;
+	vfs->xDlError = bp_dlerror;
+	vfs->xDlSym = bp_dlsym;
+	vfs->xDlClose = bp_dlclose;
+	vfs->xRandomness = bp_randomness;
+	vfs->xSleep = bp_sleep;
+	vfs->xCurrentTime = bp_current_time;
+	vfs->xGetLastError = bp_get_last_error;
+	vfs->xCurrentTimeInt64 = bp_current_time_int64;
+	int rc = sqlite3_vfs_register(vfs, 0);
+	if (rc != 0) {
+		free(vfs);
+	}
+	return rc;
+}
+
+static bp_vfs bp_nofollow_vfs;
+
+// Does what SQLITE_OPEN_NOFOLLOW does in sqlite3PagerOpen: the default VFS reports a path
+// that resolved through a symbolic link with SQLITE_OK_SYMLINK (512), which becomes
+// SQLITE_CANTOPEN_SYMLINK (1550). The unix VFS then opens the resolved path with O_NOFOLLOW.
+static int bp_nofollow_full_pathname(bp_vfs *vfs, const char *name, int n, char *out) {
+	int rc = bp_base->xFullPathname(bp_base, name, n, out);
+	return rc == 512 ? 1550 : rc;
+}
+
+static int bp_nofollow_open(bp_vfs *vfs, const char *name, bp_file *f, int flags, int *outFlags) {
+	return bp_base->xOpen(bp_base, name, f, flags, outFlags);
+}
+
+static int bp_nofollow_delete(bp_vfs *vfs, const char *name, int syncDir) {
+	return bp_base->xDelete(bp_base, name, syncDir);
+}
+
+static int bp_nofollow_access(bp_vfs *vfs, const char *name, int flags, int *out) {
+	return bp_base->xAccess(bp_base, name, flags, out);
+}
+
+// bp_register_nofollow_vfs registers a VFS named name (which must outlive it) that is the
+// default VFS but refuses paths through symbolic links.
+static int bp_register_nofollow_vfs(const char *name) {
+	if (bp_base == 0) {
+		bp_base = sqlite3_vfs_find(0);
+	}
+	bp_nofollow_vfs = *bp_base;
+	bp_nofollow_vfs.iVersion = 2;
+	bp_nofollow_vfs.pNext = 0;
+	bp_nofollow_vfs.zName = name;
+	bp_nofollow_vfs.xOpen = bp_nofollow_open;
+	bp_nofollow_vfs.xDelete = bp_nofollow_delete;
+	bp_nofollow_vfs.xAccess = bp_nofollow_access;
+	bp_nofollow_vfs.xFullPathname = bp_nofollow_full_pathname;
+	bp_nofollow_vfs.xDlOpen = bp_dlopen;
+	bp_nofollow_vfs.xDlError = bp_dlerror;
+	bp_nofollow_vfs.xDlSym = bp_dlsym;
+	bp_nofollow_vfs.xDlClose = bp_dlclose;
+	bp_nofollow_vfs.xRandomness = bp_randomness;
+	bp_nofollow_vfs.xSleep = bp_sleep;
+	bp_nofollow_vfs.xCurrentTime = bp_current_time;
+	bp_nofollow_vfs.xGetLastError = bp_get_last_error;
+	bp_nofollow_vfs.xCurrentTimeInt64 = bp_current_time_int64;
+	return sqlite3_vfs_register(&bp_nofollow_vfs, 0);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// VFS is a storage backend for SQLite written in Go, see WithVFSImplementation. Its
+// methods mirror those of the sqlite3vfs package, so adapting an implementation written
+// for it is mechanical. Methods may be called concurrently by different connections.
+//
+// Errors are reported to SQLite as the sqlite3.Error they wrap, if any, and otherwise as
+// the I/O error code matching the method (SQLITE_CANTOPEN for Open, SQLITE_BUSY for
+// VFSFile.Lock).
+type VFS interface {
+	// Open opens the file name with SQLITE_OPEN_* flags, returning the flags it was
+	// actually opened with. The name is empty for temporary files, which are opened
+	// with SQLITE_OPEN_DELETEONCLOSE.
+	Open(name string, flags int) (VFSFile, int, error)
+	// Delete removes the file name. Removing a missing file should return an error
+	// wrapping fs.ErrNotExist.
+	Delete(name string, dirSync bool) error
+	// Access reports whether name exists (flags 0), is readable and writable (1) or
+	// is readable (2).
+	Access(name string, flags int) (bool, error)
+	// FullPathname returns the canonical form of name, which Open is called with.
+	FullPathname(name string) string
+}
+
+// VFSFile is a file opened by a VFS. It must not retain the slices passed to ReadAt
+// and WriteAt, which point into SQLite's memory.
+type VFSFile interface {
+	Close() error
+	// ReadAt follows io.ReaderAt; reads past the end of the file are short.
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Truncate(size int64) error
+	Sync(flags int) error
+	FileSize() (int64, error)
+	// Lock raises the lock to level (SQLITE_LOCK_SHARED to _EXCLUSIVE, 1 to 4) and
+	// Unlock lowers it to level (SQLITE_LOCK_NONE or _SHARED, 0 or 1).
+	Lock(level int) error
+	Unlock(level int) error
+	// CheckReservedLock reports whether any connection holds a RESERVED or higher lock.
+	CheckReservedLock() (bool, error)
+	SectorSize() int
+	// DeviceCharacteristics returns SQLITE_IOCAP_* flags, 0 if none apply.
+	DeviceCharacteristics() int
+}
+
+// SQLite result codes returned by the bridge.
+const (
+	vfsCantOpen           = 14   // SQLITE_CANTOPEN
+	vfsBusy               = 5    // SQLITE_BUSY
+	vfsIOErrRead          = 266  // SQLITE_IOERR_READ
+	vfsIOErrShortRead     = 522  // SQLITE_IOERR_SHORT_READ
+	vfsIOErrWrite         = 778  // SQLITE_IOERR_WRITE
+	vfsIOErrFsync         = 1034 // SQLITE_IOERR_FSYNC
+	vfsIOErrTruncate      = 1546 // SQLITE_IOERR_TRUNCATE
+	vfsIOErrFstat         = 1802 // SQLITE_IOERR_FSTAT
+	vfsIOErrUnlock        = 2058 // SQLITE_IOERR_UNLOCK
+	vfsIOErrDelete        = 2570 // SQLITE_IOERR_DELETE
+	vfsIOErrAccess        = 3338 // SQLITE_IOERR_ACCESS
+	vfsIOErrCheckReserved = 3594 // SQLITE_IOERR_CHECKRESERVEDLOCK
+	vfsIOErrClose         = 4106 // SQLITE_IOERR_CLOSE
+	vfsIOErrDeleteNoEnt   = 5898 // SQLITE_IOERR_DELETE_NOENT
+)
+
+var (
+	vfsMu   sync.Mutex
+	vfsImpl = map[string]VFS{} // registered by registerVFS, never removed
+)
+
+// registerVFS registers vfs with SQLite under name. Registering the same implementation
+// under the same name again does nothing; SQLite keeps VFSes for the life of the process.
+func registerVFS(name string, vfs VFS) error {
+	vfsMu.Lock()
+	defer vfsMu.Unlock()
+	if existing, ok := vfsImpl[name]; ok {
+		if !sameVFS(existing, vfs) {
+			return fmt.Errorf("VFS %q is already registered with a different implementation", name)
+		}
+		return nil
+	}
+	cName := C.CString(name) // referenced by the VFS, never freed
+	if C.sqlite3_vfs_find(cName) != nil {
+		C.free(unsafe.Pointer(cName))
+		return fmt.Errorf("VFS %q is already registered with SQLite", name)
+	}
+	handle := cgo.NewHandle(vfs)
+	if rc := C.bp_register_vfs(cName, C.uintptr_t(handle)); rc != 0 {
+		handle.Delete()
+		C.free(unsafe.Pointer(cName))
+		return fmt.Errorf("sqlite3_vfs_register returned %d", int(rc))
+	}
+	vfsImpl[name] = vfs
+	return nil
+}
+
+// noFollowVFS is the name of the VFS WithNoFollow opens files with.
+const noFollowVFS = "sqlitebp-nofollow"
+
+var (
+	noFollowOnce sync.Once
+	noFollowErr  error
+)
+
+// registerNoFollowVFS registers noFollowVFS with SQLite, once.
+func registerNoFollowVFS() error {
+	noFollowOnce.Do(func() {
+		cName := C.CString(noFollowVFS) // referenced by the VFS, never freed
+		if rc := C.bp_register_nofollow_vfs(cName); rc != 0 {
+			noFollowErr = fmt.Errorf("sqlite3_vfs_register returned %d", int(rc))
+		}
+	})
+	return noFollowErr
+}
+
+// sameVFS reports whether a and b are the same implementation. Values of types that
+// cannot be compared are never the same.
+func sameVFS(a, b VFS) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// defaultVFS returns the name of the VFS SQLite opens files with by default, e.g. "unix".
+func defaultVFS() string {
+	return C.GoString(C.sqlite3_vfs_find(nil).zName)
+}
+
+// vfsError converts err to a result code, rc unless err wraps a sqlite3.Error.
+func vfsError(err error, rc int) C.int {
+	if err == nil {
+		return 0
+	}
+	var se sqlite3.Error
+	if errors.As(err, &se) && se.Code != 0 {
+		if se.ExtendedCode != 0 {
+			return C.int(se.ExtendedCode)
+		}
+		return C.int(se.Code)
+	}
+	return C.int(rc)
+}
+
+func vfsFile(handle C.uintptr_t) VFSFile {
+	return cgo.Handle(handle).Value().(VFSFile)
+}
+
+//export goVFSOpen
+func goVFSOpen(handle C.uintptr_t, name *C.char, flags C.int, outFlags *C.int, file *C.uintptr_t) C.int {
+	var goName string
+	if name != nil {
+		goName = C.GoString(name)
+	}
+	f, out, err := cgo.Handle(handle).Value().(VFS).Open(goName, int(flags))
+	if err != nil {
+		return vfsError(err, vfsCantOpen)
+	}
+	*outFlags = C.int(out)
+	*file = C.uintptr_t(cgo.NewHandle(f))
+	return 0
+}
+
+//export goVFSDelete
+func goVFSDelete(handle C.uintptr_t, name *C.char, dirSync C.int) C.int {
+	err := cgo.Handle(handle).Value().(VFS).Delete(C.GoString(name), dirSync != 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return vfsIOErrDeleteNoEnt
+	}
+	return vfsError(err, vfsIOErrDelete)
+}
+
+//export goVFSAccess
+func goVFSAccess(handle C.uintptr_t, name *C.char, flags C.int, out *C.int) C.int {
+	ok, err := cgo.Handle(handle).Value().(VFS).Access(C.GoString(name), int(flags))
+	if err != nil {
+		return vfsError(err, vfsIOErrAccess)
+	}
+	*out = 0
+	if ok {
+		*out = 1
+	}
+	return 0
+}
+
+//export goVFSFullPathname
+func goVFSFullPathname(handle C.uintptr_t, name *C.char, n C.int, out *C.char) C.int {
+	full := cgo.Handle(handle).Value().(VFS).FullPathname(C.GoString(name))
+	if len(full) >= int(n) {
+		return vfsCantOpen
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(out)), int(n))
+	buf[copy(buf, full)] = 0
+	return 0
+}
+
+//export goFileClose
+func goFileClose(handle C.uintptr_t) C.int {
+	h := cgo.Handle(handle)
+	err := h.Value().(VFSFile).Close()
+	h.Delete()
+	return vfsError(err, vfsIOErrClose)
+}
+
+//export goFileRead
+func goFileRead(handle C.uintptr_t, p unsafe.Pointer, n C.int, off C.longlong) C.int {
+	buf := unsafe.Slice((*byte)(p), int(n))
+	read, err := vfsFile(handle).ReadAt(buf, int64(off))
+	if read == len(buf) {
+		return 0
+	}
+	if err != nil && err != io.EOF {
+		return vfsError(err, vfsIOErrRead)
+	}
+	// SQLite requires the unread tail to be zeroed.
+	clear(buf[read:])
+	return vfsIOErrShortRead
+}
+
+//export goFileWrite
+func goFileWrite(handle C.uintptr_t, p unsafe.Pointer, n C.int, off C.longlong) C.int {
+	written, err := vfsFile(handle).WriteAt(unsafe.Slice((*byte)(p), int(n)), int64(off))
+	if err == nil && written < int(n) {
+		err = io.ErrShortWrite
+	}
+	return vfsError(err, vfsIOErrWrite)
+}
+
+//export goFileTruncate
+func goFileTruncate(handle C.uintptr_t, size C.longlong) C.int {
+	return vfsError(vfsFile(handle).Truncate(int64(size)), vfsIOErrTruncate)
+}
+
+//export goFileSync
+func goFileSync(handle C.uintptr_t, flags C.int) C.int {
+	return vfsError(vfsFile(handle).Sync(int(flags)), vfsIOErrFsync)
+}
+
+//export goFileSize
+func goFileSize(handle C.uintptr_t, out *C.longlong) C.int {
+	size, err := vfsFile(handle).FileSize()
+	if err != nil {
+		return vfsError(err, vfsIOErrFstat)
+	}
+	*out = C.longlong(size)
+	return 0
+}
+
+//export goFileLock
+func goFileLock(handle C.uintptr_t, level C.int) C.int {
+	return vfsError(vfsFile(handle).Lock(int(level)), vfsBusy)
+}
+
+//export goFileUnlock
+func goFileUnlock(handle C.uintptr_t, level C.int) C.int {
+	return vfsError(vfsFile(handle).Unlock(int(level)), vfsIOErrUnlock)
+}
+
+//export goFileCheckReservedLock
+func goFileCheckReservedLock(handle C.uintptr_t, out *C.int) C.int {
+	reserved, err := vfsFile(handle).CheckReservedLock()
+	if err != nil {
+		return vfsError(err, vfsIOErrCheckReserved)
+	}
+	*out = 0
+	if reserved {
+		*out = 1
+	}
+	return 0
+}
+
+//export goFileSectorSize
+func goFileSectorSize(handle C.uintptr_t) C.int {
+	return C.int(vfsFile(handle).SectorSize())
+}
+
+//export goFileDeviceCharacteristics
+func goFileDeviceCharacteristics(handle C.uintptr_t) C.int {
+	return C.int(vfsFile(handle).DeviceCharacteristics())
+}
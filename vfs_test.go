@@ -0,0 +1,180 @@
+package sqlitebp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+)
+
+// memVFS keeps files in a Go map. Locks are not implemented, so it is only safe with a
+// single connection.
+type memVFS struct {
+	mu    sync.Mutex
+	files map[string]*memFileData
+}
+
+type memFileData struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+type memFile struct {
+	vfs           *memVFS
+	name          string
+	data          *memFileData
+	deleteOnClose bool
+}
+
+func newMemVFS() *memVFS {
+	return &memVFS{files: make(map[string]*memFileData)}
+}
+
+func (v *memVFS) Open(name string, flags int) (VFSFile, int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	data, ok := v.files[name]
+	if !ok {
+		if flags&0x4 == 0 { // SQLITE_OPEN_CREATE
+			return nil, 0, fs.ErrNotExist
+		}
+		data = &memFileData{}
+		if name != "" {
+			v.files[name] = data
+		}
+	}
+	return &memFile{vfs: v, name: name, data: data, deleteOnClose: flags&0x8 != 0}, flags, nil
+}
+
+func (v *memVFS) Delete(name string, dirSync bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.files[name]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(v.files, name)
+	return nil
+}
+
+func (v *memVFS) Access(name string, flags int) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.files[name]
+	return ok, nil
+}
+
+func (v *memVFS) FullPathname(name string) string { return name }
+
+func (f *memFile) Close() error {
+	if f.deleteOnClose && f.name != "" {
+		return f.vfs.Delete(f.name, false)
+	}
+	return nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	if off >= int64(len(f.data.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(f.data.data)) {
+		f.data.data = append(f.data.data, make([]byte, end-int64(len(f.data.data)))...)
+	}
+	return copy(f.data.data[off:], p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	if size < int64(len(f.data.data)) {
+		f.data.data = f.data.data[:size]
+	}
+	return nil
+}
+
+func (f *memFile) FileSize() (int64, error) {
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+	return int64(len(f.data.data)), nil
+}
+
+func (f *memFile) Sync(flags int) error             { return nil }
+func (f *memFile) Lock(level int) error             { return nil }
+func (f *memFile) Unlock(level int) error           { return nil }
+func (f *memFile) CheckReservedLock() (bool, error) { return false, nil }
+func (f *memFile) SectorSize() int                  { return 0 }
+func (f *memFile) DeviceCharacteristics() int       { return 0 }
+
+func TestWithVFSImplementation(t *testing.T) {
+	dir := t.TempDir()
+	filename := dir + "/test.db"
+	vfs := newMemVFS()
+	name := fmt.Sprintf("bp-mem-%p", vfs)
+
+	db, err := OpenReadWriteCreate(filename, WithVFSImplementation(name, vfs), WithMaxOpenConns(1))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.Exec("INSERT INTO t (v) VALUES (?)", fmt.Sprintf("row %d", i)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "delete" {
+		t.Fatalf("journal_mode = %q, %v; want delete", mode, err)
+	}
+	db.Close()
+
+	if _, err := os.Stat(filename); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("database written to disk: %v", err)
+	}
+	if data, ok := vfs.files[filename]; !ok || len(data.data) == 0 {
+		t.Fatalf("database not stored in the VFS: %v", vfs.files)
+	}
+
+	// The second open, from a Config, reuses the registration and reads the pages back.
+	db, err = OpenWithConfig(filename, ModeReadWrite, Config{VFSName: name, VFS: vfs, MaxOpenConns: 1})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	var count int
+	var last string
+	if err := db.QueryRow("SELECT count(*), max(v) FROM t").Scan(&count, &last); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if count != 100 || last != "row 99" {
+		t.Fatalf("got %d rows, max %q", count, last)
+	}
+
+	if _, err := OpenReadWrite(filename, WithVFSImplementation(name, newMemVFS())); !errors.Is(err, ErrOpenFailed) {
+		t.Fatalf("expected ErrOpenFailed for a different implementation, got %v", err)
+	}
+	if _, err := OpenReadWrite(filename, WithVFSImplementation(defaultVFS(), vfs)); !errors.Is(err, ErrOpenFailed) {
+		t.Fatalf("expected ErrOpenFailed for a built-in VFS name, got %v", err)
+	}
+	if _, err := OpenReadWrite(filename, WithVFSImplementation(name, vfs), WithJournalMode("WAL")); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for WAL, got %v", err)
+	}
+	if _, err := OpenMemory(WithVFSImplementation(name, vfs)); !errors.Is(err, ErrInvalidConfigOption) {
+		t.Fatalf("expected ErrInvalidConfigOption for an in-memory database, got %v", err)
+	}
+}
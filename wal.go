@@ -0,0 +1,50 @@
+package sqlitebp
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// SQLite result codes used with sqlite3_log.
+const (
+	sqliteNotice  = 27 // SQLITE_NOTICE
+	sqliteWarning = 28 // SQLITE_WARNING
+)
+
+// walHook is the WAL hook installed on every connection of a handle opened with
+// WithWALHook or WithWALSizeLimit.
+type walHook struct {
+	fn       func(dbName string, pages int) int // WithWALHook, nil if unset
+	limit    int64                              // WithWALSizeLimit in bytes, 0 if unset
+	pageSize atomic.Int64                       // of the main database, read by connect
+}
+
+// commit runs after each commit in WAL mode. It returns the result of the WithWALHook
+// callback and whether the main database's WAL has outgrown the size limit, in which
+// case the bridge runs a TRUNCATE checkpoint in place of the automatic one.
+func (h *walHook) commit(dbName string, pages int) (rc int, over bool) {
+	if h.fn != nil {
+		if rc = h.fn(dbName, pages); rc != 0 {
+			return rc, false
+		}
+	}
+	pageSize := h.pageSize.Load()
+	if h.limit == 0 || dbName != "main" || pageSize == 0 {
+		return 0, false
+	}
+	// A WAL is a 32 byte header followed by frames of a 24 byte header and one page.
+	return 0, 32+int64(pages)*(24+pageSize) > h.limit
+}
+
+// truncated logs the result of a TRUNCATE checkpoint forced by the size limit.
+func (h *walHook) truncated(pages, rc int) {
+	if rc == 0 {
+		sqliteLog(sqliteNotice, fmt.Sprintf("sqlitebp: WAL of %d pages exceeded %d bytes, truncated it", pages, h.limit))
+		return
+	}
+	// Typically SQLITE_BUSY: a reader outlasted the busy timeout. The next commit retries.
+	sqliteLog(sqliteWarning, fmt.Sprintf("sqlitebp: WAL of %d pages exceeded %d bytes, truncating it failed: %s",
+		pages, h.limit, sqlite3.ErrNo(rc)))
+}